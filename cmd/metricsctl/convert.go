@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/benderr/metrics/internal/server/repository/filestorage"
+)
+
+// convertCommand rewrites file storage snapshot in another format, format of source is detected by its header.
+// Snapshots of all formats are accepted by import, so conversion isn't required before importing.
+func convertCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	format := fs.String("format", filestorage.FormatBinary, "format of destination: json, binary or binary-gzip")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return errors.New("convert requires source and destination files")
+	}
+
+	metrics, err := filestorage.ReadSnapshot(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("read source: %w", err)
+	}
+
+	if err := filestorage.WriteSnapshot(fs.Arg(1), *format, metrics); err != nil {
+		return fmt.Errorf("write destination: %w", err)
+	}
+
+	fmt.Printf("converted %d metrics (%s)\n", len(metrics), *format)
+	return nil
+}
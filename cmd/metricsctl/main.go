@@ -0,0 +1,122 @@
+// Package metricsctl is a command line tool to manage metrics server.
+//
+// Commands:
+//
+// import - loads metrics from file storage snapshot (json or binary) or ndjson file of /export to server with POST /import.
+// Mode merge adds metrics to stored ones, mode replace removes stored metrics first.
+//
+//	cmd/metricsctl/metricsctl -a http://localhost:8080 -k secret import -mode replace /tmp/metrics-db.json
+//
+// File "-" means standard input. File is sent as one request and is limited to 64 MiB, larger storages are copied with migrate.
+//
+// migrate - copies all metrics from one storage to another, e.g. from file snapshot to postgres.
+// Storage is a postgres dsn (postgres://...), sqlite dsn (sqlite:///path/to/file.db) or a path of file storage.
+// Counters are copied as absolute values, metrics existing in destination are overwritten.
+// Source is only read: database migrations aren't applied to it, missing or damaged snapshot fails migration.
+//
+//	cmd/metricsctl/metricsctl migrate -from /tmp/metrics-db.json -to postgres://localhost:5432/metrics
+//
+// convert - rewrites file storage snapshot in another format (json, binary or binary-gzip).
+// Format of source file is detected by its header. Snapshot of any format can be loaded with import.
+//
+//	cmd/metricsctl/metricsctl convert -format binary-gzip /tmp/metrics-db.json /tmp/metrics-db.bin
+//
+// Common flags:
+//
+// -a - server url
+//
+// -k - secret key for signing request body
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/benderr/metrics/pkg/client"
+)
+
+const usage = `Usage: metricsctl [-a address] [-k key] <command> [flags]
+
+Commands:
+  import [-mode merge|replace] <file>                    load snapshot or ndjson file to server
+  migrate -from <storage> -to <storage> [-batch n]      copy metrics between storages
+  convert [-format json|binary|binary-gzip] <src> <dst>  convert file storage snapshot
+`
+
+// command runs subcommand with its arguments
+type command func(ctx context.Context, args []string) error
+
+var commands = map[string]command{
+	"import":  importCommand,
+	"migrate": migrateCommand,
+	"convert": convertCommand,
+}
+
+// flags are parsed with own set, because server config registers its flags in flag.CommandLine
+var (
+	flags  = flag.NewFlagSet("metricsctl", flag.ExitOnError)
+	server = flags.String("a", "http://localhost:8080", "server url")
+	key    = flags.String("k", "", "sha256 based secret key")
+)
+
+func main() {
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := cmd(ctx, flags.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "metricsctl:", err)
+		stop()
+		os.Exit(1)
+	}
+}
+
+// newClient returns client of server from common flags
+func newClient() *client.Client {
+	return client.New(*server).SetSecret(*key).SetRetries(3)
+}
+
+func importCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	mode := fs.String("mode", "merge", "merge adds metrics to stored ones, replace removes stored metrics first")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("import requires one file argument")
+	}
+
+	var r io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	count, err := newClient().Import(ctx, r, *mode)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %d metrics (%s)\n", count, *mode)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/benderr/metrics/internal/server/config"
+	"github.com/benderr/metrics/internal/server/repository/sqlitestorage"
+	"github.com/benderr/metrics/internal/server/repository/storage"
+	"github.com/benderr/metrics/pkg/logger"
+)
+
+// migrateStoreInterval disables snapshot on every update of file storage, the file is saved on close after copying
+const migrateStoreInterval = 3600
+
+func migrateCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "", "source storage: postgres dsn or file path")
+	to := fs.String("to", "", "destination storage: postgres dsn or file path")
+	batch := fs.Int("batch", 500, "count of metrics in one update")
+	fs.Parse(args)
+
+	if *from == "" || *to == "" {
+		return errors.New("migrate requires -from and -to storages")
+	}
+
+	l, sync := logger.New()
+	defer sync()
+
+	// источник только читается: миграции не применяются, поврежденный снимок является ошибкой
+	src, err := storage.Open(ctx, storageConfig(*from), l)
+	if err != nil {
+		return fmt.Errorf("open source: %w", err)
+	}
+	defer src.Close(context.Background())
+
+	dst, err := storage.New(ctx, storageConfig(*to), l)
+	if err != nil {
+		return fmt.Errorf("open destination: %w", err)
+	}
+
+	count, err := storage.Copy(ctx, dst, src, *batch)
+	if err != nil {
+		dst.Close(context.Background())
+		return err
+	}
+
+	// файловое хранилище держит метрики в памяти, снимок сохраняется при закрытии
+	if err := dst.Close(ctx); err != nil {
+		return fmt.Errorf("close destination: %w", err)
+	}
+
+	fmt.Printf("migrated %d metrics\n", count)
+	return nil
+}
+
+// storageConfig returns config of storage.New, storage is postgres or sqlite dsn or file path (optionally with file:// scheme)
+func storageConfig(s string) *config.Config {
+	if strings.HasPrefix(s, "postgres://") || strings.HasPrefix(s, "postgresql://") || strings.HasPrefix(s, sqlitestorage.Scheme) {
+		return &config.Config{DatabaseDsn: s}
+	}
+	return &config.Config{
+		FileStoragePath: strings.TrimPrefix(s, "file://"),
+		StoreInterval:   migrateStoreInterval,
+		Restore:         true,
+	}
+}
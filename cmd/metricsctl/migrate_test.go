@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/internal/server/repository/filestorage"
+	"github.com/benderr/metrics/internal/server/repository/sqlitestorage"
+	"github.com/benderr/metrics/internal/server/repository/storage"
+)
+
+func TestMigrateSource(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dst.json")
+
+	t.Run("Corrupt snapshot", func(t *testing.T) {
+		src := filepath.Join(dir, "corrupt.json")
+		require.NoError(t, os.WriteFile(src, []byte(`{"id":"poll","type":"counter","delta":`), 0666))
+
+		assert.Error(t, migrateCommand(ctx, []string{"-from", src, "-to", dst}))
+		assert.NoFileExists(t, dst)
+	})
+
+	t.Run("Missing snapshot", func(t *testing.T) {
+		assert.Error(t, migrateCommand(ctx, []string{"-from", filepath.Join(dir, "missing.json"), "-to", dst}))
+		assert.NoFileExists(t, dst)
+	})
+
+	t.Run("SQLite source isn't migrated", func(t *testing.T) {
+		src := filepath.Join(dir, "src.db")
+		assert.Error(t, migrateCommand(ctx, []string{"-from", sqlitestorage.Scheme + src, "-to", dst}))
+		assert.NoFileExists(t, src)
+	})
+
+	t.Run("SQLite source", func(t *testing.T) {
+		src := sqlitestorage.Scheme + filepath.Join(dir, "metrics.db")
+		repo, err := storage.New(ctx, storageConfig(src), nopLogger{})
+		require.NoError(t, err)
+		delta := int64(5)
+		_, err = repo.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+		require.NoError(t, err)
+		require.NoError(t, repo.Close(ctx))
+
+		require.NoError(t, migrateCommand(ctx, []string{"-from", src, "-to", dst}))
+
+		metrics, err := filestorage.ReadSnapshot(dst)
+		require.NoError(t, err)
+		require.Len(t, metrics, 1)
+		assert.Equal(t, int64(5), *metrics[0].Delta)
+	})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Errorln(args ...interface{}) {}
@@ -1,10 +1,18 @@
 // Package server start server with endpoints for store metrics.
 //
-// Server collect metrics with two types: counter, gauge.
-// Counter metric adds a value to an existing metric.
+// Server collect metrics with five types: counter, gauge, histogram, summary, set.
+// Counter metric adds a value to an existing metric, with operation "set" (op=set query parameter or "op" field) it overwrites the value.
 // Gauge metric overwrites existing value with new value.
+// Histogram metric merges bucket counts, sum and count with existing histogram (bucket bounds must match).
+// Summary metric merges DDSketch quantile sketches (relative accuracy must match),
+// quantiles are available via /value/summary/{name}?q=0.99 or "quantiles" field of /value/.
+// Set metric merges HyperLogLog sketches (precision must match) and reports the number of distinct values,
+// a single value can be added via /update/set/{name}/{value}.
 //
-// The server work in 3 mode (see config):
+// Changes of metrics can be streamed with Server-Sent Events: /stream?id={name}&prefix={prefix}.
+// All metrics can be exported as file: /export?format=csv|ndjson, and loaded back with /import?mode=merge|replace.
+//
+// The server work in 4 mode (see config):
 //
 // In-memory mode (default mode). All metrics stored in-memory (key-value storage).
 //
@@ -14,10 +22,25 @@
 //
 //	cmd/server/server -f "/tmp/example.json"
 //
+// Changes between snapshots can be kept in write-ahead log /tmp/example.json.wal
+//
+//	cmd/server/server -f "/tmp/example.json" -wal -wal-fsync always
+//
 // Or database (postgresql)
 //
 //	cmd/server/server -d 'postgres://host:port/db'
 //
+// Or embedded database (sqlite), durable storage of single node without database server
+//
+//	cmd/server/server -d 'sqlite:///var/lib/metrics.db'
+//
+// Database schema is migrated on start, migrations can be applied (or rolled back to version) without starting server
+//
+//	cmd/server/server -d 'postgres://host:port/db' -migrate-only -migrate-to 2
+//
+// Connection pool is configured with -db-max-conns, -db-max-conn-lifetime and other -db-* flags (or DB_* env),
+// its statistics are available via /api/v1/admin/db.
+//
 // See other flags
 //
 //	cmd/server/server --help
@@ -26,8 +49,6 @@ package main
 import (
 	"context"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
-
 	"github.com/benderr/metrics/internal/server/app"
 	"github.com/benderr/metrics/internal/server/config"
 	"github.com/benderr/metrics/pkg/logger"
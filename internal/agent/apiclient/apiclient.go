@@ -2,11 +2,13 @@ package apiclient
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 
 	"github.com/benderr/metrics/pkg/logger"
+	"github.com/benderr/metrics/pkg/problem"
 	"github.com/benderr/metrics/pkg/sign"
 )
 
@@ -96,3 +98,42 @@ func (a *Client) getRequestBody(r *resty.Request) ([]byte, bool) {
 	}
 	return []byte{}, false
 }
+
+// CheckResponse возвращает ошибку неуспешного запроса,
+// если сервер ответил application/problem+json, то ошибкой будет *problem.Problem
+func CheckResponse(resp *resty.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	if !resp.IsError() {
+		return nil
+	}
+	if p, ok := problem.Parse(resp.Header().Get("Content-Type"), resp.Body()); ok {
+		return p
+	}
+	return fmt.Errorf("unexpected status %d: %s", resp.StatusCode(), resp.String())
+}
+
+// LogError пишет ошибку отправки в лог, для problem логируется каждый невалидный элемент пачки
+func (a *Client) LogError(msg string, err error) {
+	var p *problem.Problem
+	if !errors.As(err, &p) {
+		a.logger.Errorln(msg, err)
+		return
+	}
+
+	a.logger.Infow(msg,
+		"status", p.Status,
+		"code", p.Code,
+		"detail", p.Detail,
+		"metric", p.MetricID,
+	)
+	for _, item := range p.Errors {
+		a.logger.Infow(msg,
+			"index", item.Index,
+			"metric", item.MetricID,
+			"code", item.Code,
+			"detail", item.Detail,
+		)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"github.com/benderr/metrics/internal/agent/stats"
+	"github.com/benderr/metrics/pkg/histogram"
 )
 
 type Report struct {
@@ -13,10 +14,11 @@ type Report struct {
 }
 
 type MetricItem struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID        string               `json:"id"`                  // имя метрики
+	MType     string               `json:"type"`                // параметр, принимающий значение gauge, counter или histogram
+	Delta     *int64               `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Value     *float64             `json:"value,omitempty"`     // значение метрики в случае передачи gauge
+	Histogram *histogram.Histogram `json:"histogram,omitempty"` // бакеты метрики в случае передачи histogram
 }
 
 func New() *Report {
@@ -68,3 +70,59 @@ func (r *Report) GetList() []MetricItem {
 	}
 	return metrics
 }
+
+// Observe добавляет значение в гистограмму, bounds используются только при создании гистограммы
+func (r *Report) Observe(name string, bounds []float64, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item, ok := r.MetricItems[name]
+	if !ok || item.Histogram == nil {
+		item = MetricItem{
+			ID:        name,
+			MType:     "histogram",
+			Histogram: histogram.New(bounds...),
+		}
+		r.MetricItems[name] = item
+	}
+	item.Histogram.Observe(value)
+}
+
+// Flush возвращает накопленные метрики и очищает отчет,
+// так counter и histogram отправляются на сервер только приращением с прошлой отправки.
+//
+// Неотправленные метрики можно вернуть в отчет через Restore.
+func (r *Report) Flush() []MetricItem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	metrics := make([]MetricItem, 0, len(r.MetricItems))
+	for _, value := range r.MetricItems {
+		metrics = append(metrics, value)
+	}
+	r.MetricItems = make(map[string]MetricItem)
+	return metrics
+}
+
+// Restore возвращает в отчет метрики, которые не удалось отправить:
+// counter и histogram складываются с накопленными после Flush, gauge восстанавливается, если не был обновлен.
+func (r *Report) Restore(metrics []MetricItem) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range metrics {
+		exist, ok := r.MetricItems[m.ID]
+		if !ok {
+			r.MetricItems[m.ID] = m
+			continue
+		}
+
+		switch {
+		case m.MType == "counter" && exist.MType == "counter" && m.Delta != nil:
+			r.updateCounter(m.ID, *m.Delta)
+		case m.MType == "histogram" && exist.Histogram != nil && m.Histogram != nil:
+			// при несовпадении бакетов старые наблюдения отбрасываются
+			exist.Histogram.Merge(m.Histogram)
+		}
+	}
+}
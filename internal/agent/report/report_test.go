@@ -35,3 +35,37 @@ func TestReport(t *testing.T) {
 		assert.Equal(t, len(res), 2)
 	})
 }
+
+func TestReportFlush(t *testing.T) {
+	r := report.New()
+
+	r.Update([]stats.Item{
+		{Name: "poll", Type: "counter", Delta: 2},
+		{Name: "load", Type: "gauge", Value: 1},
+	})
+	r.Observe("latency", []float64{1, 10}, 5)
+
+	sent := r.Flush()
+	assert.Len(t, sent, 3)
+	assert.Empty(t, r.GetList())
+
+	r.Update([]stats.Item{
+		{Name: "poll", Type: "counter", Delta: 3},
+		{Name: "load", Type: "gauge", Value: 2},
+	})
+	r.Observe("latency", []float64{1, 10}, 50)
+
+	r.Restore(sent)
+
+	for _, m := range r.GetList() {
+		switch m.ID {
+		case "poll":
+			assert.Equal(t, int64(5), *m.Delta)
+		case "load":
+			assert.Equal(t, 2.0, *m.Value)
+		case "latency":
+			assert.Equal(t, uint64(2), m.Histogram.Count)
+			assert.Equal(t, []uint64{0, 1, 1}, m.Histogram.Counts)
+		}
+	}
+}
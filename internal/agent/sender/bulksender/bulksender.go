@@ -3,6 +3,8 @@ package bulksender
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 
 	"github.com/benderr/metrics/internal/agent/apiclient"
@@ -42,17 +44,26 @@ func (b *BulkSender) Send(metrics []report.MetricItem) error {
 		return err
 	}
 
+	key, err := newIdempotencyKey()
+
+	if err != nil {
+		return err
+	}
+
+	// ключ один на все повторы запроса, поэтому сервер не применит пачку дважды,
+	// если ответ на успешный запрос не дошел до агента
 	req := b.client.
 		R().
 		SetHeader("Content-Type", "application/json; charset=utf-8").
 		SetHeader("Content-Encoding", "gzip").
+		SetHeader("Idempotency-Key", key).
 		SetBody(body)
 
-	_, err = req.
-		Post("/updates/")
+	err = apiclient.CheckResponse(req.
+		Post("/updates/"))
 
 	if err != nil {
-		b.log.Errorln("bulk send error", err)
+		b.client.LogError("bulk send error", err)
 	} else {
 		b.log.Infoln("sent", string(bufBytes))
 	}
@@ -60,6 +71,15 @@ func (b *BulkSender) Send(metrics []report.MetricItem) error {
 	return err
 }
 
+// newIdempotencyKey returns random key of batch
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 func compress(s []byte) ([]byte, error) {
 	var buf bytes.Buffer
 
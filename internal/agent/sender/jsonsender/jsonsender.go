@@ -23,11 +23,14 @@ type JSONSender struct {
 
 func (h *JSONSender) Send(metrics []report.MetricItem) error {
 	allErrors := worker.Run(h.rateLimit, metrics, func(mi *report.MetricItem) error {
-		_, e := h.client.R().
+		e := apiclient.CheckResponse(h.client.R().
 			SetHeader("Content-Type", "application/json").
 			SetHeader("Accept-Encoding", "gzip").
 			SetBody(mi).
-			Post("/update")
+			Post("/update"))
+		if e != nil {
+			h.client.LogError("json send error", e)
+		}
 		return e
 	})
 	return errors.Join(allErrors...)
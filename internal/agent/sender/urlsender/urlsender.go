@@ -24,18 +24,21 @@ type URLSender struct {
 
 func (h *URLSender) Send(metrics []report.MetricItem) error {
 	allErrors := worker.Run(h.rateLimit, metrics, func(mi *report.MetricItem) error {
+		var url string
 		switch mi.MType {
 		case "counter":
-			url := fmt.Sprintf("/%v/%v/%v/%v", "update", "counter", mi.ID, *mi.Delta)
-			_, err := h.client.R().Post(url)
-			return err
-
+			url = fmt.Sprintf("/%v/%v/%v/%v", "update", "counter", mi.ID, *mi.Delta)
 		case "gauge":
-			url := fmt.Sprintf("/%v/%v/%v/%v", "update", "gauge", mi.ID, *mi.Value)
-			_, err := h.client.R().Post(url)
-			return err
+			url = fmt.Sprintf("/%v/%v/%v/%v", "update", "gauge", mi.ID, *mi.Value)
+		default:
+			return nil
+		}
+
+		err := apiclient.CheckResponse(h.client.R().Post(url))
+		if err != nil {
+			h.client.LogError("url send error", err)
 		}
-		return nil
+		return err
 	})
 	return errors.Join(allErrors...)
 }
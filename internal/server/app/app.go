@@ -3,10 +3,10 @@ package app
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -15,11 +15,15 @@ import (
 	"github.com/benderr/metrics/internal/server/handlers"
 	"github.com/benderr/metrics/internal/server/middleware/mlogger"
 	"github.com/benderr/metrics/internal/server/middleware/sign"
+	"github.com/benderr/metrics/internal/server/repository"
 	"github.com/benderr/metrics/internal/server/repository/storage"
 	"github.com/benderr/metrics/pkg/gziper"
 	"github.com/benderr/metrics/pkg/logger"
 )
 
+// shutdownTimeout limits waiting for in-flight requests and for closing of storage
+const shutdownTimeout = 30 * time.Second
+
 // App consisting only one method Run to start server
 type App struct {
 	config *config.Config
@@ -35,12 +39,28 @@ func New(config *config.Config, log logger.Logger) *App {
 }
 
 // Run create storage which depends on config and listens on the TCP network address addr and then calls
+//
+// On SIGINT, SIGQUIT or SIGTERM server stops accepting connections and waits for in-flight requests,
+// then storage is closed: background jobs are stopped, data is saved and connections are released.
 func (a *App) Run(ctx context.Context) error {
+	if a.config.MigrateOnly {
+		if err := storage.Migrate(ctx, a.config); err != nil {
+			return err
+		}
+		a.log.Infoln("migrations applied")
+		return nil
+	}
 
 	repo, err := storage.New(ctx, a.config, a.log)
 	if err != nil {
 		return err
 	}
+	// хранилище закрывается после остановки сервера, когда обработаны все запросы
+	defer a.closeStorage(repo)
+
+	if err := repo.Start(ctx); err != nil {
+		return err
+	}
 
 	h := handlers.New(repo, a.log, a.config.SecretKey)
 	mwlog := mlogger.New(a.log)
@@ -48,6 +68,8 @@ func (a *App) Run(ctx context.Context) error {
 	mwsign := sign.New(a.config.SecretKey, a.log)
 
 	chiRouter := chi.NewRouter()
+	// размер импорта ограничивается до того, как миддлвар подписи прочитает тело целиком
+	chiRouter.Use(handlers.LimitImport)
 	chiRouter.Use(mwsign.CheckSign)
 	chiRouter.Use(mwlog.Middleware)
 	chiRouter.Use(mwgzip.TransformWriter)
@@ -58,6 +80,7 @@ func (a *App) Run(ctx context.Context) error {
 	h.AddHandlers(chiRouter)
 
 	srv := http.Server{Addr: string(a.config.Server), Handler: chiRouter}
+	srv.RegisterOnShutdown(h.Close)
 
 	ctxStop, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
 	defer stop()
@@ -67,8 +90,10 @@ func (a *App) Run(ctx context.Context) error {
 	go func() {
 		<-ctxStop.Done()
 
-		if err := srv.Shutdown(context.Background()); err != nil {
-			log.Fatal("shutdown error ", err)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			a.log.Errorln("shutdown error", err)
 		}
 
 		close(idleConnsClosed)
@@ -86,5 +111,16 @@ func (a *App) Run(ctx context.Context) error {
 
 	<-idleConnsClosed
 	return nil
+}
 
+// closeStorage stops background jobs of storage, saves data and releases connections
+func (a *App) closeStorage(repo repository.Storage) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := repo.Close(ctx); err != nil {
+		a.log.Errorln("storage close error", err)
+		return
+	}
+	a.log.Infoln("storage closed")
 }
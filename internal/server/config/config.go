@@ -6,6 +6,7 @@ import (
 	"flag"
 	"os"
 	"regexp"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 )
@@ -32,7 +33,9 @@ func (address *ServerAddress) Set(flagValue string) error {
 }
 
 const (
-	defaultStoreInterval int = 300
+	defaultStoreInterval       int   = 300
+	defaultSnapshotGenerations int   = 2
+	defaultWALMaxSize          int64 = 64 << 20
 )
 
 type Config struct {
@@ -45,17 +48,40 @@ type Config struct {
 	CryptoKey       string        `env:"CRYPTO_KEY"`
 	PublicKey       string        `env:"PUBLIC_KEY"`
 	ConfigFile      string        `env:"CONFIG"`
+	MigrateOnly     bool          `env:"MIGRATE_ONLY"` // применить миграции базы данных и завершить работу
+	MigrateTo       int           `env:"MIGRATE_TO"`   // версия схемы для MigrateOnly, -1 - последняя
+
+	// настройки пула соединений с базой данных, нулевые значения оставляют параметры DSN или значения pgxpool по умолчанию
+	DBMaxConns          int           `env:"DB_MAX_CONNS"`
+	DBMinConns          int           `env:"DB_MIN_CONNS"`
+	DBMaxConnLifetime   time.Duration `env:"DB_MAX_CONN_LIFETIME"`
+	DBMaxConnIdleTime   time.Duration `env:"DB_MAX_CONN_IDLE_TIME"`
+	DBHealthCheckPeriod time.Duration `env:"DB_HEALTH_CHECK_PERIOD"`
+	DBStatementCache    int           `env:"DB_STATEMENT_CACHE"` // размер кеша подготовленных запросов, -1 отключает кеш
+
+	SnapshotGenerations int    `env:"SNAPSHOT_GENERATIONS"` // количество хранимых предыдущих снимков файлового хранилища
+	SnapshotFormat      string `env:"SNAPSHOT_FORMAT"`      // json, binary или binary-gzip
+
+	// журнал изменений файлового хранилища
+	FileWAL    bool   `env:"FILE_WAL"`
+	WALFsync   string `env:"WAL_FSYNC"`    // always, interval или never
+	WALMaxSize int64  `env:"WAL_MAX_SIZE"` // размер журнала в байтах, после которого он сворачивается в снимок
 }
 
 var config = Config{
-	Server:          ":8080",
-	StoreInterval:   defaultStoreInterval,
-	FileStoragePath: "/tmp/metrics-db.json",
-	Restore:         true,
-	DatabaseDsn:     "",
-	SecretKey:       "",
-	CryptoKey:       "",
-	ConfigFile:      "",
+	Server:              ":8080",
+	StoreInterval:       defaultStoreInterval,
+	FileStoragePath:     "/tmp/metrics-db.json",
+	Restore:             true,
+	DatabaseDsn:         "",
+	SecretKey:           "",
+	CryptoKey:           "",
+	ConfigFile:          "",
+	MigrateTo:           -1,
+	SnapshotGenerations: defaultSnapshotGenerations,
+	SnapshotFormat:      "json",
+	WALFsync:            "always",
+	WALMaxSize:          defaultWALMaxSize,
 }
 
 func init() {
@@ -65,10 +91,23 @@ func init() {
 	flag.IntVar(&config.StoreInterval, "i", defaultStoreInterval, "report save interval (seconds)")
 	flag.StringVar(&config.FileStoragePath, "f", "/tmp/metrics-db.json", "report store file name")
 	flag.BoolVar(&config.Restore, "r", true, "restore report from file")
-	flag.StringVar(&config.DatabaseDsn, "d", "", "connection string for postgre")
+	flag.StringVar(&config.DatabaseDsn, "d", "", "connection string for postgre or sqlite:///path/to/file.db")
 	flag.StringVar(&config.SecretKey, "k", "", "sha256 based secret key")
 	flag.StringVar(&config.CryptoKey, "crypto-key", "", "private key file for TLS")
 	flag.StringVar(&config.PublicKey, "public-key", "", "public cert file for TLS")
+	flag.BoolVar(&config.MigrateOnly, "migrate-only", false, "apply database migrations and exit")
+	flag.IntVar(&config.MigrateTo, "migrate-to", -1, "schema version for -migrate-only, lower version rolls migrations back (-1 is latest)")
+	flag.IntVar(&config.DBMaxConns, "db-max-conns", 0, "max size of database connection pool (0 is max(4, CPU count))")
+	flag.IntVar(&config.DBMinConns, "db-min-conns", 0, "min size of database connection pool")
+	flag.DurationVar(&config.DBMaxConnLifetime, "db-max-conn-lifetime", 0, "database connection is closed after this duration (0 is 1h)")
+	flag.DurationVar(&config.DBMaxConnIdleTime, "db-max-conn-idle-time", 0, "idle database connection is closed after this duration (0 is 30m)")
+	flag.DurationVar(&config.DBHealthCheckPeriod, "db-health-check-period", 0, "period of idle database connections check (0 is 1m)")
+	flag.IntVar(&config.SnapshotGenerations, "snapshot-generations", defaultSnapshotGenerations, "number of previous file storage snapshots kept for recovery")
+	flag.StringVar(&config.SnapshotFormat, "snapshot-format", "json", "format of file storage snapshots: json, binary or binary-gzip, restore detects format of file")
+	flag.BoolVar(&config.FileWAL, "wal", false, "write-ahead log of file storage, changes are kept between snapshots")
+	flag.StringVar(&config.WALFsync, "wal-fsync", "always", "fsync policy of write-ahead log: always, interval (once a second) or never")
+	flag.Int64Var(&config.WALMaxSize, "wal-max-size", defaultWALMaxSize, "size of write-ahead log in bytes triggering snapshot (0 is unlimited)")
+	flag.IntVar(&config.DBStatementCache, "db-statement-cache", 0, "prepared statements cache size per connection (0 is 512, -1 disables cache, e.g. for pgbouncer)")
 }
 
 func MustLoad() *Config {
@@ -16,8 +16,11 @@ func New(sync SyncFunc) *Dumper {
 	}
 }
 
+// Start calls sync every storeIntervalSeconds until ctx is done, it blocks and should be run in goroutine.
+//
+// Next sync is called after previous one is finished, so syncs never overlap.
 func (d *Dumper) Start(ctx context.Context, storeIntervalSeconds int) {
-	if storeIntervalSeconds == 0 {
+	if storeIntervalSeconds <= 0 {
 		return
 	}
 	saveTicker := time.NewTicker(time.Second * time.Duration(storeIntervalSeconds))
@@ -25,7 +28,11 @@ func (d *Dumper) Start(ctx context.Context, storeIntervalSeconds int) {
 	defer saveTicker.Stop()
 
 	for {
-		<-saveTicker.C
-		go d.sync(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-saveTicker.C:
+			d.sync(ctx)
+		}
 	}
 }
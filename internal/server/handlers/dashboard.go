@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/benderr/metrics/internal/server/history"
+)
+
+//go:embed templates/dashboard.html
+var templates embed.FS
+
+var dashboardTemplate = template.Must(template.ParseFS(templates, "templates/dashboard.html"))
+
+// dashboardRow metric row of dashboard table
+type dashboardRow struct {
+	ID        string
+	MType     string
+	Value     string // значение для отображения
+	Number    string // числовое значение для сортировки
+	UpdatedAt string // время последнего обновления в RFC3339, пусто если не обновлялась с запуска
+}
+
+// dashboardData data of dashboard template
+type dashboardData struct {
+	Metrics     []dashboardRow
+	Types       []string
+	HistoryURL  string
+	HistorySize int    // количество точек истории, хранимых для метрики
+	StreamURL   string // поток изменений, ограниченный префиксом страницы
+	NextURL     string // следующая страница, если метрики не поместились
+}
+
+// dashboardLimit count of metrics on dashboard page
+const dashboardLimit = 500
+
+// GetMetricListHandler handler for obtaining information about all metrics.
+//
+// Renders dashboard with filterable and sortable metric list,
+// trends of visible metrics are loaded from /api/v1/history and appended with values from /stream.
+// Metrics can be selected with the same parameters as /api/v1/metrics, e.g. /?prefix=cpu.
+//
+// @Summary Metrics dashboard
+// @Tags dashboard
+// @Produce html
+// @Param prefix query string false "ID prefix"
+// @Param glob query string false "ID pattern, e.g. cpu.*"
+// @Param regex query string false "ID regular expression"
+// @Param type query []string false "metric types" collectionFormat(multi)
+// @Param limit query int false "page size, default 500, max 1000"
+// @Param cursor query string false "cursor of next page"
+// @Success 200 {string} string "html page"
+// @Failure 400 {object} problem.Problem "Invalid query"
+// @Failure 500 {object} problem.Problem "Internal error"
+// @Router / [get]
+func (a *AppHandlers) GetMetricListHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r, dashboardLimit)
+	if err != nil {
+		a.replyError(w, r, err, "")
+		return
+	}
+
+	page, err := a.metricRepo.List(r.Context(), q)
+	if err != nil {
+		a.replyError(w, r, err, "")
+		return
+	}
+	metrics := page.Metrics
+
+	types := make(map[string]struct{})
+	data := dashboardData{
+		Metrics:     make([]dashboardRow, 0, len(metrics)),
+		HistoryURL:  "/api/v1/history",
+		HistorySize: a.history.Size(),
+		StreamURL:   "/stream",
+	}
+	if q.Prefix != "" {
+		data.StreamURL += "?" + url.Values{"prefix": {q.Prefix}}.Encode()
+	}
+	if page.NextCursor != "" {
+		data.NextURL = nextPageURL(r, page.NextCursor)
+	}
+
+	for _, m := range metrics {
+		row := dashboardRow{
+			ID:     m.ID,
+			MType:  m.MType,
+			Value:  m.GetStringValue(),
+			Number: strconv.FormatFloat(history.Value(m), 'f', -1, 64),
+		}
+		if s, ok := a.history.Get(m.ID); ok {
+			row.UpdatedAt = s.UpdatedAt.Format(time.RFC3339)
+		}
+		data.Metrics = append(data.Metrics, row)
+		types[m.MType] = struct{}{}
+	}
+
+	for t := range types {
+		data.Types = append(data.Types, t)
+	}
+	sort.Strings(data.Types)
+
+	var output bytes.Buffer
+	if err := dashboardTemplate.Execute(&output, data); err != nil {
+		a.replyError(w, r, err, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(output.Bytes())
+}
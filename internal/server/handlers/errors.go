@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/pkg/problem"
+)
+
+// problemCode maps typed repository errors to http status code and problem code.
+func problemCode(err error) (int, string) {
+	switch {
+	case errors.Is(err, repository.ErrTypeConflict):
+		return http.StatusConflict, problem.CodeTypeConflict
+	case errors.Is(err, repository.ErrInvalidType):
+		return http.StatusBadRequest, problem.CodeInvalidType
+	case errors.Is(err, repository.ErrMissingValue):
+		return http.StatusBadRequest, problem.CodeMissingValue
+	case errors.Is(err, repository.ErrInvalidValue):
+		return http.StatusBadRequest, problem.CodeInvalidValue
+	case errors.Is(err, repository.ErrInvalidName):
+		return http.StatusBadRequest, problem.CodeInvalidName
+	case errors.Is(err, repository.ErrInvalidQuery):
+		return http.StatusBadRequest, problem.CodeInvalidQuery
+	case errors.Is(err, repository.ErrInvalidOp):
+		return http.StatusBadRequest, problem.CodeInvalidOp
+	default:
+		return http.StatusInternalServerError, problem.CodeInternal
+	}
+}
+
+// replyProblem writes problem with request path as instance
+func (a *AppHandlers) replyProblem(w http.ResponseWriter, r *http.Request, p *problem.Problem) {
+	p.Instance = r.URL.Path
+	if err := problem.Write(w, p); err != nil {
+		a.logger.Errorln("write problem error", err)
+	}
+}
+
+// replyError writes problem depends on error type,
+// unexpected errors are logged and not exposed to client.
+func (a *AppHandlers) replyError(w http.ResponseWriter, r *http.Request, err error, metricID string) {
+	status, code := problemCode(err)
+
+	detail := err.Error()
+	if status == http.StatusInternalServerError {
+		a.logger.Errorln("internal error:", err)
+		detail = "internal error"
+	}
+
+	p := problem.New(status, code, detail)
+	p.MetricID = metricID
+	a.replyProblem(w, r, p)
+}
+
+// replyBadRequest writes problem for body which can't be read or decoded
+func (a *AppHandlers) replyBadRequest(w http.ResponseWriter, r *http.Request, err error) {
+	a.replyProblem(w, r, problem.New(http.StatusBadRequest, problem.CodeBadRequest, err.Error()))
+}
+
+// replyNotFound writes problem for not existing metric
+func (a *AppHandlers) replyNotFound(w http.ResponseWriter, r *http.Request, metricID string) {
+	p := problem.New(http.StatusNotFound, problem.CodeNotFound, "metric not found")
+	p.MetricID = metricID
+	a.replyProblem(w, r, p)
+}
+
+// replyItemErrors writes per-item errors of bulk update,
+// status is 409 if all items failed by type conflict, otherwise 400.
+func (a *AppHandlers) replyItemErrors(w http.ResponseWriter, r *http.Request, allErrors []error) {
+	status := http.StatusConflict
+	items := make([]problem.Item, 0, len(allErrors))
+
+	for _, err := range allErrors {
+		itemStatus, code := problemCode(err)
+		if itemStatus != http.StatusConflict {
+			status = http.StatusBadRequest
+		}
+
+		item := problem.Item{Code: code, Detail: err.Error()}
+		var itemErr *repository.ItemError
+		if errors.As(err, &itemErr) {
+			item.Index = itemErr.Index
+			item.MetricID = itemErr.ID
+			item.Detail = itemErr.Err.Error()
+		}
+		items = append(items, item)
+	}
+
+	// common code of items or generic one if items failed differently
+	code := items[0].Code
+	for _, item := range items {
+		if item.Code != code {
+			code = problem.CodeBadRequest
+			break
+		}
+	}
+
+	p := problem.New(status, code, "batch contains invalid items")
+	p.Errors = items
+	a.replyProblem(w, r, p)
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/benderr/metrics/internal/server/history"
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/pkg/problem"
+)
+
+// Formats of export.
+const (
+	exportCSV    = "csv"
+	exportNDJSON = "ndjson"
+)
+
+// exportItem line of ndjson export, metric fields are the same as in file storage
+type exportItem struct {
+	repository.Metrics
+	UpdatedAt *time.Time      `json:"updated_at,omitempty"` // время последнего обновления с запуска сервера
+	History   []history.Point `json:"history,omitempty"`    // последние значения, если запрошена история
+}
+
+// exportWriter writes metrics of export in specific format
+type exportWriter interface {
+	Write(m repository.Metrics) error
+	Flush() error
+}
+
+// ExportHandler streams all metrics as csv or ndjson file.
+//
+// Metrics are read from repository one by one and are written to response immediately,
+// so export doesn't hold the whole storage in memory.
+//
+// @Summary Export metrics
+// @Description ndjson has one metric per line in file storage format with updated_at field.
+// @Description csv has columns id, type, value, updated_at, where value is numeric: gauge value, counter delta,
+// @Description histogram mean, summary median or set distinct count.
+// @Description With history=true ndjson lines have history field and csv has one row per point with columns id, type, time, value.
+// @Tags export
+// @Produce text/csv,application/x-ndjson
+// @Param format query string false "file format, default ndjson" Enums(csv, ndjson)
+// @Param history query bool false "include history of values since server start"
+// @Param prefix query string false "ID prefix"
+// @Param glob query string false "ID pattern, e.g. cpu.*"
+// @Param regex query string false "ID regular expression"
+// @Param type query []string false "metric types" collectionFormat(multi)
+// @Success 200 {string} string "file"
+// @Failure 400 {object} problem.Problem "Invalid format or query"
+// @Failure 500 {object} problem.Problem "Internal error"
+// @Router /export [get]
+func (a *AppHandlers) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r, 0)
+	if err != nil {
+		a.replyError(w, r, err, "")
+		return
+	}
+
+	withHistory, err := parseBool(r.URL.Query().Get("history"))
+	if err != nil {
+		a.replyProblem(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "history must be true or false"))
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	var writer exportWriter
+	switch format {
+	case "", exportNDJSON:
+		format = exportNDJSON
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		writer = &ndjsonExport{enc: json.NewEncoder(w), history: a.history, withHistory: withHistory}
+	case exportCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		writer = newCSVExport(w, a.history, withHistory)
+	default:
+		a.replyProblem(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "format must be csv or ndjson"))
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="metrics.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+
+	err = a.metricRepo.Iterate(r.Context(), q, writer.Write)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		// статус уже отправлен, обрываем соединение, чтобы клиент не принял обрезанный файл за полный
+		a.logger.Errorln("export error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// parseBool parses optional boolean query parameter, empty value is false
+func parseBool(v string) (bool, error) {
+	if v == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+type ndjsonExport struct {
+	enc         *json.Encoder
+	history     *history.Recorder
+	withHistory bool
+}
+
+func (e *ndjsonExport) Write(m repository.Metrics) error {
+	item := exportItem{Metrics: m}
+	if s, ok := e.history.Get(m.ID); ok {
+		item.UpdatedAt = &s.UpdatedAt
+		if e.withHistory {
+			item.History = s.Points
+		}
+	}
+	return e.enc.Encode(item)
+}
+
+func (e *ndjsonExport) Flush() error {
+	return nil
+}
+
+type csvExport struct {
+	w           *csv.Writer
+	history     *history.Recorder
+	withHistory bool
+}
+
+// newCSVExport returns csv writer, header is written immediately, so empty export has it too
+func newCSVExport(w io.Writer, h *history.Recorder, withHistory bool) *csvExport {
+	e := &csvExport{w: csv.NewWriter(w), history: h, withHistory: withHistory}
+
+	// ошибки записи буферизуются csv.Writer и возвращаются из Flush
+	if withHistory {
+		e.w.Write([]string{"id", "type", "time", "value"})
+	} else {
+		e.w.Write([]string{"id", "type", "value", "updated_at"})
+	}
+	return e
+}
+
+func (e *csvExport) Write(m repository.Metrics) error {
+	s, ok := e.history.Get(m.ID)
+	if !e.withHistory {
+		updatedAt := ""
+		if ok {
+			updatedAt = s.UpdatedAt.Format(time.RFC3339)
+		}
+		return e.w.Write([]string{m.ID, m.MType, formatNumber(history.Value(m)), updatedAt})
+	}
+
+	for _, p := range s.Points {
+		if err := e.w.Write([]string{m.ID, m.MType, p.Time.Format(time.RFC3339Nano), formatNumber(p.Value)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *csvExport) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
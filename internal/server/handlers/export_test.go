@@ -0,0 +1,95 @@
+package handlers_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/internal/server/handlers"
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/pkg/problem"
+)
+
+func TestExportHandler(t *testing.T) {
+	var delta int64 = 5
+	value := 1.5
+
+	var store = MockMemoryStorage{
+		Metrics: map[string]repository.Metrics{
+			"poll": {ID: "poll", MType: "counter", Delta: &delta},
+			"load": {ID: "load", MType: "gauge", Value: &value},
+		},
+	}
+
+	h := handlers.New(&store, &MockLogger{}, "")
+	r := chi.NewRouter()
+	h.AddHandlers(r)
+	server := httptest.NewServer(r)
+
+	defer server.Close()
+
+	client := resty.New().SetBaseURL(server.URL)
+
+	resp, err := client.R().Post("/update/gauge/temp/36.6")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+
+	t.Run("Export ndjson", func(t *testing.T) {
+		resp, err := client.R().Get("/export")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Equal(t, "application/x-ndjson", resp.Header().Get("Content-Type"))
+
+		ids := make([]string, 0)
+		scanner := bufio.NewScanner(bytes.NewReader(resp.Body()))
+		for scanner.Scan() {
+			var m repository.Metrics
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &m))
+			ids = append(ids, m.ID)
+		}
+		assert.Equal(t, []string{"load", "poll", "temp"}, ids)
+	})
+
+	t.Run("Export csv", func(t *testing.T) {
+		resp, err := client.R().Get("/export?format=csv&type=counter")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Contains(t, resp.Header().Get("Content-Disposition"), "metrics.csv")
+
+		rows, err := csv.NewReader(bytes.NewReader(resp.Body())).ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"id", "type", "value", "updated_at"}, {"poll", "counter", "5", ""}}, rows)
+	})
+
+	t.Run("Export csv with history", func(t *testing.T) {
+		resp, err := client.R().Get("/export?format=csv&history=true")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+		rows, err := csv.NewReader(bytes.NewReader(resp.Body())).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, rows, 2)
+		assert.Equal(t, []string{"id", "type", "time", "value"}, rows[0])
+		assert.Equal(t, "temp", rows[1][0])
+		assert.Equal(t, "36.6", rows[1][3])
+	})
+
+	t.Run("Export unknown format", func(t *testing.T) {
+		resp, err := client.R().Get("/export?format=xml")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+		p, ok := problem.Parse(resp.Header().Get("Content-Type"), resp.Body())
+		require.True(t, ok)
+		assert.Equal(t, problem.CodeInvalidQuery, p.Code)
+	})
+}
@@ -3,34 +3,44 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi"
 
+	"github.com/benderr/metrics/internal/server/history"
 	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/internal/server/stream"
 	"github.com/benderr/metrics/pkg/logger"
+	"github.com/benderr/metrics/pkg/problem"
 	"github.com/benderr/metrics/pkg/sign"
 )
 
-// @Title MetricStorage API
-// @Description Metrics manager
-// @Version 1.0
-
-// @Host localhost:8080
-
 type AppHandlers struct {
 	secret     string
 	metricRepo repository.MetricRepository
 	logger     logger.Logger
+	broker     *stream.Broker
+	history    *history.Recorder
 }
 
 // metricsDto model info
 // @Description metrics dto for fetch full information
 type metricsDto struct {
-	ID    string `json:"id"`   // unique metric name
-	MType string `json:"type"` // metric type enum gauge или counter
+	ID        string    `json:"id"`                  // unique metric name
+	MType     string    `json:"type"`                // metric type enum gauge, counter, histogram, summary or set
+	Quantiles []float64 `json:"quantiles,omitempty"` // quantiles to calculate for summary metric (default 0.5, 0.9, 0.99)
+}
+
+// summaryDto model info
+// @Description summary metric with calculated quantiles
+type summaryDto struct {
+	repository.Metrics
+	Quantiles map[string]float64 `json:"quantiles"` // percentile => value, e.g. p99
 }
 
 // New returned object AppHandlers.
@@ -39,10 +49,18 @@ type metricsDto struct {
 //	h := handlers.New(repo, logger, secret)
 //	h.AddHandlers(chiRouter)
 func New(repo repository.MetricRepository, logger logger.Logger, secret string) AppHandlers {
+	broker := stream.New(stream.DefaultBufferSize)
+	repo.OnChange(broker.Publish)
+
+	recorder := history.New(history.DefaultSize)
+	repo.OnChange(recorder.Record)
+
 	return AppHandlers{
 		metricRepo: repo,
 		logger:     logger,
 		secret:     secret,
+		broker:     broker,
+		history:    recorder,
 	}
 }
 
@@ -53,6 +71,9 @@ func (a *AppHandlers) AddHandlers(r *chi.Mux) {
 	r.Get("/value/{type}/{name}", a.GetMetricByURLHandler)
 	r.Get("/ping", a.PingDBHandler)
 	r.Post("/updates/", a.BulkUpdateHandler)
+	r.Get("/stream", a.StreamHandler)
+	r.Get("/export", a.ExportHandler)
+	r.Post("/import", a.ImportHandler)
 
 	r.Route("/update", func(r chi.Router) {
 		r.Post("/", a.UpdateMetricHandler)
@@ -60,37 +81,69 @@ func (a *AppHandlers) AddHandlers(r *chi.Mux) {
 	r.Route("/value", func(r chi.Router) {
 		r.Post("/", a.GetMetricHandler)
 		r.NotFound(func(w http.ResponseWriter, r *http.Request) {
-			http.Error(w, "invalid route "+r.RequestURI, http.StatusBadRequest)
+			a.replyProblem(w, r, problem.New(http.StatusNotFound, problem.CodeNotFound, "invalid route "+r.RequestURI))
 		})
 	})
+
+	a.addV1Handlers(r)
+	a.addSwaggerHandlers(r)
 }
 
 // UpdateMetricByURLHandler handler to update metric.
 //
 // Information is received via URL.
+//
+// @Summary Update metric by URL
+// @Description Counter and gauge value is a number, histogram and summary value is an observation, set value is a member.
+// @Tags legacy
+// @Param type path string true "metric type" Enums(gauge, counter, histogram, summary, set)
+// @Param name path string true "metric ID"
+// @Param value path string true "metric value"
+// @Param op query string false "update operation, default add" Enums(add, set)
+// @Success 200
+// @Failure 400 {object} problem.Problem "Invalid name, type, value or operation"
+// @Failure 409 {object} problem.Problem "Metric exists with another type"
+// @Failure 500 {object} problem.Problem "Internal error"
+// @Router /update/{type}/{name}/{value} [post]
 func (a *AppHandlers) UpdateMetricByURLHandler(w http.ResponseWriter, r *http.Request) {
 	memType := chi.URLParam(r, "type")
 	name := chi.URLParam(r, "name")
 	value := chi.URLParam(r, "value")
 
-	if metric, err := ParseCounter(memType, name, value); err == nil {
-		a.metricRepo.Update(r.Context(), *metric)
-		w.WriteHeader(http.StatusOK)
-		return
+	metric, err := ParseMetric(memType, name, value)
+	if err == nil {
+		metric.Op = r.URL.Query().Get("op")
+		err = metric.Validate()
 	}
 
-	if metric, err := ParseGauge(memType, name, value); err == nil {
-		a.metricRepo.Update(r.Context(), *metric)
-		w.WriteHeader(http.StatusOK)
+	if err == nil {
+		_, err = a.metricRepo.Update(r.Context(), *metric)
+	}
+
+	if err != nil {
+		a.replyError(w, r, err, name)
 		return
 	}
 
-	w.WriteHeader(http.StatusBadRequest)
+	w.WriteHeader(http.StatusOK)
 }
 
 // GetMetricByURLHandler handler to get information about metric.
 //
 // Information is received via URL.
+// For summary metrics a quantile can be requested with query parameter, e.g. /value/summary/latency?q=0.99
+//
+// @Summary Get metric value by URL
+// @Tags legacy
+// @Produce plain
+// @Param type path string true "metric type" Enums(gauge, counter, histogram, summary, set)
+// @Param name path string true "metric ID"
+// @Param q query number false "quantile for summary metric"
+// @Success 200 {string} string "metric value"
+// @Failure 400 {object} problem.Problem "Invalid type or quantile"
+// @Failure 404 {object} problem.Problem "Metric not found"
+// @Failure 500 {object} problem.Problem "Internal error"
+// @Router /value/{type}/{name} [get]
 func (a *AppHandlers) GetMetricByURLHandler(w http.ResponseWriter, r *http.Request) {
 	memType := chi.URLParam(r, "type")
 	name := chi.URLParam(r, "name")
@@ -98,58 +151,56 @@ func (a *AppHandlers) GetMetricByURLHandler(w http.ResponseWriter, r *http.Reque
 	metric, err := a.metricRepo.Get(r.Context(), name)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		a.replyError(w, r, err, name)
 		return
 	}
 
 	if metric == nil {
-		http.Error(w, "not found", http.StatusNotFound)
+		a.replyNotFound(w, r, name)
 		return
 	}
 
 	if metric.MType != memType {
-		http.Error(w, "invalid memType", http.StatusBadRequest)
+		a.replyError(w, r, fmt.Errorf("%w: %s is %s", repository.ErrInvalidType, name, metric.MType), name)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(metric.GetStringValue()))
-}
-
-// GetMetricListHandler handler for obtaining information about all metrics.
-func (a *AppHandlers) GetMetricListHandler(w http.ResponseWriter, r *http.Request) {
-	var output bytes.Buffer
+	if q := r.URL.Query().Get("q"); q != "" && metric.Summary != nil {
+		quantile, err := strconv.ParseFloat(q, 64)
+		if err == nil {
+			quantile, err = metric.Summary.Quantile(quantile)
+		}
 
-	output.WriteString("<table>")
+		if err != nil {
+			p := problem.New(http.StatusBadRequest, problem.CodeInvalidValue, "quantile must be a number between 0 and 1")
+			p.MetricID = name
+			a.replyProblem(w, r, p)
+			return
+		}
 
-	metrics, err := a.metricRepo.GetList(r.Context())
-
-	if err != nil {
-		a.logger.Errorln(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(strconv.FormatFloat(quantile, 'f', -1, 64)))
 		return
 	}
 
-	for _, counter := range metrics {
-		fmt.Fprintf(&output, "<tr><td>%v</td><td>%s</td></tr>", counter.ID, counter.GetStringValue())
-	}
-
-	output.WriteString("<table>")
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
-	w.Write(output.Bytes())
+	w.Write([]byte(metric.GetStringValue()))
 }
 
 // UpdateMetricHandler handler to update metric.
 //
 // Information is received from response.Body.
+//
+// @Summary Update metric
 // @Description Create/update metric
-// @Param metric body metricsDto true "metric ID and MType"
+// @Tags legacy
+// @Accept json
+// @Produce json
+// @Param metric body repository.Metrics true "metric"
 // @Success 200 {object} repository.Metrics
-// @Failure 400 {string} string "Bad request, id not specified"
-// @Failure 404 {string} string "Metric not found"
-// @Failure 500 {string} string "Internal error"
+// @Failure 400 {object} problem.Problem "Bad request, invalid name, type or value"
+// @Failure 409 {object} problem.Problem "Metric exists with another type"
+// @Failure 500 {object} problem.Problem "Internal error"
 // @Router /update [post]
 func (a *AppHandlers) UpdateMetricHandler(w http.ResponseWriter, r *http.Request) {
 	var buf bytes.Buffer
@@ -157,128 +208,235 @@ func (a *AppHandlers) UpdateMetricHandler(w http.ResponseWriter, r *http.Request
 
 	_, err := buf.ReadFrom(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		a.replyBadRequest(w, r, err)
 		return
 	}
 
 	if err = json.Unmarshal(buf.Bytes(), &metric); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		a.replyBadRequest(w, r, err)
 		return
 	}
 
-	newMetric, err := a.metricRepo.Update(r.Context(), metric)
+	a.updateMetric(w, r, metric)
+}
+
+// GetMetricHandler handler to get information about metric.
+//
+// Information is received from response.Body.
+//
+// @Summary Get metric
+// @Description Fetch metric info
+// @Tags legacy
+// @Accept json
+// @Produce json
+// @Param metric body metricsDto true "metric ID and MType"
+// @Success 200 {object} summaryDto "metric, quantiles are present for summary only"
+// @Failure 400 {object} problem.Problem "Bad request, id not specified"
+// @Failure 404 {object} problem.Problem "Metric not found"
+// @Failure 500 {object} problem.Problem "Internal error"
+// @Router /value [post]
+func (a *AppHandlers) GetMetricHandler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	var metric metricsDto
 
+	_, err := buf.ReadFrom(r.Body)
 	if err != nil {
-		a.logger.Errorln(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		a.replyBadRequest(w, r, err)
 		return
 	}
 
-	res, err := json.Marshal(&newMetric)
+	if err = json.Unmarshal(buf.Bytes(), &metric); err != nil {
+		a.replyBadRequest(w, r, err)
+		return
+	}
 
-	if err != nil {
-		a.logger.Errorln(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	a.getMetric(w, r, metric.ID, metric.Quantiles)
+}
+
+// PingDBHandler checks storage connection.
+//
+// @Summary Ping storage
+// @Tags legacy
+// @Success 200
+// @Failure 500 {object} problem.Problem "Storage is unavailable"
+// @Router /ping [get]
+func (a *AppHandlers) PingDBHandler(w http.ResponseWriter, r *http.Request) {
+
+	if err := a.metricRepo.PingContext(r.Context()); err != nil {
+		a.logger.Errorln("ping error:", err)
+		a.replyProblem(w, r, problem.New(http.StatusInternalServerError, problem.CodeUnavailable, "could't connect to database"))
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	w.Write(res)
 }
 
-// GetMetricHandler handler to get information about metric.
+// BulkUpdateHandler handler to update metrics,
+// this method expected array of metrics in response.Body.
 //
-// Information is received from response.Body.
-// @Description Fetch metric info
-// @Param metric body metricsDto true "metric ID and MType"
-// @Success 200 {object} repository.Metrics
-// @Failure 400 {string} string "Bad request, id not specified"
-// @Failure 404 {string} string "Metric not found"
-// @Failure 500 {string} string "Internal error"
-// @Router /value [post]
-func (a *AppHandlers) GetMetricHandler(w http.ResponseWriter, r *http.Request) {
+// If some items are invalid nothing is updated and response contains errors with item indexes.
+//
+// @Summary Batch update
+// @Tags legacy
+// @Accept json
+// @Param metrics body []repository.Metrics true "metrics"
+// @Param Idempotency-Key header string false "unique key of batch, repeated batch with the same key is not applied"
+// @Success 200
+// @Header 200 {string} Idempotent-Replayed "true if batch with the same key is already applied"
+// @Failure 400 {object} problem.Problem "Invalid items"
+// @Failure 409 {object} problem.Problem "Items conflict with stored metrics"
+// @Failure 500 {object} problem.Problem "Internal error"
+// @Router /updates/ [post]
+func (a *AppHandlers) BulkUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	var buf bytes.Buffer
-	var metric metricsDto
+	metrics := make([]repository.Metrics, 0)
 
 	_, err := buf.ReadFrom(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		a.logger.Infoln("bad request:", err)
+		a.replyBadRequest(w, r, err)
 		return
 	}
 
-	if err = json.Unmarshal(buf.Bytes(), &metric); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err = json.Unmarshal(buf.Bytes(), &metrics); err != nil {
+		a.logger.Infoln("bad request unmarshal:", err)
+		a.replyBadRequest(w, r, err)
 		return
 	}
 
-	exist, err := a.metricRepo.Get(r.Context(), metric.ID)
+	if a.bulkUpdate(w, r, metrics) {
+		w.WriteHeader(http.StatusOK)
+	}
+}
 
-	if err != nil {
-		a.logger.Errorln("internal error:", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// updateMetric validates and updates metric, updated metric is written to response.
+func (a *AppHandlers) updateMetric(w http.ResponseWriter, r *http.Request, metric repository.Metrics) {
+	if err := metric.Validate(); err != nil {
+		a.replyError(w, r, err, metric.ID)
 		return
 	}
 
-	if exist == nil {
-		http.Error(w, "not found:", http.StatusNotFound)
+	newMetric, err := a.metricRepo.Update(r.Context(), metric)
+
+	if err != nil {
+		a.replyError(w, r, err, metric.ID)
 		return
 	}
 
-	res, err := json.Marshal(&exist)
+	a.replyJSON(w, r, metricView(newMetric, nil), metric.ID)
+}
+
+// getMetric writes metric by ID to response,
+// for summary metric quantiles are calculated (repository.DefaultQuantiles if empty).
+func (a *AppHandlers) getMetric(w http.ResponseWriter, r *http.Request, id string, quantiles []float64) {
+	exist, err := a.metricRepo.Get(r.Context(), id)
 
 	if err != nil {
-		a.logger.Errorln(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		a.replyError(w, r, err, id)
 		return
 	}
 
-	if a.secret != "" {
-		signhex := sign.New(a.secret, res)
-		a.logger.Infoln("generated sign", signhex)
-		w.Header().Set("HashSHA256", signhex)
+	if exist == nil {
+		a.replyNotFound(w, r, id)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(res)
+	a.replyJSON(w, r, metricView(exist, quantiles), id)
 }
 
-func (a *AppHandlers) PingDBHandler(w http.ResponseWriter, r *http.Request) {
+// Headers of idempotent batch update.
+const (
+	IdempotencyKeyHeader   = "Idempotency-Key"     // ключ запроса, повторы с тем же ключом не применяются
+	IdempotentReplayHeader = "Idempotent-Replayed" // true, если запрос с ключом уже был обработан
+)
 
-	if err := a.metricRepo.PingContext(r.Context()); err != nil {
-		http.Error(w, "could't connect to database", http.StatusInternalServerError)
-		return
+// maxIdempotencyKeyLength limits size of stored keys
+const maxIdempotencyKeyLength = 255
+
+// bulkUpdate validates and updates batch of metrics.
+//
+// If request has Idempotency-Key header and batch with the same key is already applied,
+// nothing is updated and success is returned with Idempotent-Replayed header.
+//
+// Returns false if error is already written to response.
+func (a *AppHandlers) bulkUpdate(w http.ResponseWriter, r *http.Request, metrics []repository.Metrics) bool {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		return a.applyBatch(w, r, metrics, a.metricRepo.BulkUpdate)
 	}
 
-	w.WriteHeader(http.StatusOK)
+	if len(key) > maxIdempotencyKeyLength {
+		a.replyBadRequest(w, r, fmt.Errorf("%s is longer than %d", IdempotencyKeyHeader, maxIdempotencyKeyLength))
+		return false
+	}
+
+	return a.applyBatch(w, r, metrics, func(ctx context.Context, metrics []repository.Metrics) error {
+		err := a.metricRepo.BulkUpdateOnce(ctx, key, metrics)
+		if errors.Is(err, repository.ErrDuplicate) {
+			a.logger.Infoln("duplicate request", key)
+			w.Header().Set(IdempotentReplayHeader, "true")
+			return nil
+		}
+		return err
+	})
 }
 
-// BulkUpdateHandler handler to update metrics,
-// this method expected array of metrics in response.Body.
-func (a *AppHandlers) BulkUpdateHandler(w http.ResponseWriter, r *http.Request) {
-	var buf bytes.Buffer
-	metrics := make([]repository.Metrics, 0)
+// applyBatch validates batch of metrics and passes it to apply,
+// errors of items are written to response with their indexes.
+//
+// Returns false if error is already written to response.
+func (a *AppHandlers) applyBatch(w http.ResponseWriter, r *http.Request, metrics []repository.Metrics, apply func(ctx context.Context, metrics []repository.Metrics) error) bool {
+	if allErrors := validateBatch(metrics); len(allErrors) > 0 {
+		a.logger.Infoln("bad request items:", allErrors)
+		a.replyItemErrors(w, r, allErrors)
+		return false
+	}
+
+	err := apply(r.Context(), metrics)
 
-	_, err := buf.ReadFrom(r.Body)
 	if err != nil {
-		a.logger.Infoln("bad request:", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		var itemErr *repository.ItemError
+		if status, _ := problemCode(err); errors.As(err, &itemErr) && status != http.StatusInternalServerError {
+			a.replyItemErrors(w, r, []error{itemErr})
+			return false
+		}
+		a.replyError(w, r, err, "")
+		return false
 	}
 
-	if err = json.Unmarshal(buf.Bytes(), &metrics); err != nil {
-		a.logger.Infoln("bad request unmarshal:", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	return true
+}
+
+// metricView returns summaryDto with calculated quantiles for summary metric, otherwise metric itself
+func metricView(m *repository.Metrics, quantiles []float64) any {
+	if m.MType != "summary" {
+		return m
+	}
+	if len(quantiles) == 0 {
+		quantiles = repository.DefaultQuantiles
 	}
+	return &summaryDto{
+		Metrics:   *m,
+		Quantiles: m.GetQuantiles(quantiles),
+	}
+}
 
-	err = a.metricRepo.BulkUpdate(r.Context(), metrics)
+// replyJSON writes value as json, if secret is set the response body is signed
+func (a *AppHandlers) replyJSON(w http.ResponseWriter, r *http.Request, v any, metricID string) {
+	res, err := json.Marshal(v)
 
 	if err != nil {
-		a.logger.Infoln("internal error:", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		a.replyError(w, r, err, metricID)
 		return
 	}
 
+	if a.secret != "" {
+		signhex := sign.New(a.secret, res)
+		a.logger.Infoln("generated sign", signhex)
+		w.Header().Set("HashSHA256", signhex)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	w.Write(res)
 }
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 
 	"github.com/go-chi/chi"
@@ -17,10 +18,16 @@ import (
 
 	"github.com/benderr/metrics/internal/server/handlers"
 	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/pkg/ddsketch"
 	"github.com/benderr/metrics/pkg/gziper"
+	"github.com/benderr/metrics/pkg/histogram"
+	"github.com/benderr/metrics/pkg/hll"
+	"github.com/benderr/metrics/pkg/problem"
 )
 
 type MockMemoryStorage struct {
+	repository.Notifier
+	repository.Deduplicator
 	Metrics map[string]repository.Metrics
 }
 
@@ -40,22 +47,17 @@ func (m *MockLogger) Infow(msg string, keysAndValues ...interface{}) {
 
 func (m *MockMemoryStorage) Update(ctx context.Context, mtr repository.Metrics) (*repository.Metrics, error) {
 	if metric, ok := m.Metrics[mtr.ID]; ok {
-		updatedMetric := repository.Metrics{
-			ID:    metric.ID,
-			MType: metric.MType,
+		if err := metric.Merge(mtr); err != nil {
+			return nil, err
 		}
-		switch metric.MType {
-		case "counter":
-			newVal := *metric.Delta + *mtr.Delta
-			updatedMetric.Delta = &newVal
-		case "gauge":
-			updatedMetric.Value = mtr.Value
-		}
-		m.Metrics[mtr.ID] = updatedMetric
-		return &updatedMetric, nil
+		m.Metrics[mtr.ID] = metric
+		m.Notify(metric)
+		return &metric, nil
 	} else {
+		mtr.Op = ""
 		m.Metrics[mtr.ID] = mtr
 		res := m.Metrics[mtr.ID]
+		m.Notify(res)
 		return &res, nil
 	}
 }
@@ -66,13 +68,26 @@ func (m *MockMemoryStorage) BulkUpdate(ctx context.Context, metrics []repository
 		return nil
 	}
 
-	for _, v := range metrics {
-		m.Update(ctx, v)
+	for i, v := range metrics {
+		if _, err := m.Update(ctx, v); err != nil {
+			return repository.NewItemError(i, v.ID, err)
+		}
 	}
 
 	return nil
 }
 
+func (m *MockMemoryStorage) BulkUpdateOnce(ctx context.Context, key string, metrics []repository.Metrics) error {
+	return m.Do(ctx, key, func() error {
+		return m.BulkUpdate(ctx, metrics)
+	})
+}
+
+func (m *MockMemoryStorage) Replace(ctx context.Context, metrics []repository.Metrics) error {
+	m.Metrics = make(map[string]repository.Metrics, len(metrics))
+	return m.BulkUpdate(ctx, metrics)
+}
+
 func (m *MockMemoryStorage) GetList(ctx context.Context) ([]repository.Metrics, error) {
 	res := []repository.Metrics{}
 	for _, item := range m.Metrics {
@@ -81,13 +96,44 @@ func (m *MockMemoryStorage) GetList(ctx context.Context) ([]repository.Metrics,
 	return res, nil
 }
 
+func (m *MockMemoryStorage) List(ctx context.Context, q repository.ListQuery) (*repository.ListPage, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	match := q.Matcher()
+	res := []repository.Metrics{}
+	for _, item := range m.Metrics {
+		if match(&item) {
+			res = append(res, item)
+		}
+	}
+	return q.Paginate(res), nil
+}
+
+func (m *MockMemoryStorage) Iterate(ctx context.Context, q repository.ListQuery, fn func(m repository.Metrics) error) error {
+	page, err := m.List(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, item := range page.Metrics {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (m *MockMemoryStorage) Get(ctx context.Context, name string) (*repository.Metrics, error) {
 	if res, ok := m.Metrics[name]; ok {
 		return &repository.Metrics{
-			ID:    res.ID,
-			Value: res.Value,
-			Delta: res.Delta,
-			MType: res.MType,
+			ID:        res.ID,
+			Value:     res.Value,
+			Delta:     res.Delta,
+			MType:     res.MType,
+			Histogram: res.Histogram,
+			Summary:   res.Summary,
+			Set:       res.Set,
 		}, nil
 	}
 	return nil, nil
@@ -167,6 +213,70 @@ func TestUpdateMetricByUrlHandler(t *testing.T) {
 				code: http.StatusNotFound,
 			},
 		},
+		{
+			url:    "/update/histogram/latency/0.3",
+			method: http.MethodPost,
+			name:   "Add new histogram observation",
+			want: want{
+				code: http.StatusOK,
+			},
+		},
+		{
+			url:    "/update/histogram/latency/string",
+			method: http.MethodPost,
+			name:   "Add histogram observation with invalid data",
+			want: want{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			url:    "/update/summary/duration/12.5",
+			method: http.MethodPost,
+			name:   "Add new summary value",
+			want: want{
+				code: http.StatusOK,
+			},
+		},
+		{
+			url:    "/update/gauge/nan/NaN",
+			method: http.MethodPost,
+			name:   "Add gauge metric with NaN",
+			want: want{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			url:    "/update/unknown/test/1",
+			method: http.MethodPost,
+			name:   "Add metric with unknown type",
+			want: want{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			url:    "/update/gauge/test/2.0",
+			method: http.MethodPost,
+			name:   "Change type of existing metric",
+			want: want{
+				code: http.StatusConflict,
+			},
+		},
+		{
+			url:    "/update/counter/test/1?op=set",
+			method: http.MethodPost,
+			name:   "Set counter metric",
+			want: want{
+				code: http.StatusOK,
+			},
+		},
+		{
+			url:    "/update/counter/test/1?op=sub",
+			method: http.MethodPost,
+			name:   "Update with unknown operation",
+			want: want{
+				code: http.StatusBadRequest,
+			},
+		},
 		{
 			url:    "/update/gauge/test/2.0",
 			method: http.MethodGet,
@@ -190,6 +300,8 @@ func TestUpdateMetricByUrlHandler(t *testing.T) {
 			assert.Equal(t, test.want.code, resp.StatusCode())
 		})
 	}
+
+	assert.Equal(t, int64(1), *store.Metrics["test"].Delta, "counter must be set by op=set")
 }
 
 func TestGetMetricByUrlHandler(t *testing.T) {
@@ -260,6 +372,14 @@ func TestGetMetricByUrlHandler(t *testing.T) {
 				content: "",
 			},
 		},
+		{
+			url:    "/value/gauge",
+			method: http.MethodGet,
+			name:   "Route without metric name",
+			want: want{
+				code: http.StatusNotFound,
+			},
+		},
 		{
 			url:    "/value/gauge/test2",
 			method: http.MethodPost,
@@ -320,8 +440,37 @@ func TestGetMetricList(t *testing.T) {
 
 		assert.Contains(t, string(resp.Body()), "first metric")
 		assert.Contains(t, string(resp.Body()), "second metric")
+		assert.Contains(t, string(resp.Body()), "<thead>")
+		assert.Contains(t, string(resp.Body()), `<option value="counter">counter</option>`)
+		assert.NotContains(t, string(resp.Body()), "https://", "dashboard must not use external assets")
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+	})
 
+	t.Run("Get history", func(t *testing.T) {
+		for _, v := range []string{"1", "2"} {
+			resp, err := resty.New().R().Post(server.URL + "/update/gauge/load/" + v)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode())
+		}
+
+		resp, err := resty.New().R().Get(server.URL + "/api/v1/history?id=load&id=unknown")
+		require.NoError(t, err)
 		assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+		var res map[string]struct {
+			Points []struct {
+				V float64 `json:"v"`
+			} `json:"points"`
+		}
+		require.NoError(t, json.Unmarshal(resp.Body(), &res))
+		require.Len(t, res, 1)
+		require.Len(t, res["load"].Points, 2)
+		assert.Equal(t, 2.0, res["load"].Points[1].V)
+
+		page, err := resty.New().R().Get(server.URL + "/")
+		require.NoError(t, err)
+		assert.Contains(t, string(page.Body()), `data-id="load"`)
 	})
 }
 
@@ -386,7 +535,8 @@ func TestGetMetricHandler(t *testing.T) {
 				MType: "gauge",
 			},
 			want: want{
-				code: http.StatusNotFound,
+				code:    http.StatusNotFound,
+				content: `{"type":"about:blank", "title":"Not Found", "status":404, "code":"not_found", "detail":"metric not found", "instance":"/value/", "metric_id":"test3"}`,
 			},
 		},
 	}
@@ -415,11 +565,15 @@ func TestUpdateMetricHandler(t *testing.T) {
 	val1 := 100.1200
 	val2 := 806132.0
 
+	hist := histogram.New(1, 2)
+	hist.Observe(0.5)
+
 	var store = MockMemoryStorage{
 		Metrics: map[string]repository.Metrics{
 			"test":   {ID: "test", Delta: &delta, MType: "counter"},
 			"test2":  {ID: "test2", Value: &val1, MType: "gauge"},
 			"test22": {ID: "test22", Value: &val2, MType: "gauge"},
+			"hist":   {ID: "hist", Histogram: hist, MType: "histogram"},
 		},
 	}
 
@@ -430,6 +584,10 @@ func TestUpdateMetricHandler(t *testing.T) {
 
 	defer server.Close()
 
+	resHist := histogram.New(1, 2)
+	resHist.Observe(1.5)
+	resHist.Observe(3)
+
 	var resDelta int64 = 2
 	resValue := 102.1200
 
@@ -469,6 +627,89 @@ func TestUpdateMetricHandler(t *testing.T) {
 				content: `{"value":102.12, "id":"test2", "type":"gauge"}`,
 			},
 		},
+		{
+			url: "/update",
+			body: &repository.Metrics{
+				ID:        "hist",
+				MType:     "histogram",
+				Histogram: resHist,
+			},
+			name: "Merge histogram metric",
+			want: want{
+				code:    http.StatusOK,
+				content: `{"id":"hist", "type":"histogram", "histogram":{"bounds":[1,2], "counts":[1,1,1], "sum":5, "count":3}}`,
+			},
+		},
+		{
+			url: "/update",
+			body: &repository.Metrics{
+				ID:        "hist",
+				MType:     "histogram",
+				Histogram: histogram.New(1, 5),
+			},
+			name: "Merge histogram with other bounds",
+			want: want{
+				code: http.StatusConflict,
+			},
+		},
+		{
+			url: "/update",
+			body: &repository.Metrics{
+				ID:    "hist",
+				MType: "histogram",
+			},
+			name: "Histogram without buckets",
+			want: want{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			url: "/update",
+			body: &repository.Metrics{
+				ID:    "test",
+				MType: "counter",
+			},
+			name: "Counter without delta",
+			want: want{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			url: "/update",
+			body: &repository.Metrics{
+				ID:    "new",
+				MType: "unknown",
+				Value: &resValue,
+			},
+			name: "Unknown type",
+			want: want{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			url: "/update",
+			body: &repository.Metrics{
+				ID:    "bad name",
+				MType: "gauge",
+				Value: &resValue,
+			},
+			name: "Invalid name",
+			want: want{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			url: "/update",
+			body: &repository.Metrics{
+				ID:    "test2",
+				MType: "counter",
+				Delta: &resDelta,
+			},
+			name: "Change type of existing metric",
+			want: want{
+				code: http.StatusConflict,
+			},
+		},
 	}
 
 	req := resty.New().SetBaseURL(server.URL).R().SetHeader("Content-Type", "application/json")
@@ -594,7 +835,8 @@ func TestBulkUpdateHandler(t *testing.T) {
 	defer server.Close()
 
 	type want struct {
-		code int
+		code    int
+		content string
 	}
 
 	var delta int64 = 1
@@ -617,6 +859,44 @@ func TestBulkUpdateHandler(t *testing.T) {
 				code: http.StatusOK,
 			},
 		},
+		{
+			url: "/updates/",
+			body: []repository.Metrics{
+				{ID: "test3", MType: "gauge", Value: &value},
+				{ID: "test4", MType: "counter"},
+				{ID: "test5", MType: "unknown", Value: &value},
+			},
+			name: "Invalid items reported by index",
+			want: want{
+				code: http.StatusBadRequest,
+				content: `{
+					"type":"about:blank", "title":"Bad Request", "status":400, "code":"bad_request",
+					"detail":"batch contains invalid items", "instance":"/updates/",
+					"errors":[
+						{"index":1, "metric_id":"test4", "code":"missing_value", "detail":"missing metric value: counter requires delta"},
+						{"index":2, "metric_id":"test5", "code":"invalid_type", "detail":"invalid metric type: \"unknown\""}
+					]
+				}`,
+			},
+		},
+		{
+			url: "/updates/",
+			body: []repository.Metrics{
+				{ID: "test6", MType: "gauge", Value: &value},
+				{ID: "test", MType: "gauge", Value: &value},
+			},
+			name: "Type conflict with stored metric",
+			want: want{
+				code: http.StatusConflict,
+				content: `{
+					"type":"about:blank", "title":"Conflict", "status":409, "code":"type_conflict",
+					"detail":"batch contains invalid items", "instance":"/updates/",
+					"errors":[
+						{"index":1, "metric_id":"test", "code":"type_conflict", "detail":"metric type conflict: test is counter, got gauge"}
+					]
+				}`,
+			},
+		},
 	}
 
 	req := resty.New().SetBaseURL(server.URL).R().
@@ -638,6 +918,11 @@ func TestBulkUpdateHandler(t *testing.T) {
 
 			assert.NoError(t, err, "error making HTTP request")
 
+			if len(test.want.content) > 0 {
+				assert.JSONEq(t, test.want.content, string(resp.Body()))
+				assert.Equal(t, problem.ContentType, resp.Header().Get("Content-Type"))
+			}
+
 			assert.Equal(t, test.want.code, resp.StatusCode())
 		})
 	}
@@ -718,3 +1003,149 @@ func compress(s []byte) ([]byte, error) {
 	zipped.Close()
 	return buf.Bytes(), nil
 }
+
+func TestParseHistogram(t *testing.T) {
+	t.Run("should parse histogram success", func(t *testing.T) {
+		m, err := handlers.ParseHistogram("histogram", "test", "0.3")
+		assert.NoError(t, err)
+		assert.Equal(t, m.Histogram.Count, uint64(1))
+		assert.Equal(t, m.Histogram.Sum, 0.3)
+		assert.Equal(t, m.MType, "histogram")
+		assert.Equal(t, m.ID, "test")
+	})
+
+	t.Run("should parse histogram error value", func(t *testing.T) {
+		_, err := handlers.ParseHistogram("histogram", "test", "10b")
+		assert.Error(t, err, "invalid value")
+	})
+
+	t.Run("should parse histogram error", func(t *testing.T) {
+		_, err := handlers.ParseHistogram("gauge", "test", "10")
+		assert.Error(t, err, "invalid metric type")
+	})
+}
+
+func TestGetSummaryQuantiles(t *testing.T) {
+	sketch, err := ddsketch.New(0.01)
+	require.NoError(t, err)
+	for i := 1; i <= 100; i++ {
+		sketch.Add(float64(i))
+	}
+
+	var store = MockMemoryStorage{
+		Metrics: map[string]repository.Metrics{
+			"duration": {ID: "duration", Summary: sketch, MType: "summary"},
+		},
+	}
+
+	h := handlers.New(&store, &MockLogger{}, "")
+	r := chi.NewRouter()
+	h.AddHandlers(r)
+	server := httptest.NewServer(r)
+
+	defer server.Close()
+
+	t.Run("Get quantile by url", func(t *testing.T) {
+		resp, err := resty.New().R().Get(server.URL + "/value/summary/duration?q=0.5")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+		v, err := strconv.ParseFloat(string(resp.Body()), 64)
+		require.NoError(t, err)
+		assert.InDelta(t, 50, v, 1)
+	})
+
+	t.Run("Get invalid quantile by url", func(t *testing.T) {
+		resp, err := resty.New().R().Get(server.URL + "/value/summary/duration?q=2")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	})
+
+	t.Run("Get quantiles by json", func(t *testing.T) {
+		resp, err := resty.New().SetBaseURL(server.URL).R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(`{"id":"duration","type":"summary","quantiles":[0.9,0.99]}`).
+			Post("/value/")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+		var res struct {
+			Quantiles map[string]float64 `json:"quantiles"`
+		}
+		require.NoError(t, json.Unmarshal(resp.Body(), &res))
+		assert.Len(t, res.Quantiles, 2)
+		assert.InDelta(t, 90, res.Quantiles["p90"], 1)
+		assert.InDelta(t, 99, res.Quantiles["p99"], 1)
+	})
+}
+
+func TestSetMetric(t *testing.T) {
+	var store = MockMemoryStorage{
+		Metrics: make(map[string]repository.Metrics),
+	}
+
+	h := handlers.New(&store, &MockLogger{}, "")
+	r := chi.NewRouter()
+	h.AddHandlers(r)
+	server := httptest.NewServer(r)
+
+	defer server.Close()
+
+	for _, user := range []string{"alice", "bob", "alice"} {
+		resp, err := resty.New().R().Post(server.URL + "/update/set/users/" + user)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+	}
+
+	t.Run("Get distinct count by url", func(t *testing.T) {
+		resp, err := resty.New().R().Get(server.URL + "/value/set/users")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Equal(t, "2", string(resp.Body()))
+	})
+
+	t.Run("Merge sketch from json", func(t *testing.T) {
+		sketch, err := hll.New(hll.DefaultPrecision)
+		require.NoError(t, err)
+		sketch.AddString("bob")
+		sketch.AddString("carol")
+
+		resp, err := resty.New().SetBaseURL(server.URL).R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(&repository.Metrics{ID: "users", MType: "set", Set: sketch}).
+			Post("/update")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+		var res repository.Metrics
+		require.NoError(t, json.Unmarshal(resp.Body(), &res))
+		assert.Equal(t, uint64(3), res.Set.Estimate())
+	})
+
+	t.Run("Merge sketch with other precision", func(t *testing.T) {
+		sketch, err := hll.New(8)
+		require.NoError(t, err)
+
+		resp, err := resty.New().SetBaseURL(server.URL).R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(&repository.Metrics{ID: "users", MType: "set", Set: sketch}).
+			Post("/update")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode())
+	})
+}
+
+func TestParseSet(t *testing.T) {
+	t.Run("should parse set success", func(t *testing.T) {
+		m, err := handlers.ParseSet("set", "test", "user-1")
+		assert.NoError(t, err)
+		assert.Equal(t, m.Set.Estimate(), uint64(1))
+		assert.Equal(t, m.MType, "set")
+		assert.Equal(t, m.ID, "test")
+	})
+
+	t.Run("should parse set error", func(t *testing.T) {
+		_, err := handlers.ParseSet("gauge", "test", "user-1")
+		assert.Error(t, err, "invalid metric type")
+	})
+}
@@ -0,0 +1,56 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/internal/server/handlers"
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+func TestIdempotentBulkUpdate(t *testing.T) {
+	var store = MockMemoryStorage{
+		Metrics: make(map[string]repository.Metrics),
+	}
+
+	h := handlers.New(&store, &MockLogger{}, "")
+	r := chi.NewRouter()
+	h.AddHandlers(r)
+	server := httptest.NewServer(r)
+
+	defer server.Close()
+
+	client := resty.New().SetBaseURL(server.URL)
+	body := `[{"id":"PollCount","type":"counter","delta":1}]`
+
+	for _, path := range []string{"/updates/", "/api/v1/metrics:batch"} {
+		t.Run(path, func(t *testing.T) {
+			key := "key" + path
+
+			resp, err := client.R().SetHeader(handlers.IdempotencyKeyHeader, key).SetBody(body).Post(path)
+			require.NoError(t, err)
+			assert.Less(t, resp.StatusCode(), 300)
+			assert.Empty(t, resp.Header().Get(handlers.IdempotentReplayHeader))
+			delta := *store.Metrics["PollCount"].Delta
+
+			resp, err = client.R().SetHeader(handlers.IdempotencyKeyHeader, key).SetBody(body).Post(path)
+			require.NoError(t, err)
+			assert.Less(t, resp.StatusCode(), 300)
+			assert.Equal(t, "true", resp.Header().Get(handlers.IdempotentReplayHeader))
+			assert.Equal(t, delta, *store.Metrics["PollCount"].Delta, "repeated batch must not be applied")
+		})
+	}
+
+	t.Run("Too long key", func(t *testing.T) {
+		resp, err := client.R().SetHeader(handlers.IdempotencyKeyHeader, strings.Repeat("k", 256)).SetBody(body).Post("/updates/")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	})
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/internal/server/repository/filestorage"
+	"github.com/benderr/metrics/pkg/problem"
+)
+
+// Modes of import.
+const (
+	importMerge   = "merge"
+	importReplace = "replace"
+)
+
+// MaxImportSize limits size of import body after decompression.
+//
+// The whole body is decoded and applied as one batch, so nothing is changed if some line is invalid.
+// Larger storages can be copied with metricsctl migrate.
+const MaxImportSize = 64 << 20
+
+// LimitImport middleware limits body of /import with MaxImportSize before it's read by other middleware.
+//
+// It must be registered before sign and gzip middleware: sign middleware reads the whole body to check hash,
+// so limit of ImportHandler would apply only after body is in memory.
+// Compressed body is limited too, it's not larger than decompressed one in practice.
+func LimitImport(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/import" {
+			r.Body = http.MaxBytesReader(w, r.Body, MaxImportSize)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// importResult response of import
+type importResult struct {
+	Mode     string `json:"mode"`     // режим импорта
+	Imported int    `json:"imported"` // количество загруженных строк
+}
+
+// ImportHandler loads metrics from ndjson file, one metric per line as written by /export,
+// or from file storage snapshot of any format (json, binary, binary-gzip), see filestorage.DecodeSnapshot.
+//
+// In merge mode metrics are applied as batch update: counters are added and sketches are merged with stored ones.
+// In replace mode all stored metrics are removed first. Nothing is changed if some line is invalid.
+// Body is decoded completely before update and is limited with MaxImportSize, see LimitImport.
+//
+// @Summary Import metrics
+// @Description Body is ndjson of /export or file storage snapshot (json or binary). Extra fields of /export (updated_at, history) are ignored.
+// @Tags export
+// @Accept application/x-ndjson,application/octet-stream
+// @Produce json
+// @Param mode query string false "import mode, default merge" Enums(merge, replace)
+// @Param metrics body repository.Metrics true "metric per line"
+// @Success 200 {object} importResult
+// @Failure 400 {object} problem.Problem "Invalid mode, line or items"
+// @Failure 409 {object} problem.Problem "Items conflict with stored metrics"
+// @Failure 413 {object} problem.Problem "Body is larger than 64 MiB"
+// @Failure 500 {object} problem.Problem "Internal error"
+// @Router /import [post]
+func (a *AppHandlers) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	var apply func(w http.ResponseWriter, r *http.Request, metrics []repository.Metrics) bool
+	switch mode {
+	case "", importMerge:
+		mode = importMerge
+		apply = a.bulkUpdate
+	case importReplace:
+		apply = a.replace
+	default:
+		a.replyProblem(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "mode must be merge or replace"))
+		return
+	}
+
+	metrics, err := filestorage.DecodeSnapshot(http.MaxBytesReader(w, r.Body, MaxImportSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		a.replyProblem(w, r, problem.New(http.StatusRequestEntityTooLarge, problem.CodeTooLarge, fmt.Sprintf("import is larger than %d bytes", MaxImportSize)))
+		return
+	}
+	if err != nil {
+		a.logger.Infoln("bad request import:", err)
+		a.replyBadRequest(w, r, err)
+		return
+	}
+
+	if apply(w, r, metrics) {
+		a.replyJSON(w, r, importResult{Mode: mode, Imported: len(metrics)}, "")
+	}
+}
+
+// replace validates metrics and replaces all stored ones.
+//
+// Returns false if error is already written to response.
+func (a *AppHandlers) replace(w http.ResponseWriter, r *http.Request, metrics []repository.Metrics) bool {
+	return a.applyBatch(w, r, metrics, a.metricRepo.Replace)
+}
@@ -0,0 +1,154 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/internal/server/handlers"
+	"github.com/benderr/metrics/internal/server/middleware/sign"
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/internal/server/repository/filestorage"
+	"github.com/benderr/metrics/pkg/problem"
+	signer "github.com/benderr/metrics/pkg/sign"
+)
+
+func TestImportHandler(t *testing.T) {
+	var delta int64 = 5
+
+	var store = MockMemoryStorage{
+		Metrics: map[string]repository.Metrics{
+			"poll": {ID: "poll", MType: "counter", Delta: &delta},
+		},
+	}
+
+	h := handlers.New(&store, &MockLogger{}, "")
+	r := chi.NewRouter()
+	h.AddHandlers(r)
+	server := httptest.NewServer(r)
+
+	defer server.Close()
+
+	client := resty.New().SetBaseURL(server.URL)
+
+	body := `{"id":"poll","type":"counter","delta":2}
+{"id":"load","type":"gauge","value":1.5,"updated_at":"2024-01-01T00:00:00Z"}
+`
+
+	t.Run("Import merge", func(t *testing.T) {
+		resp, err := client.R().SetBody(body).Post("/import")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.JSONEq(t, `{"mode":"merge","imported":2}`, string(resp.Body()))
+		assert.Equal(t, int64(7), *store.Metrics["poll"].Delta)
+		assert.Equal(t, 1.5, *store.Metrics["load"].Value)
+	})
+
+	t.Run("Import replace", func(t *testing.T) {
+		resp, err := client.R().SetBody(`{"id":"poll","type":"counter","delta":2}`).Post("/import?mode=replace")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Len(t, store.Metrics, 1)
+		assert.Equal(t, int64(2), *store.Metrics["poll"].Delta)
+	})
+
+	t.Run("Import invalid items", func(t *testing.T) {
+		resp, err := client.R().SetBody(`{"id":"load","type":"gauge"}`).Post("/import?mode=replace")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+		assert.Len(t, store.Metrics, 1, "storage must not be changed")
+
+		var p problem.Problem
+		require.NoError(t, json.Unmarshal(resp.Body(), &p))
+		require.Len(t, p.Errors, 1)
+		assert.Equal(t, problem.CodeMissingValue, p.Errors[0].Code)
+	})
+
+	t.Run("Import invalid line", func(t *testing.T) {
+		resp, err := client.R().SetBody("{\"id\":\"load\"}\nnot json").Post("/import")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+		assert.Contains(t, string(resp.Body()), "item 1")
+	})
+
+	t.Run("Import file storage snapshot", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.json")
+		fs := filestorage.New(path, false, &MockLogger{})
+		fs.Update(context.Background(), repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+		require.NoError(t, fs.Sync(context.Background()))
+
+		snapshot, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		resp, err := client.R().SetBody(snapshot).Post("/import?mode=replace")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode(), string(resp.Body()))
+		assert.JSONEq(t, `{"mode":"replace","imported":1}`, string(resp.Body()))
+		assert.Equal(t, int64(5), *store.Metrics["poll"].Delta)
+	})
+
+	t.Run("Import binary snapshot", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.bin")
+		var imported int64 = 9
+		metrics := []repository.Metrics{{ID: "poll", MType: "counter", Delta: &imported}}
+		require.NoError(t, filestorage.WriteSnapshot(path, filestorage.FormatBinaryGzip, metrics))
+
+		snapshot, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		resp, err := client.R().SetHeader("Content-Type", "application/octet-stream").SetBody(snapshot).Post("/import?mode=replace")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode(), string(resp.Body()))
+		assert.Equal(t, int64(9), *store.Metrics["poll"].Delta)
+	})
+
+	t.Run("Import too large body", func(t *testing.T) {
+		resp, err := client.R().SetBody(bytes.Repeat([]byte(" "), handlers.MaxImportSize+1)).Post("/import")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode())
+	})
+
+	t.Run("Import unknown mode", func(t *testing.T) {
+		resp, err := client.R().SetBody(body).Post("/import?mode=append")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	})
+}
+
+func TestLimitImport(t *testing.T) {
+	var store = MockMemoryStorage{Metrics: map[string]repository.Metrics{}}
+
+	secret := "123"
+	h := handlers.New(&store, &MockLogger{}, secret)
+	r := chi.NewRouter()
+	// тело подписанного запроса читается миддлваром подписи до обработчика
+	r.Use(handlers.LimitImport)
+	r.Use(sign.New(secret, &MockLogger{}).CheckSign)
+	h.AddHandlers(r)
+	server := httptest.NewServer(r)
+
+	defer server.Close()
+
+	// подпись не проверяется, если тело превышает лимит: без ограничения ответ был бы invalid sign
+	body := bytes.Repeat([]byte(" "), handlers.MaxImportSize+1)
+	resp, err := resty.New().SetBaseURL(server.URL).R().
+		SetHeader("HashSHA256", signer.New(secret, []byte("other body"))).
+		SetBody(body).
+		Post("/import")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode())
+
+	var p problem.Problem
+	require.NoError(t, json.Unmarshal(resp.Body(), &p))
+	assert.Equal(t, problem.CodeTooLarge, p.Code)
+}
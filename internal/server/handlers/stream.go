@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/internal/server/stream"
+	"github.com/benderr/metrics/pkg/problem"
+)
+
+// streamKeepAlive interval of comments sent to keep idle connection open through proxies.
+const streamKeepAlive = 15 * time.Second
+
+// StreamHandler subscribes client to metric changes with Server-Sent Events.
+//
+// Metrics are selected with repeatable query parameters id and prefix, e.g. /stream?id=Alloc&prefix=cpu.
+// Without parameters all metrics are streamed. Current values are sent first, then every change.
+//
+// @Summary Stream metric changes
+// @Description Each event has type "metric" and metric json as data. If client reads too slowly the stream is closed,
+// @Description EventSource reconnects and receives current values again.
+// @Tags stream
+// @Produce text/event-stream
+// @Param id query []string false "metric IDs" collectionFormat(multi)
+// @Param prefix query []string false "metric ID prefixes" collectionFormat(multi)
+// @Success 200 {object} repository.Metrics "event data"
+// @Failure 500 {object} problem.Problem "Streaming is not supported"
+// @Failure 503 {object} problem.Problem "Server is shutting down"
+// @Router /stream [get]
+func (a *AppHandlers) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	filter := stream.Filter{
+		IDs:      r.URL.Query()["id"],
+		Prefixes: r.URL.Query()["prefix"],
+	}
+
+	rc := http.NewResponseController(w)
+
+	sub := a.broker.Subscribe(filter)
+	if sub == nil {
+		a.replyProblem(w, r, problem.New(http.StatusServiceUnavailable, problem.CodeUnavailable, "server is shutting down"))
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// подписка оформлена до чтения текущих значений, чтобы не потерять изменения между ними
+	err := a.metricRepo.Iterate(r.Context(), streamQuery(filter), func(m repository.Metrics) error {
+		if !filter.Match(m.ID) {
+			return nil
+		}
+		return writeEvent(w, m)
+	})
+	if err != nil {
+		a.logger.Errorln("stream current values error", err)
+		return
+	}
+
+	if err := rc.Flush(); err != nil {
+		a.logger.Errorln("stream flush error", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case m, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, m); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// streamQuery returns query of current values for filter, single prefix or ID is filtered by storage,
+// other filters are checked with filter.Match
+func streamQuery(filter stream.Filter) repository.ListQuery {
+	switch {
+	case len(filter.IDs) == 0 && len(filter.Prefixes) == 1:
+		return repository.ListQuery{Prefix: filter.Prefixes[0]}
+	case len(filter.IDs) == 1 && len(filter.Prefixes) == 0:
+		return repository.ListQuery{Prefix: filter.IDs[0]}
+	}
+	return repository.ListQuery{}
+}
+
+// writeEvent writes metric as SSE event
+func writeEvent(w http.ResponseWriter, m repository.Metrics) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: metric\ndata: %s\n\n", data)
+	return err
+}
+
+// Close closes active streams, it should be called on server shutdown,
+// otherwise http.Server.Shutdown waits for stream connections forever.
+func (a *AppHandlers) Close() {
+	a.broker.Close()
+}
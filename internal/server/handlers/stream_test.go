@@ -0,0 +1,131 @@
+package handlers_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/internal/server/handlers"
+	"github.com/benderr/metrics/internal/server/middleware/mlogger"
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/internal/server/repository/inmemory"
+	"github.com/benderr/metrics/pkg/gziper"
+)
+
+func TestStreamHandler(t *testing.T) {
+	value := 1.5
+	var store = MockMemoryStorage{
+		Metrics: map[string]repository.Metrics{
+			"cpu.1": {ID: "cpu.1", MType: "gauge", Value: &value},
+			"mem":   {ID: "mem", MType: "gauge", Value: &value},
+		},
+	}
+
+	h := handlers.New(&store, &MockLogger{}, "")
+	g := gziper.New(1, "application/json", "text/html")
+	r := chi.NewRouter()
+	r.Use(mlogger.New(&MockLogger{}).Middleware)
+	r.Use(g.TransformWriter)
+	r.Use(g.TransformReader)
+	h.AddHandlers(r)
+	server := httptest.NewServer(r)
+
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/stream?prefix=cpu.&id=load", nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := bufio.NewScanner(resp.Body)
+	next := func() repository.Metrics {
+		for events.Scan() {
+			if data, ok := strings.CutPrefix(events.Text(), "data: "); ok {
+				var m repository.Metrics
+				require.NoError(t, json.Unmarshal([]byte(data), &m))
+				return m
+			}
+		}
+		require.NoError(t, events.Err())
+		t.Fatal("stream closed")
+		return repository.Metrics{}
+	}
+
+	assert.Equal(t, "cpu.1", next().ID)
+
+	updates := `[{"id":"mem","type":"gauge","value":2},{"id":"load","type":"gauge","value":3}]`
+	res, err := http.Post(server.URL+"/updates/", "application/json", strings.NewReader(updates))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	m := next()
+	assert.Equal(t, "load", m.ID)
+	assert.Equal(t, 3.0, *m.Value)
+
+	h.Close()
+	for events.Scan() {
+	}
+	assert.NoError(t, ctx.Err(), "stream must be closed on shutdown")
+}
+
+func TestStreamConcurrentUpdates(t *testing.T) {
+	for name, repo := range map[string]repository.MetricRepository{"slice": inmemory.New(), "map": inmemory.NewFast()} {
+		t.Run(name, func(t *testing.T) {
+			h := handlers.New(repo, &MockLogger{}, "")
+			r := chi.NewRouter()
+			h.AddHandlers(r)
+			server := httptest.NewServer(r)
+			defer server.Close()
+			defer h.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			// текущие значения читаются, пока пачки изменяют хранилище
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; ctx.Err() == nil; i++ {
+					value := float64(i)
+					repo.BulkUpdate(ctx, []repository.Metrics{
+						{ID: "cpu." + strconv.Itoa(i%50), MType: "gauge", Value: &value},
+						{ID: "mem", MType: "gauge", Value: &value},
+					})
+				}
+			}()
+
+			for i := 0; i < 10; i++ {
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/stream", nil)
+				require.NoError(t, err)
+				resp, err := http.DefaultClient.Do(req)
+				require.NoError(t, err)
+
+				events := bufio.NewScanner(resp.Body)
+				require.True(t, events.Scan())
+				resp.Body.Close()
+			}
+
+			cancel()
+			<-done
+		})
+	}
+}
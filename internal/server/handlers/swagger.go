@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"github.com/go-chi/chi"
+	httpSwagger "github.com/swaggo/http-swagger"
+
+	// регистрирует сгенерированную спецификацию в swag
+	_ "github.com/benderr/metrics/swagger"
+)
+
+// @Title MetricStorage API
+// @Description Metrics manager
+// @Version 1.0
+
+// @Host localhost:8080
+
+// SwaggerDocPath path of generated OpenAPI spec.
+const SwaggerDocPath = "/swagger/doc.json"
+
+// addSwaggerHandlers registers spec at /swagger/doc.json and Swagger UI at /swagger/index.html.
+//
+// Spec is generated by swag:
+//
+//	swag init -g swagger.go -d internal/server/handlers -o swagger --parseDependency --parseInternal
+func (a *AppHandlers) addSwaggerHandlers(r chi.Router) {
+	r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL(SwaggerDocPath)))
+}
@@ -0,0 +1,87 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/swaggo/swag"
+
+	"github.com/benderr/metrics/internal/server/handlers"
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+func TestSwaggerCoversRoutes(t *testing.T) {
+	var store = MockMemoryStorage{
+		Metrics: make(map[string]repository.Metrics),
+	}
+
+	h := handlers.New(&store, &MockLogger{}, "")
+	r := chi.NewRouter()
+	h.AddHandlers(r)
+
+	doc, err := swag.ReadDoc()
+	require.NoError(t, err)
+
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(doc), &spec))
+
+	documented := make(map[string]bool)
+	for path, methods := range spec.Paths {
+		for method := range methods {
+			documented[strings.ToUpper(method)+" "+normalizeRoute(path)] = true
+		}
+	}
+
+	err = chi.Walk(r, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, "/swagger/") {
+			return nil
+		}
+		assert.True(t, documented[method+" "+normalizeRoute(route)], "route %s %s is not documented", method, route)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestSwaggerServed(t *testing.T) {
+	var store = MockMemoryStorage{
+		Metrics: make(map[string]repository.Metrics),
+	}
+
+	h := handlers.New(&store, &MockLogger{}, "")
+	r := chi.NewRouter()
+	h.AddHandlers(r)
+	server := httptest.NewServer(r)
+
+	defer server.Close()
+
+	t.Run("Spec", func(t *testing.T) {
+		resp, err := resty.New().R().Get(server.URL + handlers.SwaggerDocPath)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Contains(t, string(resp.Body()), "/api/v1/metrics")
+	})
+
+	t.Run("UI", func(t *testing.T) {
+		resp, err := resty.New().R().Get(server.URL + "/swagger/index.html")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Contains(t, string(resp.Body()), "swagger-ui")
+	})
+}
+
+// normalizeRoute trims trailing slash, chi reports /update for r.Route("/update") as /update/
+func normalizeRoute(route string) string {
+	if len(route) > 1 {
+		return strings.TrimSuffix(route, "/")
+	}
+	return route
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"github.com/benderr/metrics/internal/server/history"
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/pkg/problem"
+)
+
+// addV1Handlers registers REST-style routes of API v1.
+//
+// Legacy routes (/update, /value, /updates/) are kept for agents in the field
+// and share the same validation, storage and error model.
+func (a *AppHandlers) addV1Handlers(r chi.Router) {
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Get("/metrics", a.ListMetricsV1Handler)
+		r.Get("/history", a.HistoryV1Handler)
+		r.Post("/metrics:batch", a.BatchUpdateV1Handler)
+		r.Get("/metrics/{id}", a.GetMetricV1Handler)
+		r.Put("/metrics/{id}", a.PutMetricV1Handler)
+		r.Get("/admin/db", a.DBStatsV1Handler)
+		r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+			a.replyProblem(w, r, problem.New(http.StatusNotFound, problem.CodeNotFound, "invalid route "+r.RequestURI))
+		})
+	})
+}
+
+// Limits of metrics page size.
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// ListMetricsV1Handler returns page of metrics.
+//
+// Link header with rel="next" is set if there are more metrics.
+//
+// @Summary List metrics
+// @Description Metrics are ordered by ID, filters are combined with AND.
+// @Tags v1
+// @Produce json
+// @Param prefix query string false "ID prefix"
+// @Param glob query string false "ID pattern, e.g. cpu.*"
+// @Param regex query string false "ID regular expression"
+// @Param type query []string false "metric types" collectionFormat(multi)
+// @Param order query string false "order by ID" Enums(asc, desc)
+// @Param limit query int false "page size, default 100, max 1000"
+// @Param cursor query string false "cursor from Link header of previous page"
+// @Success 200 {array} repository.Metrics
+// @Header 200 {string} Link "URL of next page with rel=next"
+// @Failure 400 {object} problem.Problem "Invalid query"
+// @Failure 500 {object} problem.Problem "Internal error"
+// @Router /api/v1/metrics [get]
+func (a *AppHandlers) ListMetricsV1Handler(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r, defaultListLimit)
+	if err != nil {
+		a.replyError(w, r, err, "")
+		return
+	}
+
+	page, err := a.metricRepo.List(r.Context(), q)
+	if err != nil {
+		a.replyError(w, r, err, "")
+		return
+	}
+
+	if page.NextCursor != "" {
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextPageURL(r, page.NextCursor)))
+	}
+
+	a.replyJSON(w, r, page.Metrics, "")
+}
+
+// parseListQuery reads list query from url parameters,
+// defaultLimit is used if limit is not specified
+func parseListQuery(r *http.Request, defaultLimit int) (repository.ListQuery, error) {
+	params := r.URL.Query()
+
+	q := repository.ListQuery{
+		Prefix: params.Get("prefix"),
+		Glob:   params.Get("glob"),
+		Regex:  params.Get("regex"),
+		Types:  params["type"],
+		Cursor: params.Get("cursor"),
+		Limit:  defaultLimit,
+	}
+
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, fmt.Errorf("%w: order must be asc or desc", repository.ErrInvalidQuery)
+	}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return q, fmt.Errorf("%w: limit must be between 1 and %d", repository.ErrInvalidQuery, maxListLimit)
+		}
+		q.Limit = limit
+	}
+
+	return q, q.Validate()
+}
+
+// nextPageURL returns request url with cursor of next page
+func nextPageURL(r *http.Request, cursor string) string {
+	params := r.URL.Query()
+	params.Set("cursor", cursor)
+	return r.URL.Path + "?" + params.Encode()
+}
+
+// GetMetricV1Handler returns metric by ID.
+//
+// @Summary Get metric
+// @Description For summary metric quantiles are calculated, by default 0.5, 0.9 and 0.99.
+// @Tags v1
+// @Produce json
+// @Param id path string true "metric ID"
+// @Param q query []number false "quantiles for summary metric" collectionFormat(multi)
+// @Success 200 {object} summaryDto "metric, quantiles are present for summary only"
+// @Failure 400 {object} problem.Problem "Invalid quantile"
+// @Failure 404 {object} problem.Problem "Metric not found"
+// @Failure 500 {object} problem.Problem "Internal error"
+// @Router /api/v1/metrics/{id} [get]
+func (a *AppHandlers) GetMetricV1Handler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	quantiles := make([]float64, 0)
+	for _, q := range r.URL.Query()["q"] {
+		v, err := strconv.ParseFloat(q, 64)
+		if err != nil || v < 0 || v > 1 {
+			a.replyError(w, r, fmt.Errorf("%w: quantile must be a number between 0 and 1", repository.ErrInvalidValue), id)
+			return
+		}
+		quantiles = append(quantiles, v)
+	}
+
+	a.getMetric(w, r, id, quantiles)
+}
+
+// PutMetricV1Handler creates or updates metric by ID.
+//
+// @Summary Create/update metric
+// @Description Gauge value is overwritten, counter delta is added, histogram, summary and set are merged.
+// @Tags v1
+// @Accept json
+// @Produce json
+// @Param id path string true "metric ID"
+// @Param metric body repository.Metrics true "metric, id can be omitted"
+// @Success 200 {object} repository.Metrics
+// @Failure 400 {object} problem.Problem "Bad request, invalid name, type or value"
+// @Failure 409 {object} problem.Problem "Metric exists with another type"
+// @Failure 500 {object} problem.Problem "Internal error"
+// @Router /api/v1/metrics/{id} [put]
+func (a *AppHandlers) PutMetricV1Handler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var buf bytes.Buffer
+	var metric repository.Metrics
+
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		a.replyBadRequest(w, r, err)
+		return
+	}
+
+	if err := json.Unmarshal(buf.Bytes(), &metric); err != nil {
+		a.replyBadRequest(w, r, err)
+		return
+	}
+
+	if metric.ID == "" {
+		metric.ID = id
+	}
+
+	if metric.ID != id {
+		a.replyError(w, r, fmt.Errorf("%w: body id %q does not match path", repository.ErrInvalidName, metric.ID), id)
+		return
+	}
+
+	a.updateMetric(w, r, metric)
+}
+
+// BatchUpdateV1Handler updates batch of metrics.
+//
+// @Summary Batch update
+// @Description Items are validated before update, if some items are invalid nothing is updated
+// @Description and the problem contains errors with item indexes.
+// @Tags v1
+// @Accept json
+// @Param metrics body []repository.Metrics true "metrics"
+// @Param Idempotency-Key header string false "unique key of batch, repeated batch with the same key is not applied"
+// @Success 204
+// @Header 204 {string} Idempotent-Replayed "true if batch with the same key is already applied"
+// @Failure 400 {object} problem.Problem "Invalid items"
+// @Failure 409 {object} problem.Problem "Items conflict with stored metrics"
+// @Failure 500 {object} problem.Problem "Internal error"
+// @Router /api/v1/metrics:batch [post]
+func (a *AppHandlers) BatchUpdateV1Handler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	metrics := make([]repository.Metrics, 0)
+
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		a.replyBadRequest(w, r, err)
+		return
+	}
+
+	if err := json.Unmarshal(buf.Bytes(), &metrics); err != nil {
+		a.replyBadRequest(w, r, err)
+		return
+	}
+
+	if a.bulkUpdate(w, r, metrics) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HistoryV1Handler returns recent values of metrics updated since server start.
+//
+// @Summary Metrics history
+// @Description Values are numeric: gauge value, counter delta, histogram mean, summary median and set distinct count.
+// @Description History is kept in memory and is empty after restart.
+// @Tags v1
+// @Produce json
+// @Param id query []string false "metric IDs, all metrics if empty" collectionFormat(multi)
+// @Success 200 {object} map[string]history.Series
+// @Router /api/v1/history [get]
+func (a *AppHandlers) HistoryV1Handler(w http.ResponseWriter, r *http.Request) {
+	ids := r.URL.Query()["id"]
+	if len(ids) == 0 {
+		a.replyJSON(w, r, a.history.All(), "")
+		return
+	}
+
+	res := make(map[string]history.Series, len(ids))
+	for _, id := range ids {
+		if s, ok := a.history.Get(id); ok {
+			res[id] = s
+		}
+	}
+	a.replyJSON(w, r, res, "")
+}
+
+// DBStatsV1Handler returns statistics of storage connection pool.
+//
+// @Summary Connection pool statistics
+// @Description Available for database storage only.
+// @Tags admin
+// @Produce json
+// @Success 200 {object} repository.PoolStats
+// @Failure 404 {object} problem.Problem "Storage has no connection pool"
+// @Router /api/v1/admin/db [get]
+func (a *AppHandlers) DBStatsV1Handler(w http.ResponseWriter, r *http.Request) {
+	p, ok := a.metricRepo.(repository.PoolStatsProvider)
+	if !ok {
+		a.replyProblem(w, r, problem.New(http.StatusNotFound, problem.CodeNotFound, "storage has no connection pool"))
+		return
+	}
+	a.replyJSON(w, r, p.PoolStats(), "")
+}
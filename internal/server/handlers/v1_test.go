@@ -0,0 +1,234 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/internal/server/handlers"
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/pkg/ddsketch"
+	"github.com/benderr/metrics/pkg/problem"
+)
+
+func TestV1Handlers(t *testing.T) {
+	var delta int64 = 5
+	value := 1.5
+
+	sketch, err := ddsketch.New(0.01)
+	require.NoError(t, err)
+	for i := 1; i <= 100; i++ {
+		sketch.Add(float64(i))
+	}
+
+	var store = MockMemoryStorage{
+		Metrics: map[string]repository.Metrics{
+			"poll":     {ID: "poll", MType: "counter", Delta: &delta},
+			"duration": {ID: "duration", MType: "summary", Summary: sketch},
+		},
+	}
+
+	h := handlers.New(&store, &MockLogger{}, "")
+	r := chi.NewRouter()
+	h.AddHandlers(r)
+	server := httptest.NewServer(r)
+
+	defer server.Close()
+
+	client := resty.New().SetBaseURL(server.URL)
+
+	t.Run("Put metric with id from path", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(`{"type":"gauge","value":1.5}`).
+			Put("/api/v1/metrics/temp")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.JSONEq(t, `{"id":"temp","type":"gauge","value":1.5}`, string(resp.Body()))
+	})
+
+	t.Run("Put metric with mismatched id", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(&repository.Metrics{ID: "other", MType: "gauge", Value: &value}).
+			Put("/api/v1/metrics/temp")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+		assert.Equal(t, problem.ContentType, resp.Header().Get("Content-Type"))
+
+		p, ok := problem.Parse(resp.Header().Get("Content-Type"), resp.Body())
+		require.True(t, ok)
+		assert.Equal(t, problem.CodeInvalidName, p.Code)
+	})
+
+	t.Run("Put metric with type conflict", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(`{"type":"gauge","value":1}`).
+			Put("/api/v1/metrics/poll")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode())
+	})
+
+	t.Run("Get metric", func(t *testing.T) {
+		resp, err := client.R().Get("/api/v1/metrics/poll")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.JSONEq(t, `{"id":"poll","type":"counter","delta":5}`, string(resp.Body()))
+	})
+
+	t.Run("Get summary quantiles", func(t *testing.T) {
+		resp, err := client.R().Get("/api/v1/metrics/duration?q=0.5&q=0.9")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+		var res struct {
+			Quantiles map[string]float64 `json:"quantiles"`
+		}
+		require.NoError(t, json.Unmarshal(resp.Body(), &res))
+		assert.Len(t, res.Quantiles, 2)
+		assert.InDelta(t, 50, res.Quantiles["p50"], 1)
+	})
+
+	t.Run("Get invalid quantile", func(t *testing.T) {
+		resp, err := client.R().Get("/api/v1/metrics/duration?q=abc")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	})
+
+	t.Run("Get unknown metric", func(t *testing.T) {
+		resp, err := client.R().Get("/api/v1/metrics/unknown")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+		assert.Equal(t, problem.ContentType, resp.Header().Get("Content-Type"))
+	})
+
+	t.Run("Batch update", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody([]repository.Metrics{
+				{ID: "poll", MType: "counter", Delta: &delta},
+				{ID: "load", MType: "gauge", Value: &value},
+			}).
+			Post("/api/v1/metrics:batch")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode())
+		assert.Equal(t, int64(10), *store.Metrics["poll"].Delta)
+	})
+
+	t.Run("Batch update with invalid items", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody([]repository.Metrics{
+				{ID: "poll", MType: "counter"},
+			}).
+			Post("/api/v1/metrics:batch")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+		p, ok := problem.Parse(resp.Header().Get("Content-Type"), resp.Body())
+		require.True(t, ok)
+		require.Len(t, p.Errors, 1)
+		assert.Equal(t, problem.CodeMissingValue, p.Errors[0].Code)
+	})
+
+	t.Run("List metrics", func(t *testing.T) {
+		resp, err := client.R().Get("/api/v1/metrics")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+		var res []repository.Metrics
+		require.NoError(t, json.Unmarshal(resp.Body(), &res))
+		assert.Len(t, res, 4)
+	})
+
+	t.Run("List metrics page", func(t *testing.T) {
+		resp, err := client.R().Get("/api/v1/metrics?type=gauge&type=counter&limit=1")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.JSONEq(t, `[{"id":"load","type":"gauge","value":1.5}]`, string(resp.Body()))
+		assert.Equal(t, `</api/v1/metrics?cursor=load&limit=1&type=gauge&type=counter>; rel="next"`, resp.Header().Get("Link"))
+
+		resp, err = client.R().Get("/api/v1/metrics?type=gauge&type=counter&limit=1&cursor=load")
+		require.NoError(t, err)
+		assert.JSONEq(t, `[{"id":"poll","type":"counter","delta":10}]`, string(resp.Body()))
+		assert.Contains(t, resp.Header().Get("Link"), "cursor=poll")
+
+		resp, err = client.R().Get("/api/v1/metrics?glob=te*&order=desc")
+		require.NoError(t, err)
+		assert.JSONEq(t, `[{"id":"temp","type":"gauge","value":1.5}]`, string(resp.Body()))
+		assert.Empty(t, resp.Header().Get("Link"))
+	})
+
+	t.Run("List metrics with invalid query", func(t *testing.T) {
+		resp, err := client.R().Get("/api/v1/metrics?regex=(")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+		p, ok := problem.Parse(resp.Header().Get("Content-Type"), resp.Body())
+		require.True(t, ok)
+		assert.Equal(t, problem.CodeInvalidQuery, p.Code)
+	})
+
+	t.Run("Unknown route", func(t *testing.T) {
+		resp, err := client.R().Get("/api/v1/unknown")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+		assert.Equal(t, problem.ContentType, resp.Header().Get("Content-Type"))
+	})
+}
+
+// MockPoolStorage storage with connection pool
+type MockPoolStorage struct {
+	MockMemoryStorage
+}
+
+func (m *MockPoolStorage) PoolStats() repository.PoolStats {
+	return repository.PoolStats{MaxConns: 4, TotalConns: 2, IdleConns: 1, AcquiredConns: 1, AcquireCount: 10}
+}
+
+func TestDBStatsV1Handler(t *testing.T) {
+	tests := []struct {
+		name       string
+		store      repository.MetricRepository
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "storage with pool",
+			store:      &MockPoolStorage{MockMemoryStorage{Metrics: map[string]repository.Metrics{}}},
+			wantStatus: http.StatusOK,
+			wantBody: `{"max_conns":4,"total_conns":2,"idle_conns":1,"acquired_conns":1,"constructing_conns":0,
+				"acquire_count":10,"empty_acquire_count":0,"canceled_acquire_count":0,"acquire_duration_ms":0,
+				"new_conns_count":0,"max_lifetime_destroyed":0,"max_idle_destroyed":0}`,
+		},
+		{
+			name:       "storage without pool",
+			store:      &MockMemoryStorage{Metrics: map[string]repository.Metrics{}},
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handlers.New(tt.store, &MockLogger{}, "")
+			r := chi.NewRouter()
+			h.AddHandlers(r)
+			server := httptest.NewServer(r)
+			defer server.Close()
+
+			resp, err := resty.New().R().Get(server.URL + "/api/v1/admin/db")
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode())
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, string(resp.Body()))
+			}
+		})
+	}
+}
@@ -1,12 +1,34 @@
 package handlers
 
 import (
-	"errors"
+	"fmt"
 	"strconv"
 
 	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/pkg/ddsketch"
+	"github.com/benderr/metrics/pkg/histogram"
+	"github.com/benderr/metrics/pkg/hll"
 )
 
+// ParseMetric parses metric received via URL depending on memType.
+//
+// Unknown memType results in error wrapping repository.ErrInvalidType.
+func ParseMetric(memType, name, value string) (*repository.Metrics, error) {
+	switch memType {
+	case "counter":
+		return ParseCounter(memType, name, value)
+	case "gauge":
+		return ParseGauge(memType, name, value)
+	case "histogram":
+		return ParseHistogram(memType, name, value)
+	case "summary":
+		return ParseSummary(memType, name, value)
+	case "set":
+		return ParseSet(memType, name, value)
+	}
+	return nil, fmt.Errorf("%w: %q", repository.ErrInvalidType, memType)
+}
+
 func ParseCounter(memType, name, value string) (*repository.Metrics, error) {
 	var metricInfo = repository.Metrics{}
 	if memType == "counter" {
@@ -14,7 +36,7 @@ func ParseCounter(memType, name, value string) (*repository.Metrics, error) {
 		v, err := strconv.ParseInt(value, 10, 64)
 
 		if err != nil {
-			return &metricInfo, errors.New("invalid value")
+			return &metricInfo, fmt.Errorf("%w: %q is not integer", repository.ErrInvalidValue, value)
 		}
 
 		metricInfo.ID = name
@@ -22,7 +44,7 @@ func ParseCounter(memType, name, value string) (*repository.Metrics, error) {
 		metricInfo.MType = memType
 		return &metricInfo, nil
 	}
-	return nil, errors.New("not counter")
+	return nil, fmt.Errorf("%w: not counter", repository.ErrInvalidType)
 }
 
 func ParseGauge(memType, name, value string) (*repository.Metrics, error) {
@@ -32,7 +54,7 @@ func ParseGauge(memType, name, value string) (*repository.Metrics, error) {
 		v, err := strconv.ParseFloat(value, 64)
 
 		if err != nil {
-			return &metricInfo, errors.New("invalid value")
+			return &metricInfo, fmt.Errorf("%w: %q is not float", repository.ErrInvalidValue, value)
 		}
 
 		metricInfo.ID = name
@@ -40,5 +62,99 @@ func ParseGauge(memType, name, value string) (*repository.Metrics, error) {
 		metricInfo.MType = memType
 		return &metricInfo, nil
 	}
-	return nil, errors.New("not gauge")
+	return nil, fmt.Errorf("%w: not gauge", repository.ErrInvalidType)
+}
+
+// ParseHistogram creates histogram with default bounds and a single observation of value.
+func ParseHistogram(memType, name, value string) (*repository.Metrics, error) {
+	var metricInfo = repository.Metrics{}
+	if memType == "histogram" {
+
+		v, err := strconv.ParseFloat(value, 64)
+
+		if err != nil {
+			return &metricInfo, fmt.Errorf("%w: %q is not float", repository.ErrInvalidValue, value)
+		}
+
+		h := histogram.New()
+		h.Observe(v)
+
+		metricInfo.ID = name
+		metricInfo.Histogram = h
+		metricInfo.MType = memType
+		return &metricInfo, nil
+	}
+	return nil, fmt.Errorf("%w: not histogram", repository.ErrInvalidType)
+}
+
+// ParseSummary creates summary sketch with default accuracy and a single value.
+func ParseSummary(memType, name, value string) (*repository.Metrics, error) {
+	var metricInfo = repository.Metrics{}
+	if memType == "summary" {
+
+		v, err := strconv.ParseFloat(value, 64)
+
+		if err != nil {
+			return &metricInfo, fmt.Errorf("%w: %q is not float", repository.ErrInvalidValue, value)
+		}
+
+		s, err := ddsketch.New(ddsketch.DefaultRelativeAccuracy)
+		if err != nil {
+			return &metricInfo, err
+		}
+		s.Add(v)
+
+		metricInfo.ID = name
+		metricInfo.Summary = s
+		metricInfo.MType = memType
+		return &metricInfo, nil
+	}
+	return nil, fmt.Errorf("%w: not summary", repository.ErrInvalidType)
+}
+
+// ParseSet creates set sketch with default precision and a single distinct value.
+func ParseSet(memType, name, value string) (*repository.Metrics, error) {
+	var metricInfo = repository.Metrics{}
+	if memType == "set" {
+
+		if value == "" {
+			return &metricInfo, fmt.Errorf("%w: empty set value", repository.ErrMissingValue)
+		}
+
+		s, err := hll.New(hll.DefaultPrecision)
+		if err != nil {
+			return &metricInfo, err
+		}
+		s.AddString(value)
+
+		metricInfo.ID = name
+		metricInfo.Set = s
+		metricInfo.MType = memType
+		return &metricInfo, nil
+	}
+	return nil, fmt.Errorf("%w: not set", repository.ErrInvalidType)
+}
+
+// validateBatch validates every metric of bulk update and checks
+// that the same ID is not sent with different types.
+func validateBatch(metrics []repository.Metrics) []error {
+	allErrors := make([]error, 0)
+	types := make(map[string]string, len(metrics))
+
+	for i := range metrics {
+		m := &metrics[i]
+		if err := m.Validate(); err != nil {
+			allErrors = append(allErrors, repository.NewItemError(i, m.ID, err))
+			continue
+		}
+
+		if t, ok := types[m.ID]; ok && t != m.MType {
+			err := fmt.Errorf("%w: %s sent as %s and %s", repository.ErrTypeConflict, m.ID, t, m.MType)
+			allErrors = append(allErrors, repository.NewItemError(i, m.ID, err))
+			continue
+		}
+		types[m.ID] = m.MType
+	}
+
+	return allErrors
 }
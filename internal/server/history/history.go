@@ -0,0 +1,121 @@
+// Package history хранит в памяти последние значения метрик для графиков дашборда.
+//
+// История собирается с момента запуска сервера через repository.ChangeHook и не сохраняется в хранилище.
+package history
+
+import (
+	"sync"
+	"time"
+
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+// DefaultSize count of points kept for every metric by default.
+const DefaultSize = 120
+
+// Point value of metric at update time.
+type Point struct {
+	Time  time.Time `json:"t"` // время обновления
+	Value float64   `json:"v"` // числовое значение метрики, см. Value
+}
+
+// Series history of metric.
+type Series struct {
+	UpdatedAt time.Time `json:"updated_at"` // время последнего обновления
+	Points    []Point   `json:"points"`     // последние значения, от старых к новым
+}
+
+// Recorder keeps last points of every metric.
+//
+// It's safe for concurrent use by multiple goroutines.
+type Recorder struct {
+	mu     sync.RWMutex
+	size   int
+	series map[string][]Point
+	now    func() time.Time
+}
+
+// New returns recorder keeping size last points of every metric.
+func New(size int) *Recorder {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Recorder{
+		size:   size,
+		series: make(map[string][]Point),
+		now:    time.Now,
+	}
+}
+
+// Size returns count of points kept for every metric.
+func (r *Recorder) Size() int {
+	return r.size
+}
+
+// Record appends new values of metrics, it's compatible with repository.ChangeHook.
+func (r *Recorder) Record(metrics []repository.Metrics) {
+	now := r.now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range metrics {
+		points := append(r.series[m.ID], Point{Time: now, Value: Value(m)})
+		if len(points) > r.size {
+			points = points[len(points)-r.size:]
+		}
+		r.series[m.ID] = points
+	}
+}
+
+// Get returns history of metric, false is returned if metric was not updated since start.
+func (r *Recorder) Get(id string) (Series, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	points, ok := r.series[id]
+	if !ok {
+		return Series{}, false
+	}
+	return newSeries(points), true
+}
+
+// All returns history of all metrics updated since start.
+func (r *Recorder) All() map[string]Series {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	res := make(map[string]Series, len(r.series))
+	for id, points := range r.series {
+		res[id] = newSeries(points)
+	}
+	return res
+}
+
+func newSeries(points []Point) Series {
+	copied := make([]Point, len(points))
+	copy(copied, points)
+	return Series{
+		UpdatedAt: copied[len(copied)-1].Time,
+		Points:    copied,
+	}
+}
+
+// Value returns numeric value of metric for charts:
+// gauge value, counter delta, histogram mean, summary median and set distinct count.
+func Value(m repository.Metrics) float64 {
+	switch {
+	case m.Value != nil:
+		return *m.Value
+	case m.Delta != nil:
+		return float64(*m.Delta)
+	case m.Histogram != nil:
+		return m.Histogram.Mean()
+	case m.Summary != nil:
+		v, _ := m.Summary.Quantile(0.5)
+		return v
+	case m.Set != nil:
+		return float64(m.Set.Estimate())
+	}
+	return 0
+}
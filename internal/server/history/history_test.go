@@ -0,0 +1,43 @@
+package history_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/internal/server/history"
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/pkg/histogram"
+)
+
+func TestRecorder(t *testing.T) {
+	r := history.New(3)
+
+	for i := int64(1); i <= 5; i++ {
+		delta := i
+		r.Record([]repository.Metrics{{ID: "poll", MType: "counter", Delta: &delta}})
+	}
+
+	s, ok := r.Get("poll")
+	require.True(t, ok)
+	require.Len(t, s.Points, 3)
+	assert.Equal(t, []float64{3, 4, 5}, []float64{s.Points[0].Value, s.Points[1].Value, s.Points[2].Value})
+	assert.Equal(t, s.Points[2].Time, s.UpdatedAt)
+
+	_, ok = r.Get("unknown")
+	assert.False(t, ok)
+
+	assert.Len(t, r.All(), 1)
+}
+
+func TestValue(t *testing.T) {
+	value := 1.5
+	h := histogram.New(1, 10)
+	h.Observe(2)
+	h.Observe(4)
+
+	assert.Equal(t, 1.5, history.Value(repository.Metrics{MType: "gauge", Value: &value}))
+	assert.Equal(t, 3.0, history.Value(repository.Metrics{MType: "histogram", Histogram: h}))
+	assert.Equal(t, 0.0, history.Value(repository.Metrics{MType: "counter"}))
+}
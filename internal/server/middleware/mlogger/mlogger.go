@@ -30,6 +30,11 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode
 }
 
+// Unwrap позволяет http.ResponseController добраться до Flush исходного writer
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 type LoggingMiddleware struct {
 	logger logger.Logger
 }
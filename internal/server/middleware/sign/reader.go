@@ -5,8 +5,12 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+
+	"github.com/benderr/metrics/pkg/problem"
 )
 
 // Миддлвар для проверки подписи получаемого запроса
@@ -22,7 +26,7 @@ func (h *signValidator) CheckSign(next http.Handler) http.Handler {
 
 			if err != nil {
 				h.logger.Errorln("decode hash error", err)
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				problem.Write(w, problem.New(http.StatusBadRequest, problem.CodeInvalidSign, "HashSHA256 is not hex encoded"))
 				return
 			}
 
@@ -32,9 +36,15 @@ func (h *signValidator) CheckSign(next http.Handler) http.Handler {
 
 			content, err := io.ReadAll(teeReader)
 
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				problem.Write(w, problem.New(http.StatusRequestEntityTooLarge, problem.CodeTooLarge, fmt.Sprintf("body is larger than %d bytes", tooLarge.Limit)))
+				return
+			}
+
 			if err != nil {
 				h.logger.Errorln("can't read body", err)
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				problem.Write(w, problem.New(http.StatusBadRequest, problem.CodeBadRequest, err.Error()))
 				return
 			}
 
@@ -44,7 +54,7 @@ func (h *signValidator) CheckSign(next http.Handler) http.Handler {
 
 			if !hmac.Equal(sign, signFromBody) {
 				h.logger.Infow("invalid sign", "sign", sign)
-				http.Error(w, "invalid sign", http.StatusBadRequest)
+				problem.Write(w, problem.New(http.StatusBadRequest, problem.CodeInvalidSign, "invalid sign"))
 				return
 			}
 
@@ -0,0 +1,164 @@
+package dbstorage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+// copyThreshold minimal size of batch loaded with COPY, smaller batches are faster with prepared upsert
+const copyThreshold = 100
+
+// stagedMetric row of metrics_staging table, duplicates of batch are already folded
+type stagedMetric struct {
+	index int // позиция первого вхождения метрики в пачке
+	id    string
+	mtype string
+	delta *int64
+	value *float64
+	set   bool // значение перезаписывает сохраненное (op=set встречался в пачке)
+}
+
+// copyUpdate applies batch in transaction: counters and gauges are loaded with COPY into temporary staging table
+// and merged with a single INSERT ... SELECT ... ON CONFLICT.
+//
+// Sketches can't be merged by sql, they are applied one by one in the same transaction, see updateSketch.
+func copyUpdate(ctx context.Context, tx pgx.Tx, metrics []repository.Metrics) error {
+	scalars := make([]repository.Metrics, 0, len(metrics))
+	indexes := make([]int, 0, len(metrics))
+	for i, mtr := range metrics {
+		if isSketch(mtr.MType) {
+			if err := updateSketch(ctx, tx, mtr); err != nil {
+				return repository.NewItemError(i, mtr.ID, err)
+			}
+			continue
+		}
+		scalars = append(scalars, mtr)
+		indexes = append(indexes, i)
+	}
+
+	if len(scalars) == 0 {
+		return nil
+	}
+
+	staged, err := foldBatch(scalars)
+	if err != nil {
+		return remapItemError(err, indexes)
+	}
+
+	return remapItemError(copyStaged(ctx, tx, staged), indexes)
+}
+
+// remapItemError converts index of item among counters and gauges to index in the whole batch
+func remapItemError(err error, indexes []int) error {
+	if itemErr, ok := err.(*repository.ItemError); ok {
+		return repository.NewItemError(indexes[itemErr.Index], itemErr.ID, itemErr.Err)
+	}
+	return err
+}
+
+// copyStaged loads rows to staging table and merges them into metrics
+func copyStaged(ctx context.Context, tx pgx.Tx, staged []stagedMetric) error {
+	_, err := tx.Exec(ctx, `CREATE TEMP TABLE metrics_staging
+	(
+		id text PRIMARY KEY,
+		type text NOT NULL,
+		delta bigint,
+		value double precision,
+		set boolean NOT NULL
+	) ON COMMIT DROP`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"metrics_staging"},
+		[]string{"id", "type", "delta", "value", "set"},
+		pgx.CopyFromSlice(len(staged), func(i int) ([]any, error) {
+			s := staged[i]
+			return []any{s.id, s.mtype, s.delta, s.value, s.set}, nil
+		}))
+	if err != nil {
+		return err
+	}
+
+	// метрика другого типа не обновляется и не попадает в RETURNING
+	rows, err := tx.Query(ctx, `INSERT INTO metrics (id, type, delta, value)
+	SELECT id, type, delta, value FROM metrics_staging
+	ON CONFLICT (id)
+	DO UPDATE SET
+		delta=CASE WHEN (SELECT set FROM metrics_staging s WHERE s.id = excluded.id) THEN excluded.delta ELSE metrics.delta + excluded.delta END,
+		value=excluded.value
+	WHERE metrics.type = excluded.type
+	RETURNING id`)
+	if err != nil {
+		return err
+	}
+
+	updated, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	if len(updated) == len(staged) {
+		return nil
+	}
+
+	done := make(map[string]struct{}, len(updated))
+	for _, id := range updated {
+		done[id] = struct{}{}
+	}
+	for _, s := range staged {
+		if _, ok := done[s.id]; !ok {
+			err := fmt.Errorf("%w: %s is not %s", repository.ErrTypeConflict, s.id, s.mtype)
+			return repository.NewItemError(s.index, s.id, err)
+		}
+	}
+	return nil
+}
+
+// foldBatch merges items of batch with the same ID in order of batch:
+// counter deltas are summed (op=set drops previous sum), the last gauge value wins.
+func foldBatch(metrics []repository.Metrics) ([]stagedMetric, error) {
+	res := make([]stagedMetric, 0, len(metrics))
+	byID := make(map[string]int, len(metrics))
+
+	for i, mtr := range metrics {
+		pos, ok := byID[mtr.ID]
+		if !ok {
+			byID[mtr.ID] = len(res)
+			s := stagedMetric{index: i, id: mtr.ID, mtype: mtr.MType, value: mtr.Value, set: mtr.Op == repository.OpSet}
+			if mtr.Delta != nil {
+				delta := *mtr.Delta
+				s.delta = &delta
+			}
+			res = append(res, s)
+			continue
+		}
+
+		s := &res[pos]
+		if s.mtype != mtr.MType {
+			err := fmt.Errorf("%w: %s sent as %s and %s", repository.ErrTypeConflict, mtr.ID, s.mtype, mtr.MType)
+			return nil, repository.NewItemError(i, mtr.ID, err)
+		}
+
+		s.value = mtr.Value
+		if mtr.Op == repository.OpSet {
+			s.set = true
+			s.delta = nil
+		}
+		if mtr.Delta != nil {
+			var delta int64
+			if s.delta != nil {
+				delta = *s.delta
+			}
+			delta += *mtr.Delta
+			s.delta = &delta
+		}
+	}
+
+	return res, nil
+}
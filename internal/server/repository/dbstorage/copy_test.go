@@ -0,0 +1,110 @@
+package dbstorage
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+func TestFoldBatch(t *testing.T) {
+	one, two := int64(1), int64(2)
+	v1, v2 := 1.5, 2.5
+
+	staged, err := foldBatch([]repository.Metrics{
+		{ID: "poll", MType: "counter", Delta: &one},
+		{ID: "load", MType: "gauge", Value: &v1},
+		{ID: "poll", MType: "counter", Delta: &two},
+		{ID: "load", MType: "gauge", Value: &v2},
+		{ID: "fixed", MType: "counter", Delta: &two},
+		{ID: "fixed", MType: "counter", Delta: &one, Op: repository.OpSet},
+		{ID: "fixed", MType: "counter", Delta: &one},
+	})
+	require.NoError(t, err)
+	require.Len(t, staged, 3)
+	assert.Equal(t, int64(3), *staged[0].delta, "summed counter")
+	assert.False(t, staged[0].set)
+	assert.Equal(t, 2.5, *staged[1].value, "last gauge value")
+	assert.Equal(t, int64(2), *staged[2].delta, "counter set to 2")
+	assert.True(t, staged[2].set)
+	assert.Equal(t, 4, staged[2].index)
+
+	_, err = foldBatch([]repository.Metrics{
+		{ID: "poll", MType: "counter", Delta: &one},
+		{ID: "poll", MType: "gauge", Value: &v1},
+	})
+	var itemErr *repository.ItemError
+	require.ErrorAs(t, err, &itemErr, "type conflict")
+	assert.Equal(t, 1, itemErr.Index)
+}
+
+// BenchmarkBulkUpdate compares prepared upsert per item with COPY,
+// it requires postgres: DATABASE_DSN=postgres://... go test -bench BulkUpdate
+func BenchmarkBulkUpdate(b *testing.B) {
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		b.Skip("DATABASE_DSN is not set")
+	}
+
+	ctx := context.Background()
+	pool, err := NewPool(ctx, dsn, PoolConfig{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pool.Close()
+
+	repo := New(pool, nil)
+	if err := repo.Prepare(ctx); err != nil {
+		b.Fatal(err)
+	}
+
+	// write mock items to batch
+	var delta int64 = 1
+	val1 := 100.1200
+	metrics := make([]repository.Metrics, 0, 10000)
+	for i := 0; i < 5000; i++ {
+		metrics = append(metrics,
+			repository.Metrics{ID: "bench_counter_" + strconv.Itoa(i), MType: "counter", Delta: &delta},
+			repository.Metrics{ID: "bench_gauge_" + strconv.Itoa(i), MType: "gauge", Value: &val1},
+		)
+	}
+
+	b.ResetTimer()
+
+	b.Run("prepared upsert", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			runTx(ctx, b, pool, func(tx pgx.Tx) error {
+				return upsertEach(ctx, tx, metrics)
+			})
+		}
+	})
+	b.Run("copy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			runTx(ctx, b, pool, func(tx pgx.Tx) error {
+				return copyUpdate(ctx, tx, metrics)
+			})
+		}
+	})
+}
+
+func runTx(ctx context.Context, b *testing.B, pool *pgxpool.Pool, fn func(tx pgx.Tx) error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		b.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		b.Fatal(err)
+	}
+}
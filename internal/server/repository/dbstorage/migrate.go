@@ -0,0 +1,189 @@
+package dbstorage
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// LatestVersion target of Migrate to apply all migrations.
+const LatestVersion = -1
+
+// migrationLockID key of advisory lock, so concurrently started servers don't apply migrations twice
+const migrationLockID = 7312605
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change, files are named {version}_{name}.up.sql and {version}_{name}.down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string // sql применения миграции
+	Down    string // sql отката миграции
+}
+
+// Migrations returns embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		parts := migrationName.FindStringSubmatch(e.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", e.Name())
+		}
+
+		version, _ := strconv.Atoi(parts[1])
+		content, err := migrationFiles.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		}
+		if m.Name != parts[2] {
+			return nil, fmt.Errorf("migration %d has different names %s and %s", version, m.Name, parts[2])
+		}
+
+		if parts[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	res := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s requires up and down files", m.Version, m.Name)
+		}
+		res = append(res, *m)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Version < res[j].Version
+	})
+
+	return res, nil
+}
+
+// Migrate applies or rolls back migrations to make schema of target version, LatestVersion applies all of them.
+//
+// Applied versions are stored in schema_migrations table, every migration runs in its own transaction.
+func (m *MetricDBRepository) Migrate(ctx context.Context, target int) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	if target < LatestVersion {
+		return fmt.Errorf("invalid schema version %d", target)
+	}
+	if target == LatestVersion && len(migrations) > 0 {
+		target = migrations[len(migrations)-1].Version
+	}
+
+	// блокировка сессионная, поэтому все запросы выполняются в одном соединении
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations
+	(
+		version bigint NOT NULL,
+		name text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now(),
+		CONSTRAINT schema_migrations_pkey PRIMARY KEY (version)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, mg := range migrations {
+		if mg.Version > target || applied[mg.Version] {
+			continue
+		}
+		err := runMigration(ctx, conn, mg.Up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", mg.Version, mg.Name)
+		if err != nil {
+			return fmt.Errorf("apply migration %d_%s: %w", mg.Version, mg.Name, err)
+		}
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		mg := migrations[i]
+		if mg.Version <= target || !applied[mg.Version] {
+			continue
+		}
+		err := runMigration(ctx, conn, mg.Down, "DELETE FROM schema_migrations WHERE version = $1", mg.Version)
+		if err != nil {
+			return fmt.Errorf("rollback migration %d_%s: %w", mg.Version, mg.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// appliedVersions returns versions from schema_migrations
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int]bool, error) {
+	rows, err := conn.Query(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		res[v] = true
+	}
+	return res, rows.Err()
+}
+
+// runMigration executes migration sql and updates schema_migrations in one transaction
+func runMigration(ctx context.Context, conn *pgxpool.Conn, query, record string, args ...any) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	// запрос без параметров выполняется простым протоколом, поэтому файл может содержать несколько команд
+	if _, err = tx.Exec(ctx, query); err == nil {
+		_, err = tx.Exec(ctx, record, args...)
+	}
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
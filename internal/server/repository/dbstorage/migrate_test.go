@@ -0,0 +1,23 @@
+package dbstorage_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/internal/server/repository/dbstorage"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := dbstorage.Migrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "versions must be sequential")
+		assert.NotEmpty(t, m.Up, m.Name)
+		assert.NotEmpty(t, m.Down, m.Name)
+	}
+	assert.Equal(t, "create_metrics", migrations[0].Name)
+}
@@ -5,4 +5,4 @@ CREATE TABLE IF NOT EXISTS metrics
     delta bigint,
     value double precision,
     CONSTRAINT metrics_pkey PRIMARY KEY (id)
-)
\ No newline at end of file
+);
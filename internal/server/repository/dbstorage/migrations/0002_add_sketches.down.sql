@@ -0,0 +1,5 @@
+ALTER TABLE metrics DROP COLUMN IF EXISTS hll;
+
+ALTER TABLE metrics DROP COLUMN IF EXISTS summary;
+
+ALTER TABLE metrics DROP COLUMN IF EXISTS histogram;
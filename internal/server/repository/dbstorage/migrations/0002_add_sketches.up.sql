@@ -0,0 +1,5 @@
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS histogram jsonb;
+
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS summary bytea;
+
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS hll bytea;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS metrics_id_c;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS metrics_id_c ON metrics (id COLLATE "C");
@@ -0,0 +1,79 @@
+package dbstorage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+// PoolConfig settings of connection pool, zero values keep settings of DSN (pool_max_conns etc.) or pgxpool defaults.
+type PoolConfig struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration // период проверки простаивающих соединений
+	StatementCache    int           // размер кеша подготовленных запросов соединения, отрицательный отключает кеш
+}
+
+// NewPool creates connection pool of dsn, connections are established lazily.
+func NewPool(ctx context.Context, dsn string, cfg PoolConfig) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxConns < 0 || cfg.MinConns < 0 || (cfg.MaxConns > 0 && cfg.MinConns > cfg.MaxConns) {
+		return nil, fmt.Errorf("invalid pool size: min %d, max %d", cfg.MinConns, cfg.MaxConns)
+	}
+	if cfg.MaxConns > 0 {
+		poolConfig.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		poolConfig.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+
+	switch {
+	case cfg.StatementCache > 0:
+		poolConfig.ConnConfig.StatementCacheCapacity = cfg.StatementCache
+	case cfg.StatementCache < 0:
+		// без подготовленных запросов, например для pgbouncer в режиме transaction
+		poolConfig.ConnConfig.StatementCacheCapacity = 0
+		poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeExec
+	}
+
+	return pgxpool.NewWithConfig(ctx, poolConfig)
+}
+
+// PoolStats returns statistics of connection pool.
+func (m *MetricDBRepository) PoolStats() repository.PoolStats {
+	s := m.pool.Stat()
+	return repository.PoolStats{
+		MaxConns:             s.MaxConns(),
+		TotalConns:           s.TotalConns(),
+		IdleConns:            s.IdleConns(),
+		AcquiredConns:        s.AcquiredConns(),
+		ConstructingConns:    s.ConstructingConns(),
+		AcquireCount:         s.AcquireCount(),
+		EmptyAcquireCount:    s.EmptyAcquireCount(),
+		CanceledAcquireCount: s.CanceledAcquireCount(),
+		AcquireDurationMs:    float64(s.AcquireDuration()) / float64(time.Millisecond),
+		NewConnsCount:        s.NewConnsCount(),
+		MaxLifetimeDestroyed: s.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyed:     s.MaxIdleDestroyCount(),
+	}
+}
@@ -0,0 +1,240 @@
+package dbstorage
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+// List returns page of metrics matching query.
+//
+// Filters are applied in SQL except regex, which is checked with repository.ListQuery.Matcher,
+// so its syntax is RE2 as for other storages. Metrics are ordered by id in byte order (COLLATE "C"),
+// so cursors are consistent with in-memory storages.
+func (m *MetricDBRepository) List(ctx context.Context, q repository.ListQuery) (*repository.ListPage, error) {
+	metrics := make([]repository.Metrics, 0)
+	err := m.scan(ctx, q, func(v repository.Metrics) error {
+		metrics = append(metrics, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &repository.ListPage{Metrics: metrics}
+	// запрашивается на одну запись больше лимита, чтобы узнать о следующей странице
+	if q.Limit > 0 && len(metrics) > q.Limit {
+		page.Metrics = metrics[:q.Limit]
+		page.NextCursor = page.Metrics[q.Limit-1].ID
+	}
+	return page, nil
+}
+
+// Iterate calls fn for every metric matching query in ID order, iteration stops on first error of fn.
+//
+// Rows are read with a cursor one by one, so the whole table is never loaded into memory.
+func (m *MetricDBRepository) Iterate(ctx context.Context, q repository.ListQuery, fn func(m repository.Metrics) error) error {
+	count := 0
+	return m.scan(ctx, q, func(v repository.Metrics) error {
+		// лишняя запись для курсора следующей страницы не передается
+		if q.Limit > 0 && count == q.Limit {
+			return nil
+		}
+		count++
+		return fn(v)
+	})
+}
+
+// regexChunk количество строк, читаемых одним запросом, если regex проверяется в Go
+const regexChunk = 1000
+
+// scan reads matching metrics, at most q.Limit+1 metrics are passed to fn.
+//
+// Regex is checked by Matcher, then rows are read in keyset pages of regexChunk rows
+// until enough metrics are matched, so the whole table isn't read for one page.
+func (m *MetricDBRepository) scan(ctx context.Context, q repository.ListQuery, fn func(m repository.Metrics) error) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+
+	limit := regexChunk
+	if q.Regex == "" {
+		limit = 0
+		if q.Limit > 0 {
+			limit = q.Limit + 1
+		}
+	}
+
+	match := q.Matcher()
+	count := 0
+	page := q
+	for {
+		read, err := m.scanPage(ctx, page, limit, func(v *repository.Metrics) (bool, error) {
+			page.Cursor = v.ID
+			if !match(v) {
+				return true, nil
+			}
+			count++
+			if err := fn(*v); err != nil {
+				return false, err
+			}
+			return q.Limit == 0 || count <= q.Limit, nil
+		})
+		if err != nil {
+			return err
+		}
+		if q.Regex == "" || read < limit || (q.Limit > 0 && count > q.Limit) {
+			return nil
+		}
+	}
+}
+
+// scanPage reads at most limit rows of query (0 - without limit) and returns count of read rows,
+// reading stops when fn returns false or error
+func (m *MetricDBRepository) scanPage(ctx context.Context, q repository.ListQuery, limit int, fn func(v *repository.Metrics) (bool, error)) (int, error) {
+	query, args := buildListQuery(q, limit)
+	rows, err := m.pool.Query(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	read := 0
+	for rows.Next() {
+		v, err := scanMetric(rows)
+		if err != nil {
+			return read, err
+		}
+		read++
+
+		next, err := fn(v)
+		if err != nil || !next {
+			return read, err
+		}
+	}
+	return read, rows.Err()
+}
+
+// buildListQuery returns SELECT with filters of query and its arguments, limit 0 means no LIMIT.
+//
+// Regex isn't translated: POSIX syntax of Postgres differs from RE2, so rows are filtered by Matcher.
+// Prefix and cursor are ranges of id in byte order (COLLATE "C"), they use index metrics_id_c.
+func buildListQuery(q repository.ListQuery, limit int) (string, []any) {
+	where := make([]string, 0)
+	args := make([]any, 0)
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if q.Prefix != "" {
+		where = append(where, `id COLLATE "C" >= `+arg(q.Prefix))
+		if end, ok := prefixEnd(q.Prefix); ok {
+			where = append(where, `id COLLATE "C" < `+arg(end))
+		}
+	}
+	if q.Glob != "" {
+		where = append(where, "id ~ "+arg(globToRegex(q.Glob)))
+	}
+	if len(q.Types) > 0 {
+		where = append(where, "type = ANY("+arg(q.Types)+")")
+	}
+
+	order := "ASC"
+	if q.Cursor != "" {
+		op := ">"
+		if q.Desc {
+			op = "<"
+		}
+		where = append(where, `id COLLATE "C" `+op+" "+arg(q.Cursor))
+	}
+	if q.Desc {
+		order = "DESC"
+	}
+
+	var b strings.Builder
+	b.WriteString("SELECT id, type, delta, value, histogram, summary, hll FROM metrics")
+	if len(where) > 0 {
+		b.WriteString(" WHERE " + strings.Join(where, " AND "))
+	}
+	b.WriteString(` ORDER BY id COLLATE "C" ` + order)
+	if limit > 0 {
+		b.WriteString(" LIMIT " + arg(limit))
+	}
+
+	return b.String(), args
+}
+
+// prefixEnd returns the least string greater than all strings with prefix in code point order,
+// false is returned if there is no such string. Result is valid UTF-8, so it can be passed as text.
+func prefixEnd(prefix string) (string, bool) {
+	runes := []rune(prefix)
+	for i := len(runes) - 1; i >= 0; i-- {
+		r := runes[i] + 1
+		if r >= 0xD800 && r <= 0xDFFF {
+			// суррогаты не кодируются в UTF-8
+			r = 0xE000
+		}
+		if r <= utf8.MaxRune {
+			runes[i] = r
+			return string(runes[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+// globToRegex converts valid path.Match pattern to anchored Postgres regular expression matching the same strings.
+//
+// Wildcards don't match / as in path.Match, character class is rebuilt with escaped characters.
+func globToRegex(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	runes := []rune(glob)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*':
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			b.WriteString("[")
+			i++
+			if runes[i] == '^' {
+				b.WriteString("^")
+				i++
+			}
+			// класс продолжается до ], символы классов a-z, \- и т.п. экранируются для Postgres
+			for ; runes[i] != ']'; i++ {
+				if runes[i] == '-' {
+					b.WriteString("-")
+					continue
+				}
+				if runes[i] == '\\' {
+					i++
+				}
+				b.WriteString(escapeClass(runes[i]))
+			}
+			b.WriteString("]")
+		case '\\':
+			i++
+			b.WriteString(regexp.QuoteMeta(string(runes[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// escapeClass escapes character special in bracket expression, in Postgres regex \ keeps its meaning inside brackets
+func escapeClass(r rune) string {
+	switch r {
+	case '\\', ']', '[', '^', '-':
+		return `\` + string(r)
+	}
+	return string(r)
+}
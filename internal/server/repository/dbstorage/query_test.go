@@ -0,0 +1,65 @@
+package dbstorage
+
+import (
+	"path"
+	"regexp"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+func TestGlobToRegex(t *testing.T) {
+	tests := []struct {
+		glob string
+		want string
+	}{
+		{"cpu.*", `^cpu\.[^/]*$`},
+		{"cpu?", `^cpu[^/]$`},
+		{"cpu[0-9]", `^cpu[0-9]$`},
+		{"cpu[^\\]\\-]", `^cpu[^\]\-]$`},
+		{"a\\*b", `^a\*b$`},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, globToRegex(tt.glob), "glob %q", tt.glob)
+	}
+
+	// регулярное выражение совпадает с теми же ID, что и path.Match
+	ids := []string{"cpu", "cpu.1", "cpu/1", "cpu.a/b", "cpu-", "cpu]", "cpu7", "a*b", "aab", "ёж.1"}
+	for _, glob := range []string{"cpu.*", "cpu?", "cpu*", "cpu[0-9]", "cpu[^\\]\\-]", "a\\*b", "ёж.?", "*"} {
+		re := regexp.MustCompile(globToRegex(glob))
+		for _, id := range ids {
+			want, _ := path.Match(glob, id)
+			assert.Equal(t, want, re.MatchString(id), "glob %q, id %q", glob, id)
+		}
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+		ok     bool
+	}{
+		{"cpu", "cpv", true},
+		{"cpu.\U0010FFFF", "cpu/", true},
+		{"a\uD7FF", "a\uE000", true},
+		{"\U0010FFFF", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := prefixEnd(tt.prefix)
+		assert.Equal(t, tt.ok, ok, tt.prefix)
+		assert.Equal(t, tt.want, got, tt.prefix)
+		assert.True(t, utf8.ValidString(got), tt.prefix)
+	}
+}
+
+func TestBuildListQuery(t *testing.T) {
+	query, args := buildListQuery(repository.ListQuery{Prefix: "cpu", Cursor: "cpu.1", Regex: "x", Limit: 10}, 1000)
+	assert.Equal(t, `SELECT id, type, delta, value, histogram, summary, hll FROM metrics`+
+		` WHERE id COLLATE "C" >= $1 AND id COLLATE "C" < $2 AND id COLLATE "C" > $3`+
+		` ORDER BY id COLLATE "C" ASC LIMIT $4`, query)
+	assert.Equal(t, []any{"cpu", "cpv", "cpu.1", 1000}, args)
+}
@@ -2,11 +2,11 @@ package dbstorage
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/benderr/metrics/internal/server/repository"
 	"github.com/benderr/metrics/pkg/retry"
@@ -14,13 +14,9 @@ import (
 
 // Оборачиваем репозиторий с добавлением возможности повтора операции при ошибках
 // В качестве примера сделал для трех операций
-func NewWithRetry(db *sql.DB, log repository.Logger) *MetricDBWithRetryRepository {
-	repo := &MetricDBRepository{
-		db:  db,
-		log: log,
-	}
+func NewWithRetry(pool *pgxpool.Pool, log repository.Logger) *MetricDBWithRetryRepository {
 	return &MetricDBWithRetryRepository{
-		MetricDBRepository: repo,
+		MetricDBRepository: New(pool, log),
 	}
 }
 
@@ -40,6 +36,18 @@ func (m *MetricDBWithRetryRepository) BulkUpdate(ctx context.Context, metrics []
 	}, canRetry)
 }
 
+func (m *MetricDBWithRetryRepository) BulkUpdateOnce(ctx context.Context, key string, metrics []repository.Metrics) error {
+	return retry.Do(func() error {
+		return m.MetricDBRepository.BulkUpdateOnce(ctx, key, metrics)
+	}, canRetry)
+}
+
+func (m *MetricDBWithRetryRepository) Replace(ctx context.Context, metrics []repository.Metrics) error {
+	return retry.Do(func() error {
+		return m.MetricDBRepository.Replace(ctx, metrics)
+	}, canRetry)
+}
+
 func (m *MetricDBWithRetryRepository) PingContext(ctx context.Context) error {
 	return retry.Do(func() error {
 		return m.MetricDBRepository.PingContext(ctx)
@@ -2,23 +2,43 @@ package dbstorage
 
 import (
 	"context"
-	"database/sql"
+	"encoding/json"
 	"errors"
-	"os"
+	"fmt"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/benderr/metrics/internal/server/dump"
 	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/pkg/ddsketch"
+	"github.com/benderr/metrics/pkg/histogram"
+	"github.com/benderr/metrics/pkg/hll"
 )
 
-// MetricDBRepository is a database handle, which implements MetricRepository
+// upsertQuery inserts counter or gauge, delta of existing counter is added or overwritten if $5 (op=set) is true.
+// Metric of another type is not updated, see checkTypeConflict.
+const upsertQuery = `INSERT INTO metrics (id, type, delta, value)
+	VALUES($1, $2, $3, $4)
+	ON CONFLICT (id)
+	DO UPDATE SET delta=CASE WHEN $5 THEN $3 ELSE metrics.delta + $3 END, value=$4
+	WHERE metrics.type = $2`
+
+// MetricDBRepository is a connection pool handle, which implements MetricRepository
 type MetricDBRepository struct {
-	db  *sql.DB
-	log repository.Logger
+	repository.Notifier
+	pool *pgxpool.Pool
+	log  repository.Logger
+
+	stop context.CancelFunc // останавливает фоновые задачи, nil если они не запущены
+	done chan struct{}      // закрывается после остановки фоновых задач
 }
 
-func New(db *sql.DB, log repository.Logger) *MetricDBRepository {
+func New(pool *pgxpool.Pool, log repository.Logger) *MetricDBRepository {
 	return &MetricDBRepository{
-		db:  db,
-		log: log,
+		pool: pool,
+		log:  log,
 	}
 }
 
@@ -26,31 +46,39 @@ func New(db *sql.DB, log repository.Logger) *MetricDBRepository {
 //
 // If metric exist, then update delta and value field, otherwise new metric inserted
 func (m *MetricDBRepository) Update(ctx context.Context, mtr repository.Metrics) (*repository.Metrics, error) {
-	delta := sql.NullInt64{}
-	value := sql.NullFloat64{}
-	if mtr.Delta != nil {
-		delta = sql.NullInt64{Valid: true, Int64: *mtr.Delta}
+	if err := m.update(ctx, mtr); err != nil {
+		return nil, err
 	}
 
-	if mtr.Value != nil {
-		value = sql.NullFloat64{Valid: true, Float64: *mtr.Value}
+	res, err := m.Get(ctx, mtr.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if res != nil {
+		m.Notify(*res)
+	}
+	return res, nil
+}
+
+func (m *MetricDBRepository) update(ctx context.Context, mtr repository.Metrics) error {
+	if isSketch(mtr.MType) {
+		return m.updateSketchTx(ctx, mtr)
 	}
 
-	_, err := m.db.ExecContext(ctx, `INSERT INTO metrics (id, type, delta, value)
-	VALUES($1, $2, $3, $4) 
-	ON CONFLICT (id) 
-	DO 
-	   UPDATE SET delta=metrics.delta + $3, value=$4`, mtr.ID, mtr.MType, delta, value)
+	res, err := m.pool.Exec(ctx, upsertQuery, mtr.ID, mtr.MType, mtr.Delta, mtr.Value, mtr.Op == repository.OpSet)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return m.Get(ctx, mtr.ID)
+	return checkTypeConflict(res, mtr)
 }
 
 // BulkUpdate insert or update slice of metric.
 //
+// Large batches are loaded with COPY, see copyUpdate, small ones are applied with prepared upsert per item.
+//
 // Warning! Method starts a transaction, if one of executes a prepared statement failed, then transaction rollback
 func (m *MetricDBRepository) BulkUpdate(ctx context.Context, metrics []repository.Metrics) error {
 
@@ -58,74 +86,212 @@ func (m *MetricDBRepository) BulkUpdate(ctx context.Context, metrics []repositor
 		return nil
 	}
 
-	tx, err := m.db.BeginTx(ctx, nil)
+	tx, err := m.pool.Begin(ctx)
 
 	if err != nil {
 		return err
 	}
 
-	stmt, err := tx.PrepareContext(ctx, `INSERT INTO metrics (id, type, delta, value)
-	VALUES($1, $2, $3, $4) 
-	ON CONFLICT (id) 
-	DO UPDATE SET delta=metrics.delta + $3, value=$4`)
+	if err = bulkUpdate(ctx, tx, metrics); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	err = tx.Commit(ctx)
 
 	if err != nil {
 		return err
 	}
 
-	for _, mtr := range metrics {
-		delta := sql.NullInt64{}
-		value := sql.NullFloat64{}
-		if mtr.Delta != nil {
-			delta = sql.NullInt64{Valid: true, Int64: *mtr.Delta}
+	m.notifyBulk(ctx, metrics)
+	return nil
+}
+
+// BulkUpdateOnce applies batch like BulkUpdate if key was not used within repository.IdempotencyTTL,
+// otherwise error wrapping repository.ErrDuplicate is returned.
+//
+// Key is written in the same transaction as metrics, so it's saved only if batch is committed.
+// Concurrent request with the same key waits for the first transaction on unique index.
+func (m *MetricDBRepository) BulkUpdateOnce(ctx context.Context, key string, metrics []repository.Metrics) error {
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = reserveKey(ctx, tx, key); err == nil {
+		err = bulkUpdate(ctx, tx, metrics)
+	}
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	m.notifyBulk(ctx, metrics)
+	return nil
+}
+
+// reserveKeyQuery saves key, expired key is taken over, so expired keys are removed only by periodic sweep
+const reserveKeyQuery = `INSERT INTO idempotency_keys (key) VALUES ($1)
+	ON CONFLICT (key) DO UPDATE SET created_at = now()
+	WHERE idempotency_keys.created_at < now() - $2::interval`
+
+// keysSweepInterval период удаления просроченных ключей, см. Start
+const keysSweepInterval = 60
+
+// reserveKey saves new key, ErrDuplicate is returned if key exists and not expired
+func reserveKey(ctx context.Context, tx pgx.Tx, key string) error {
+	res, err := tx.Exec(ctx, reserveKeyQuery, key, idempotencyTTL())
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", repository.ErrDuplicate, key)
+	}
+	return nil
+}
+
+// sweepKeys removes expired idempotency keys
+func (m *MetricDBRepository) sweepKeys(ctx context.Context) error {
+	if _, err := m.pool.Exec(ctx, "DELETE FROM idempotency_keys WHERE created_at < now() - $1::interval", idempotencyTTL()); err != nil {
+		m.log.Errorln("idempotency keys sweep error", err)
+		return err
+	}
+	return nil
+}
+
+// idempotencyTTL returns repository.IdempotencyTTL as postgres interval
+func idempotencyTTL() string {
+	return fmt.Sprintf("%d seconds", int(repository.IdempotencyTTL.Seconds()))
+}
+
+// Replace removes all metrics and stores given ones in one transaction.
+func (m *MetricDBRepository) Replace(ctx context.Context, metrics []repository.Metrics) error {
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(ctx, "DELETE FROM metrics"); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	if err = bulkUpdate(ctx, tx, metrics); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	m.notifyBulk(ctx, metrics)
+	return nil
+}
+
+// bulkUpdate upserts metrics in transaction, error of item is returned as *repository.ItemError.
+//
+// Batch of copyThreshold items and more is loaded with COPY, see copyUpdate.
+func bulkUpdate(ctx context.Context, tx pgx.Tx, metrics []repository.Metrics) error {
+	if len(metrics) >= copyThreshold {
+		return copyUpdate(ctx, tx, metrics)
+	}
+	return upsertEach(ctx, tx, metrics)
+}
+
+// upsertEach applies metrics one by one, error of item is returned as *repository.ItemError
+func upsertEach(ctx context.Context, tx pgx.Tx, metrics []repository.Metrics) error {
+	// upsertQuery подготавливается один раз и берется из кеша запросов соединения
+	for i, mtr := range metrics {
+		if isSketch(mtr.MType) {
+			if err := updateSketch(ctx, tx, mtr); err != nil {
+				return repository.NewItemError(i, mtr.ID, err)
+			}
+			continue
 		}
 
-		if mtr.Value != nil {
-			value = sql.NullFloat64{Valid: true, Float64: *mtr.Value}
+		res, err := tx.Exec(ctx, upsertQuery, mtr.ID, mtr.MType, mtr.Delta, mtr.Value, mtr.Op == repository.OpSet)
+
+		if err == nil {
+			err = checkTypeConflict(res, mtr)
 		}
-		_, err2 := stmt.ExecContext(ctx, mtr.ID, mtr.MType, delta, value)
 
-		if err2 != nil {
-			stmt.Close()
-			return err2
+		if err != nil {
+			return repository.NewItemError(i, mtr.ID, err)
 		}
 	}
 
-	err = stmt.Close()
+	return nil
+}
+
+// notifyBulk загружает новые значения метрик пачки одним запросом и передает их подписчикам
+func (m *MetricDBRepository) notifyBulk(ctx context.Context, metrics []repository.Metrics) {
+	if !m.HasHooks() {
+		return
+	}
+
+	seen := make(map[string]struct{}, len(metrics))
+	ids := make([]string, 0, len(metrics))
+	for _, mtr := range metrics {
+		if _, ok := seen[mtr.ID]; !ok {
+			seen[mtr.ID] = struct{}{}
+			ids = append(ids, mtr.ID)
+		}
+	}
 
+	updated, err := m.getMany(ctx, ids)
 	if err != nil {
-		return err
+		m.log.Errorln("load updated metrics error", err)
+		return
 	}
 
-	err = tx.Commit()
+	m.Notify(updated...)
+}
 
+// getMany returns existed metrics with given IDs ordered by ID
+func (m *MetricDBRepository) getMany(ctx context.Context, ids []string) ([]repository.Metrics, error) {
+	rows, err := m.pool.Query(ctx, "SELECT id, type, delta, value, histogram, summary, hll from metrics WHERE id = ANY($1) ORDER BY id", ids)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return err
+	defer rows.Close()
+
+	metrics := make([]repository.Metrics, 0, len(ids))
+	for rows.Next() {
+		v, err := scanMetric(rows)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, *v)
+	}
+	return metrics, rows.Err()
 }
 
 // Get return pointer of existed metric by ID or return nil
 func (m *MetricDBRepository) Get(ctx context.Context, id string) (*repository.Metrics, error) {
-	row := m.db.QueryRowContext(ctx, "SELECT id, type, delta, value from metrics WHERE id = $1", id)
-	var v repository.Metrics
-	err := row.Scan(&v.ID, &v.MType, &v.Delta, &v.Value)
+	row := m.pool.QueryRow(ctx, "SELECT id, type, delta, value, histogram, summary, hll from metrics WHERE id = $1", id)
+	v, err := scanMetric(row)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
 
 		return nil, err
 	}
 
-	return &v, nil
+	return v, nil
 }
 
 // GetList return all existed metrics in db
 func (m *MetricDBRepository) GetList(ctx context.Context) ([]repository.Metrics, error) {
 	metrics := make([]repository.Metrics, 0)
 
-	rows, err := m.db.QueryContext(ctx, "SELECT id, type, delta, value from metrics ORDER BY id")
+	rows, err := m.pool.Query(ctx, "SELECT id, type, delta, value, histogram, summary, hll from metrics ORDER BY id")
 
 	if err != nil {
 		return nil, err
@@ -134,13 +300,12 @@ func (m *MetricDBRepository) GetList(ctx context.Context) ([]repository.Metrics,
 	defer rows.Close()
 
 	for rows.Next() {
-		var v repository.Metrics
-		err = rows.Scan(&v.ID, &v.MType, &v.Delta, &v.Value)
+		v, err := scanMetric(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		metrics = append(metrics, v)
+		metrics = append(metrics, *v)
 	}
 
 	err = rows.Err()
@@ -151,34 +316,189 @@ func (m *MetricDBRepository) GetList(ctx context.Context) ([]repository.Metrics,
 }
 
 func (m *MetricDBRepository) PingContext(ctx context.Context) error {
-	if m.db == nil {
+	if m.pool == nil {
 		return errors.New("no initialized")
 	}
 
-	if err := m.db.PingContext(ctx); err != nil {
+	if err := m.pool.Ping(ctx); err != nil {
 		return err
 	}
 	return nil
 }
 
-// Prepare execute migration query from ./init.sql file
+// Start runs periodic removal of expired idempotency keys, it's stopped by Close or when ctx is done.
+//
+// Keys aren't removed in batch transactions, so concurrent batches don't contend on the same rows.
+func (m *MetricDBRepository) Start(ctx context.Context) error {
+	ctx, m.stop = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		dump.New(m.sweepKeys).Start(ctx, keysSweepInterval)
+	}()
+	return nil
+}
+
+// Close stops background jobs and closes pool, it waits until acquired connections are released or ctx is done
+func (m *MetricDBRepository) Close(ctx context.Context) error {
+	if m.stop != nil {
+		m.stop()
+		select {
+		case <-m.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.pool.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Prepare checks connection and applies all embedded migrations, see Migrate
 func (m *MetricDBRepository) Prepare(ctx context.Context) error {
 	if err := m.PingContext(ctx); err != nil {
 		return err
 	}
-	content, err := os.ReadFile("./init.sql")
 
+	return m.Migrate(ctx, LatestVersion)
+}
+
+// checkTypeConflict upsert skips update of metric with another type, so no affected rows means type conflict
+func checkTypeConflict(res pgconn.CommandTag, mtr repository.Metrics) error {
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s is not %s", repository.ErrTypeConflict, mtr.ID, mtr.MType)
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanMetric reads metric from row with columns id, type, delta, value, histogram, summary, hll
+func scanMetric(row scanner) (*repository.Metrics, error) {
+	var v repository.Metrics
+	var hist, summary, set []byte
+	if err := row.Scan(&v.ID, &v.MType, &v.Delta, &v.Value, &hist, &summary, &set); err != nil {
+		return nil, err
+	}
+
+	if err := decodeSketches(&v, hist, summary, set); err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+// decodeSketches fills histogram (jsonb), summary and set (bytea) of metric from raw column values
+func decodeSketches(v *repository.Metrics, hist, summary, set []byte) error {
+	if len(hist) > 0 {
+		v.Histogram = &histogram.Histogram{}
+		if err := json.Unmarshal(hist, v.Histogram); err != nil {
+			return err
+		}
+	}
+
+	if len(summary) > 0 {
+		v.Summary = &ddsketch.Sketch{}
+		if err := v.Summary.UnmarshalBinary(summary); err != nil {
+			return err
+		}
+	}
+
+	if len(set) > 0 {
+		v.Set = &hll.Sketch{}
+		if err := v.Set.UnmarshalBinary(set); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isSketch reports whether metric type can't be merged by sql and requires read-modify-write
+func isSketch(mtype string) bool {
+	return mtype == "histogram" || mtype == "summary" || mtype == "set"
+}
+
+func (m *MetricDBRepository) updateSketchTx(ctx context.Context, mtr repository.Metrics) error {
+	tx, err := m.pool.Begin(ctx)
 	if err != nil {
 		return err
 	}
 
-	sql := string(content)
+	if err = updateSketch(ctx, tx, mtr); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
 
-	_, err = m.db.ExecContext(ctx, sql)
+	return tx.Commit(ctx)
+}
 
+// updateSketch merges histogram, summary or set with the stored one.
+//
+// Buckets and sketches can't be merged by sql, so the row is locked with SELECT ... FOR UPDATE,
+// merged in memory and written back in the same transaction.
+// Empty row is inserted first, so the lock exists on the first write too and concurrent first writes are serialized
+// (the second one waits on unique index until the first transaction ends).
+func updateSketch(ctx context.Context, tx pgx.Tx, mtr repository.Metrics) error {
+	_, err := tx.Exec(ctx, "INSERT INTO metrics (id, type) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING", mtr.ID, mtr.MType)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	stored := repository.Metrics{ID: mtr.ID}
+	var hist, summary, set []byte
+	err = tx.QueryRow(ctx, "SELECT type, histogram, summary, hll FROM metrics WHERE id = $1 FOR UPDATE", mtr.ID).
+		Scan(&stored.MType, &hist, &summary, &set)
+	if err != nil {
+		return err
+	}
+
+	if err = decodeSketches(&stored, hist, summary, set); err != nil {
+		return err
+	}
+
+	if err = stored.Merge(mtr); err != nil {
+		return err
+	}
+
+	var histValue *string
+	if stored.Histogram != nil {
+		content, err := json.Marshal(stored.Histogram)
+		if err != nil {
+			return err
+		}
+		v := string(content)
+		histValue = &v
+	}
+
+	var summaryValue []byte
+	if stored.Summary != nil {
+		if summaryValue, err = stored.Summary.MarshalBinary(); err != nil {
+			return err
+		}
+	}
+
+	var setValue []byte
+	if stored.Set != nil {
+		if setValue, err = stored.Set.MarshalBinary(); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE metrics SET histogram = $2, summary = $3, hll = $4 WHERE id = $1",
+		mtr.ID, histValue, summaryValue, setValue)
+
+	return err
 }
@@ -0,0 +1,119 @@
+package dbstorage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/pkg/histogram"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Errorln(args ...interface{}) {}
+
+// newTestRepo returns repository with empty tables, it requires postgres: DATABASE_DSN=postgres://... go test
+func newTestRepo(t *testing.T) *MetricDBRepository {
+	t.Helper()
+
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("DATABASE_DSN is not set")
+	}
+
+	ctx := context.Background()
+	pool, err := NewPool(ctx, dsn, PoolConfig{})
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	repo := New(pool, nopLogger{})
+	require.NoError(t, repo.Prepare(ctx))
+	_, err = pool.Exec(ctx, "TRUNCATE metrics, idempotency_keys")
+	require.NoError(t, err)
+	return repo
+}
+
+func TestNotifyBulk(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	var notified []repository.Metrics
+	repo.OnChange(func(metrics []repository.Metrics) {
+		notified = append(notified, metrics...)
+	})
+
+	one, two := int64(1), int64(2)
+	value := 0.5
+	err := repo.BulkUpdate(ctx, []repository.Metrics{
+		{ID: "poll", MType: "counter", Delta: &one},
+		{ID: "load", MType: "gauge", Value: &value},
+		{ID: "poll", MType: "counter", Delta: &two},
+	})
+	require.NoError(t, err)
+
+	// каждая метрика пачки передается один раз
+	require.Len(t, notified, 2)
+	assert.Equal(t, "load", notified[0].ID)
+	assert.Equal(t, 0.5, *notified[0].Value)
+	assert.Equal(t, "poll", notified[1].ID)
+	assert.Equal(t, int64(3), *notified[1].Delta)
+}
+
+func TestUpdateSketchConcurrentFirstWrite(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	// первые записи одной гистограммы из разных транзакций не должны перезаписывать друг друга
+	const writers = 8
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			h := histogram.New(1, 10)
+			h.Observe(float64(i))
+			_, err := repo.Update(ctx, repository.Metrics{ID: "latency", MType: "histogram", Histogram: h})
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	m, err := repo.Get(ctx, "latency")
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	require.NotNil(t, m.Histogram)
+	assert.Equal(t, uint64(writers), m.Histogram.Count)
+}
+
+func TestListRegexChunks(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	// подходящие метрики находятся дальше первой страницы чтения
+	value := 1.0
+	metrics := make([]repository.Metrics, 0, 2*regexChunk+3)
+	for i := 0; i < 2*regexChunk; i++ {
+		metrics = append(metrics, repository.Metrics{ID: fmt.Sprintf("a.%05d", i), MType: "gauge", Value: &value})
+	}
+	for _, id := range []string{"b.1", "b.2", "b.3"} {
+		metrics = append(metrics, repository.Metrics{ID: id, MType: "gauge", Value: &value})
+	}
+	require.NoError(t, repo.BulkUpdate(ctx, metrics))
+
+	page, err := repo.List(ctx, repository.ListQuery{Regex: `^b\.\d\z`, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page.Metrics, 2)
+	assert.Equal(t, "b.1", page.Metrics[0].ID)
+	assert.Equal(t, "b.2", page.NextCursor)
+
+	page, err = repo.List(ctx, repository.ListQuery{Regex: `^b\.\d\z`, Limit: 2, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	require.Len(t, page.Metrics, 1)
+	assert.Empty(t, page.NextCursor)
+}
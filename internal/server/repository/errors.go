@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Typed errors of metric validation and update,
+// handlers map ErrTypeConflict to 409 and other ones to 400.
+var (
+	ErrInvalidType  = errors.New("invalid metric type")
+	ErrMissingValue = errors.New("missing metric value")
+	ErrInvalidValue = errors.New("invalid metric value")
+	ErrInvalidName  = errors.New("invalid metric name")
+	ErrTypeConflict = errors.New("metric type conflict")
+	ErrInvalidQuery = errors.New("invalid list query")
+	ErrInvalidOp    = errors.New("invalid update operation")
+)
+
+// ErrDuplicate is returned by BulkUpdateOnce if batch with the same key is already applied.
+var ErrDuplicate = errors.New("request is already processed")
+
+// ItemError describes failed item of bulk update.
+type ItemError struct {
+	Index int    // position of item in the batch
+	ID    string // metric ID
+	Err   error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item %d (%s): %v", e.Index, e.ID, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// NewItemError wraps err of bulk update item.
+func NewItemError(index int, id string, err error) error {
+	return &ItemError{Index: index, ID: id, Err: err}
+}
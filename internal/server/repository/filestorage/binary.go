@@ -0,0 +1,392 @@
+package filestorage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/pkg/ddsketch"
+	"github.com/benderr/metrics/pkg/histogram"
+	"github.com/benderr/metrics/pkg/hll"
+)
+
+// Binary snapshot layout:
+//
+//	header (binaryHeaderSize bytes, little endian):
+//	  magic "MSNB" | version uint8 | compression uint8 | reserved uint16 | crc32c uint32 | size uint64
+//	body (size bytes, compressed if compression is set, checksum is calculated over stored bytes):
+//	  records: uvarint length | metric
+//
+// Metric is encoded as id, type and present values, see appendMetric.
+
+var binaryMagic = []byte("MSNB")
+
+const (
+	binaryVersion    = 1
+	binaryHeaderSize = 20
+
+	// compression codes of body, zstd can be added with the next code without version change
+	compressionNone byte = 0
+	compressionGzip byte = 1
+
+	// maxRecordSize limits size of record, so damaged length doesn't cause huge allocation
+	maxRecordSize = 64 << 20
+)
+
+// Flags of values present in record.
+const (
+	hasDelta byte = 1 << iota
+	hasValue
+	hasHistogram
+	hasSummary
+	hasSet
+)
+
+// typeCodes short codes of known types, type with code 0 is written as string
+var typeCodes = map[string]byte{"gauge": 1, "counter": 2, "histogram": 3, "summary": 4, "set": 5}
+
+var typeNames = map[byte]string{1: "gauge", 2: "counter", 3: "histogram", 4: "summary", 5: "set"}
+
+// writeBinarySnapshot writes header and length-prefixed records, file is synced
+func writeBinarySnapshot(file *os.File, compression byte, metrics []repository.Metrics) error {
+	// header is rewritten after body when checksum and size are known
+	header := make([]byte, binaryHeaderSize)
+	if _, err := file.Write(header); err != nil {
+		return err
+	}
+
+	crc := crc32.New(castagnoli)
+	body := &countingWriter{w: io.MultiWriter(file, crc)}
+	buf := bufio.NewWriter(body)
+
+	var w io.Writer = buf
+	var gz *gzip.Writer
+	if compression == compressionGzip {
+		gz = gzip.NewWriter(buf)
+		w = gz
+	}
+
+	var record []byte
+	var length [binary.MaxVarintLen64]byte
+	for _, m := range metrics {
+		var err error
+		if record, err = appendMetric(record[:0], m); err != nil {
+			return fmt.Errorf("metric %s: %w", m.ID, err)
+		}
+		n := binary.PutUvarint(length[:], uint64(len(record)))
+		if _, err := w.Write(length[:n]); err != nil {
+			return err
+		}
+		if _, err := w.Write(record); err != nil {
+			return err
+		}
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+
+	copy(header, binaryMagic)
+	header[4] = binaryVersion
+	header[5] = compression
+	binary.LittleEndian.PutUint32(header[8:], crc.Sum32())
+	binary.LittleEndian.PutUint64(header[12:], uint64(body.n))
+	if _, err := file.WriteAt(header, 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// readBinarySnapshot reads records of binary snapshot and checks checksum
+func readBinarySnapshot(r io.Reader) ([]repository.Metrics, error) {
+	header := make([]byte, binaryHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrCorruptSnapshot, err)
+	}
+	if header[4] != binaryVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrCorruptSnapshot, header[4])
+	}
+	sum := binary.LittleEndian.Uint32(header[8:])
+	size := int64(binary.LittleEndian.Uint64(header[12:]))
+
+	crc := crc32.New(castagnoli)
+	body := &countingReader{r: io.TeeReader(r, crc)}
+
+	var records io.Reader = body
+	switch header[5] {
+	case compressionNone:
+	case compressionGzip:
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCorruptSnapshot, err)
+		}
+		records = gz
+	default:
+		return nil, fmt.Errorf("%w: unknown compression %d", ErrCorruptSnapshot, header[5])
+	}
+
+	metrics, err := decodeRecords(bufio.NewReader(records))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorruptSnapshot, err)
+	}
+
+	// checksum is calculated over the whole body, including data after the last record
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return nil, err
+	}
+	if body.n != size || crc.Sum32() != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorruptSnapshot)
+	}
+	return metrics, nil
+}
+
+func decodeRecords(r *bufio.Reader) ([]repository.Metrics, error) {
+	metrics := make([]repository.Metrics, 0)
+	var record []byte
+	for {
+		length, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return metrics, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if length > maxRecordSize {
+			return nil, fmt.Errorf("record %d is too large: %d bytes", len(metrics), length)
+		}
+
+		if uint64(cap(record)) < length {
+			record = make([]byte, length)
+		}
+		record = record[:length]
+		if _, err := io.ReadFull(r, record); err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(metrics), err)
+		}
+
+		m, err := decodeMetric(record)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(metrics), err)
+		}
+		metrics = append(metrics, m)
+	}
+}
+
+// appendMetric appends binary encoding of metric:
+// id (uvarint length, bytes), type code (byte, 0 is followed by type string), flags byte and values of flags in order:
+// delta (varint), value (float64), histogram (bounds, counts, sum, count), summary and set (uvarint length, MarshalBinary).
+func appendMetric(b []byte, m repository.Metrics) ([]byte, error) {
+	b = appendString(b, m.ID)
+	code := typeCodes[m.MType]
+	b = append(b, code)
+	if code == 0 {
+		b = appendString(b, m.MType)
+	}
+
+	var flags byte
+	if m.Delta != nil {
+		flags |= hasDelta
+	}
+	if m.Value != nil {
+		flags |= hasValue
+	}
+	if m.Histogram != nil {
+		flags |= hasHistogram
+	}
+	if m.Summary != nil {
+		flags |= hasSummary
+	}
+	if m.Set != nil {
+		flags |= hasSet
+	}
+	b = append(b, flags)
+
+	if m.Delta != nil {
+		b = binary.AppendVarint(b, *m.Delta)
+	}
+	if m.Value != nil {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(*m.Value))
+	}
+	if h := m.Histogram; h != nil {
+		b = binary.AppendUvarint(b, uint64(len(h.Bounds)))
+		for _, v := range h.Bounds {
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+		}
+		b = binary.AppendUvarint(b, uint64(len(h.Counts)))
+		for _, v := range h.Counts {
+			b = binary.AppendUvarint(b, v)
+		}
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(h.Sum))
+		b = binary.AppendUvarint(b, h.Count)
+	}
+	if m.Summary != nil {
+		data, err := m.Summary.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		b = appendString(b, string(data))
+	}
+	if m.Set != nil {
+		data, err := m.Set.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		b = appendString(b, string(data))
+	}
+	return b, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// decodeMetric decodes record written by appendMetric
+func decodeMetric(record []byte) (repository.Metrics, error) {
+	var m repository.Metrics
+	r := bytes.NewReader(record)
+
+	var err error
+	if m.ID, err = readString(r); err != nil {
+		return m, err
+	}
+
+	code, err := r.ReadByte()
+	if err != nil {
+		return m, err
+	}
+	if code == 0 {
+		if m.MType, err = readString(r); err != nil {
+			return m, err
+		}
+	} else if m.MType = typeNames[code]; m.MType == "" {
+		return m, fmt.Errorf("unknown type code %d", code)
+	}
+
+	flags, err := r.ReadByte()
+	if err != nil {
+		return m, err
+	}
+
+	if flags&hasDelta != 0 {
+		delta, err := binary.ReadVarint(r)
+		if err != nil {
+			return m, err
+		}
+		m.Delta = &delta
+	}
+	if flags&hasValue != 0 {
+		value, err := readFloat(r)
+		if err != nil {
+			return m, err
+		}
+		m.Value = &value
+	}
+	if flags&hasHistogram != 0 {
+		if m.Histogram, err = readHistogram(r); err != nil {
+			return m, err
+		}
+	}
+	if flags&hasSummary != 0 {
+		data, err := readString(r)
+		if err != nil {
+			return m, err
+		}
+		m.Summary = &ddsketch.Sketch{}
+		if err := m.Summary.UnmarshalBinary([]byte(data)); err != nil {
+			return m, err
+		}
+	}
+	if flags&hasSet != 0 {
+		data, err := readString(r)
+		if err != nil {
+			return m, err
+		}
+		m.Set = &hll.Sketch{}
+		if err := m.Set.UnmarshalBinary([]byte(data)); err != nil {
+			return m, err
+		}
+	}
+
+	if r.Len() != 0 {
+		return m, errors.New("unexpected data after metric")
+	}
+	return m, nil
+}
+
+func readHistogram(r *bytes.Reader) (*histogram.Histogram, error) {
+	h := &histogram.Histogram{}
+
+	n, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	h.Bounds = make([]float64, n)
+	for i := range h.Bounds {
+		if h.Bounds[i], err = readFloat(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if n, err = readLength(r); err != nil {
+		return nil, err
+	}
+	h.Counts = make([]uint64, n)
+	for i := range h.Counts {
+		if h.Counts[i], err = binary.ReadUvarint(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if h.Sum, err = readFloat(r); err != nil {
+		return nil, err
+	}
+	if h.Count, err = binary.ReadUvarint(r); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// readLength reads uvarint length, it can't exceed the rest of record
+func readLength(r *bytes.Reader) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(r.Len()) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(n), nil
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := readLength(r)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func readFloat(r *bytes.Reader) (float64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b[:])), nil
+}
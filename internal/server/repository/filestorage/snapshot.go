@@ -0,0 +1,269 @@
+package filestorage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+// snapshotMagic start of snapshot header, snapshots without header (old format) are read without checksum verification
+const snapshotMagic = "METRICS-SNAPSHOT"
+
+const snapshotVersion = 1
+
+// headerFormat has fixed width, so header is rewritten in place when body is written
+const headerFormat = snapshotMagic + " %d crc32c=%08x size=%016d\n"
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Formats of snapshot file, Restore detects format of file by its header.
+const (
+	FormatJSON       = "json"        // metrics in json, one per line after text header
+	FormatBinary     = "binary"      // length-prefixed records after binary header, see binary.go
+	FormatBinaryGzip = "binary-gzip" // binary format, body is compressed with gzip
+)
+
+// validFormat checks snapshot format, empty format is FormatJSON
+func validFormat(format string) error {
+	switch format {
+	case "", FormatJSON, FormatBinary, FormatBinaryGzip:
+		return nil
+	}
+	return fmt.Errorf("invalid snapshot format %q", format)
+}
+
+// ErrCorruptSnapshot is returned if snapshot can't be parsed or its checksum doesn't match.
+var ErrCorruptSnapshot = errors.New("corrupt snapshot")
+
+// generationPath returns path of n-th previous snapshot, 0 is the current one
+func generationPath(path string, n int) string {
+	if n == 0 {
+		return path
+	}
+	return path + "." + strconv.Itoa(n)
+}
+
+// writeSnapshot writes metrics to temporary file, syncs it and atomically renames to path.
+//
+// Current snapshot becomes previous generation {path}.1, {path}.1 becomes {path}.2 and so on,
+// at most generations previous snapshots are kept.
+func writeSnapshot(path string, generations int, format string, metrics []repository.Metrics) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatBinary:
+		err = writeBinarySnapshot(file, compressionNone, metrics)
+	case FormatBinaryGzip:
+		err = writeBinarySnapshot(file, compressionGzip, metrics)
+	default:
+		err = writeSnapshotFile(file, metrics)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err = rotateGenerations(path, generations); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// writeSnapshotFile writes header with checksum and metrics one per line, file is synced
+func writeSnapshotFile(file *os.File, metrics []repository.Metrics) error {
+	// header is rewritten after body when checksum and size are known
+	if _, err := fmt.Fprintf(file, headerFormat, snapshotVersion, 0, 0); err != nil {
+		return err
+	}
+
+	crc := crc32.New(castagnoli)
+	body := &countingWriter{w: io.MultiWriter(file, crc)}
+	buf := bufio.NewWriter(body)
+	encoder := json.NewEncoder(buf)
+	for _, m := range metrics {
+		if err := encoder.Encode(m); err != nil {
+			return err
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+
+	header := fmt.Sprintf(headerFormat, snapshotVersion, crc.Sum32(), body.n)
+	if _, err := file.WriteAt([]byte(header), 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// rotateGenerations shifts previous snapshots and keeps current one as {path}.1.
+//
+// Current snapshot is hard linked, so path exists until it's replaced by rename of the new one.
+func rotateGenerations(path string, generations int) error {
+	if generations <= 0 {
+		return nil
+	}
+
+	if err := os.Remove(generationPath(path, generations)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for n := generations - 1; n >= 1; n-- {
+		if err := os.Rename(generationPath(path, n), generationPath(path, n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	err := os.Link(path, generationPath(path, 1))
+	if err == nil || os.IsNotExist(err) {
+		return nil
+	}
+	// file system without hard links
+	if err := os.Rename(path, generationPath(path, 1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// syncDir syncs directory, so rename of snapshot survives power loss
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// WriteSnapshot atomically writes metrics to snapshot file of given format, previous file is replaced.
+func WriteSnapshot(path string, format string, metrics []repository.Metrics) error {
+	if err := validFormat(format); err != nil {
+		return err
+	}
+	return writeSnapshot(path, 0, format, metrics)
+}
+
+// ReadSnapshot reads metrics of snapshot file of any format, see DecodeSnapshot.
+func ReadSnapshot(path string) ([]repository.Metrics, error) {
+	return readSnapshot(path)
+}
+
+// readSnapshot reads all metrics of snapshot file, see DecodeSnapshot
+func readSnapshot(path string) ([]repository.Metrics, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return DecodeSnapshot(file)
+}
+
+// DecodeSnapshot reads all metrics of snapshot and checks checksum, snapshot without header is read as is.
+//
+// Format is detected by header: binary, json or json without header written by previous versions and /export.
+//
+// Error wrapping ErrCorruptSnapshot is returned if snapshot is damaged.
+func DecodeSnapshot(reader io.Reader) ([]repository.Metrics, error) {
+	r := bufio.NewReader(reader)
+	prefix, err := r.Peek(len(snapshotMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.HasPrefix(prefix, binaryMagic) {
+		return readBinarySnapshot(r)
+	}
+	if string(prefix) != snapshotMagic {
+		metrics, err := decodeMetrics(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCorruptSnapshot, err)
+		}
+		return metrics, nil
+	}
+
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrCorruptSnapshot, err)
+	}
+	var version int
+	var sum uint32
+	var size int64
+	if _, err := fmt.Sscanf(header, headerFormat, &version, &sum, &size); err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrCorruptSnapshot, err)
+	}
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrCorruptSnapshot, version)
+	}
+
+	crc := crc32.New(castagnoli)
+	body := &countingReader{r: io.TeeReader(r, crc)}
+	metrics, err := decodeMetrics(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorruptSnapshot, err)
+	}
+	if body.n != size || crc.Sum32() != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorruptSnapshot)
+	}
+	return metrics, nil
+}
+
+// decodeMetrics reads metrics written one per line until EOF, empty lines are skipped
+func decodeMetrics(r io.Reader) ([]repository.Metrics, error) {
+	metrics := make([]repository.Metrics, 0)
+	decoder := json.NewDecoder(r)
+	for {
+		var m repository.Metrics
+		err := decoder.Decode(&m)
+		if err == io.EOF {
+			return metrics, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", len(metrics), err)
+		}
+		metrics = append(metrics, m)
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -2,10 +2,13 @@ package filestorage
 
 import (
 	"context"
-	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
 
+	"github.com/benderr/metrics/internal/server/dump"
 	"github.com/benderr/metrics/internal/server/repository"
 	"github.com/benderr/metrics/internal/server/repository/inmemory"
 	"github.com/benderr/metrics/pkg/retry"
@@ -15,8 +18,21 @@ import (
 type FileMetricRepository struct {
 	sync bool
 	repository.MetricRepository
+	mem      *inmemory.InMemoryMetricRepository // the same storage as MetricRepository
 	filePath string
 	logger   repository.Logger
+	mu       sync.Mutex // orders WAL records with changes in memory and snapshots
+	wal      *wal       // write-ahead log, nil if disabled
+
+	snapshotMu  sync.Mutex // snapshots are written through shared temporary file
+	generations int        // number of kept previous snapshots
+	format      string     // format of written snapshots
+
+	dirty atomic.Bool // there are changes not saved to snapshot
+
+	interval int                // period of snapshot saving in seconds, 0 - no periodic saving
+	stop     context.CancelFunc // stops background jobs, nil if they are not started
+	done     chan struct{}      // closed after background jobs are stopped
 }
 
 // New returns a new FileMetricRepository object
@@ -25,22 +41,117 @@ type FileMetricRepository struct {
 // This repository used an in-memory repository
 // with the addition of additional methods for backup and restoring
 func New(filePath string, sync bool, logger repository.Logger) *FileMetricRepository {
-	var repo repository.MetricRepository = inmemory.New()
+	repo := inmemory.New()
 
 	return &FileMetricRepository{
 		sync:             sync,
 		MetricRepository: repo,
+		mem:              repo,
 		logger:           logger,
 		filePath:         filePath,
 	}
 }
 
-func (f *FileMetricRepository) getFile() (io.ReadWriteCloser, error) {
-	file, err := os.OpenFile(f.filePath, os.O_RDWR|os.O_CREATE, 0666)
+// EnableWAL opens write-ahead log {filePath}.wal, it must be called before Restore and updates.
+//
+// New values of changed metrics are appended to the log before they are applied in memory,
+// so changes made since the last snapshot are replayed by Restore.
+// With FsyncInterval policy records are synced by background job, see Start. Sync writes snapshot and truncates the log (compaction),
+// log exceeding opts.MaxSize is compacted on write. With WAL snapshot isn't written on every update in sync mode.
+func (f *FileMetricRepository) EnableWAL(opts WALOptions) error {
+	w, err := openWAL(f.filePath+".wal", opts)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	f.wal = w
+	return nil
+}
+
+// KeepGenerations sets number of previous snapshots {filePath}.1 ... {filePath}.n kept on Sync,
+// Restore falls back to them if the current snapshot is damaged.
+func (f *FileMetricRepository) KeepGenerations(n int) {
+	f.generations = n
+}
+
+// SetSnapshotFormat sets format of snapshots written on Sync: FormatJSON (default), FormatBinary or FormatBinaryGzip.
+// Restore reads snapshot of any format, so format can be changed between restarts.
+func (f *FileMetricRepository) SetSnapshotFormat(format string) error {
+	if err := validFormat(format); err != nil {
+		return err
 	}
-	return file, nil
+	f.format = format
+	return nil
+}
+
+// SetStoreInterval sets period of snapshot saved in background after Start, 0 disables it.
+func (f *FileMetricRepository) SetStoreInterval(seconds int) {
+	f.interval = seconds
+}
+
+// Start runs periodic saving of snapshot and fsync of WAL with FsyncInterval policy,
+// they are stopped by Close or when ctx is done.
+// In sync mode snapshot is saved on every update and isn't saved periodically.
+func (f *FileMetricRepository) Start(ctx context.Context) error {
+	var jobs []func(ctx context.Context)
+	if !f.sync && f.interval > 0 {
+		jobs = append(jobs, func(ctx context.Context) { dump.New(f.Sync).Start(ctx, f.interval) })
+	}
+	if f.wal != nil && f.wal.opts.Fsync == FsyncInterval {
+		jobs = append(jobs, func(ctx context.Context) { dump.New(f.flushWAL).Start(ctx, walSyncInterval) })
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	ctx, f.stop = context.WithCancel(ctx)
+	f.done = make(chan struct{})
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job func(ctx context.Context)) {
+			defer wg.Done()
+			job(ctx)
+		}(job)
+	}
+	go func() {
+		wg.Wait()
+		close(f.done)
+	}()
+	return nil
+}
+
+// flushWAL syncs records of WAL appended since the last fsync
+func (f *FileMetricRepository) flushWAL(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.wal.Flush(); err != nil {
+		f.logger.Errorln("wal sync error", err)
+		return err
+	}
+	return nil
+}
+
+// Close stops background jobs, saves the final snapshot if metrics were changed since the last one and closes WAL.
+func (f *FileMetricRepository) Close(ctx context.Context) error {
+	if f.stop != nil {
+		f.stop()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	var err error
+	if f.dirty.Load() {
+		err = f.Sync(ctx)
+	}
+	if f.wal != nil {
+		err = errors.Join(err, f.wal.Close())
+	}
+	return err
 }
 
 // Update insert or update metric.
@@ -50,16 +161,17 @@ func (f *FileMetricRepository) getFile() (io.ReadWriteCloser, error) {
 //
 // If FileMetricRepository.sync=true then the metrics are also saved to the file
 func (f *FileMetricRepository) Update(ctx context.Context, metric repository.Metrics) (*repository.Metrics, error) {
-	res, err := f.MetricRepository.Update(ctx, metric)
+	var res *repository.Metrics
+	err := f.write(ctx, walUpdate, []repository.Metrics{metric}, func() error {
+		var err error
+		res, err = f.MetricRepository.Update(ctx, metric)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	if f.sync {
-		f.Sync(ctx)
-	}
-
-	return res, err
+	return res, nil
 }
 
 // BulkUpdate insert or update slice of metric.
@@ -71,68 +183,225 @@ func (f *FileMetricRepository) BulkUpdate(ctx context.Context, metrics []reposit
 		return nil
 	}
 
-	for _, v := range metrics {
-		_, err := f.MetricRepository.Update(ctx, v)
+	return f.write(ctx, walUpdate, metrics, func() error {
+		return f.MetricRepository.BulkUpdate(ctx, metrics)
+	})
+}
+
+// BulkUpdateOnce insert or update slice of metric if key was not used, see repository.MetricRepository.
+//
+// Key is checked before batch is logged, so duplicate isn't written to WAL.
+// If FileMetricRepository.sync=true then the metrics are also saved to the file
+func (f *FileMetricRepository) BulkUpdateOnce(ctx context.Context, key string, metrics []repository.Metrics) error {
+	return f.mem.Do(ctx, key, func() error {
+		return f.BulkUpdate(ctx, metrics)
+	})
+}
+
+// Replace removes all metrics and stores given ones.
+//
+// If FileMetricRepository.sync=true then the metrics are also saved to the file
+func (f *FileMetricRepository) Replace(ctx context.Context, metrics []repository.Metrics) error {
+	return f.write(ctx, walReplace, metrics, func() error {
+		return f.MetricRepository.Replace(ctx, metrics)
+	})
+}
+
+// write applies change of metrics to memory, without WAL snapshot is written in sync mode.
+//
+// With WAL new values of changed metrics are calculated and logged with repository.OpSet before change is applied,
+// so replay of record is idempotent and records already included in snapshot (e.g. on crash during compaction)
+// don't change restored values. Invalid change isn't logged, if the logged change fails to apply, its record is removed.
+func (f *FileMetricRepository) write(ctx context.Context, kind string, metrics []repository.Metrics, apply func() error) error {
+	if f.wal == nil {
+		if err := apply(); err != nil {
+			return err
+		}
+		f.dirty.Store(true)
+		if f.sync {
+			f.Sync(ctx)
+		}
+		return nil
+	}
+
+	// all changes are made under f.mu, so calculated values are the same as applied ones
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// empty Replace is logged too, it removes all metrics
+	logged := len(metrics) > 0 || kind == walReplace
+	if logged {
+		values, err := f.values(ctx, kind, metrics)
 		if err != nil {
 			return err
 		}
+		if err := f.wal.Append(walRecord{Kind: kind, Metrics: values}); err != nil {
+			f.logger.Errorln("wal write error", err)
+			return err
+		}
 	}
 
-	if f.sync {
-		f.Sync(ctx)
+	if err := apply(); err != nil {
+		if logged {
+			if err := f.wal.Undo(); err != nil {
+				f.logger.Errorln("wal undo error", err)
+			}
+		}
+		return err
 	}
+	f.dirty.Store(true)
 
+	if f.wal.Full() {
+		if err := f.compact(ctx); err != nil {
+			f.logger.Errorln("wal compaction error", err)
+		}
+	}
 	return nil
 }
 
-// Sync saved metrics from memory to file
+// values returns new values of metrics after change with repository.OpSet, every ID once, storage isn't changed
+func (f *FileMetricRepository) values(ctx context.Context, kind string, metrics []repository.Metrics) ([]repository.Metrics, error) {
+	base := f.mem
+	if kind == walReplace {
+		// Replace doesn't depend on stored values
+		base = inmemory.New()
+	}
+
+	values, err := base.Values(ctx, metrics)
+	if err != nil {
+		return nil, err
+	}
+	for i := range values {
+		values[i].Op = repository.OpSet
+	}
+	return values, nil
+}
+
+// Sync saved metrics from memory to file, with WAL the log is truncated after snapshot is saved
 func (f *FileMetricRepository) Sync(ctx context.Context) error {
-	return retry.Do(func() error {
-		w, err := f.getFile()
+	if f.wal == nil {
+		return f.snapshot(ctx)
+	}
 
-		if err != nil {
-			f.logger.Errorln("invalid writer", err)
-			return err
-		}
-		defer w.Close()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.compact(ctx)
+}
+
+// compact saves snapshot and removes records of log included in it, f.mu must be held
+func (f *FileMetricRepository) compact(ctx context.Context) error {
+	if err := f.snapshot(ctx); err != nil {
+		return err
+	}
+	return f.wal.Reset()
+}
+
+// snapshot writes all metrics to file atomically, see writeSnapshot
+func (f *FileMetricRepository) snapshot(ctx context.Context) error {
+	f.snapshotMu.Lock()
+	defer f.snapshotMu.Unlock()
 
+	// changes made during writing get into the next snapshot
+	f.dirty.Store(false)
+	err := retry.Do(func() error {
+		// copy is taken under lock of in-memory storage, writing file doesn't block updates
 		list, err := f.GetList(ctx)
 		if err != nil {
 			f.logger.Errorln("data error", err)
 			return err
 		}
 
-		encoder := json.NewEncoder(w)
-		for _, item := range list {
-			encoder.Encode(item)
+		if err := writeSnapshot(f.filePath, f.generations, f.format, list); err != nil {
+			f.logger.Errorln("snapshot write error", err)
+			return err
 		}
 		return nil
 	}, retry.DefaultRetryCondition)
+	if err != nil {
+		f.dirty.Store(true)
+	}
+	return err
 }
 
-// Restore load metrics from file to memory
+// Restore load metrics from the last valid snapshot to memory, stored values are set as is (repository.OpSet),
+// then changes from WAL are replayed.
+//
+// If snapshot is damaged, previous generations are tried, storage is empty if there are no snapshots.
+// If all generations are damaged, error is returned and WAL isn't replayed: repository must not be used then,
+// because the first Sync would truncate the log.
 func (f *FileMetricRepository) Restore(ctx context.Context) error {
-	return retry.Do(func() error {
-		r, err := f.getFile()
+	metrics, err := f.loadSnapshot()
+	if err != nil {
+		return err
+	}
+
+	for _, metric := range metrics {
+		metric.Op = repository.OpSet
+		// restored values are not logged and don't trigger snapshot saving
+		f.MetricRepository.Update(ctx, metric)
+	}
+
+	if f.wal == nil {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.wal.Replay(func(rec walRecord) error {
+		// replayed changes are only in the log
+		f.dirty.Store(true)
+		var err error
+		switch rec.Kind {
+		case walUpdate:
+			err = f.MetricRepository.BulkUpdate(ctx, rec.Metrics)
+		case walReplace:
+			err = f.MetricRepository.Replace(ctx, rec.Metrics)
+		default:
+			return fmt.Errorf("unknown wal record %q", rec.Kind)
+		}
 		if err != nil {
-			f.logger.Errorln("invalid reader", err)
-			return err
+			// type conflict is possible only if snapshot already includes subsequent Replace of the log,
+			// that Replace record is applied later
+			f.logger.Errorln("wal replay error", err)
 		}
-		defer r.Close()
+		return nil
+	})
+}
 
-		decoder := json.NewDecoder(r)
+// ResetWAL removes records of WAL, it's called instead of Restore when stored metrics are not restored,
+// so records of previous run aren't replayed by the next Restore.
+func (f *FileMetricRepository) ResetWAL() error {
+	if f.wal == nil {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.wal.Reset()
+}
 
-		for {
-			metric := &repository.Metrics{}
-			err := decoder.Decode(&metric)
-			if err == io.EOF {
-				break
+// loadSnapshot reads the newest valid generation of snapshot
+func (f *FileMetricRepository) loadSnapshot() ([]repository.Metrics, error) {
+	var lastErr error
+	for n := 0; ; n++ {
+		path := generationPath(f.filePath, n)
+		metrics, err := readSnapshot(path)
+		if err == nil {
+			if n > 0 {
+				f.logger.Errorln("snapshot restored from previous generation", path)
 			}
-			if err != nil {
-				return err
+			return metrics, nil
+		}
+
+		if os.IsNotExist(err) {
+			// current snapshot may be missing if crash happened during rotation
+			if n > 0 {
+				return nil, lastErr
 			}
-			f.Update(ctx, *metric)
+			continue
 		}
-		return nil
-	}, retry.DefaultRetryCondition)
+
+		f.logger.Errorln("invalid snapshot", path, err)
+		lastErr = err
+	}
 }
@@ -0,0 +1,408 @@
+package filestorage_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/internal/server/repository/filestorage"
+	"github.com/benderr/metrics/pkg/ddsketch"
+	"github.com/benderr/metrics/pkg/histogram"
+	"github.com/benderr/metrics/pkg/hll"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Errorln(args ...interface{}) {}
+
+func openWithWAL(t *testing.T, path string, opts filestorage.WALOptions) *filestorage.FileMetricRepository {
+	t.Helper()
+
+	fs := filestorage.New(path, false, nopLogger{})
+	if err := fs.EnableWAL(opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Restore(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func getDelta(t *testing.T, repo repository.MetricRepository, id string) int64 {
+	t.Helper()
+
+	m, err := repo.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Delta == nil {
+		t.Fatalf("counter %s not found", id)
+	}
+	return *m.Delta
+}
+
+func TestWALReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	opts := filestorage.WALOptions{Fsync: filestorage.FsyncAlways}
+	var delta int64 = 2
+
+	fs := openWithWAL(t, path, opts)
+	fs.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+	if err := fs.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	fs.BulkUpdate(ctx, []repository.Metrics{
+		{ID: "poll", MType: "counter", Delta: &delta},
+		{ID: "other", MType: "counter", Delta: &delta},
+	})
+
+	// изменения после снимка восстанавливаются из журнала
+	restored := openWithWAL(t, path, opts)
+	if v := getDelta(t, restored, "poll"); v != 4 {
+		t.Fatalf("expected counter 4, got %d", v)
+	}
+	if v := getDelta(t, restored, "other"); v != 2 {
+		t.Fatalf("expected counter 2, got %d", v)
+	}
+}
+
+func TestWALCompactionCrash(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	opts := filestorage.WALOptions{Fsync: filestorage.FsyncAlways}
+	var delta int64 = 3
+
+	fs := openWithWAL(t, path, opts)
+	fs.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+	fs.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+
+	log, err := os.ReadFile(path + ".wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// сбой после записи снимка, но до очистки журнала: записи журнала уже есть в снимке
+	if err := os.WriteFile(path+".wal", log, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := openWithWAL(t, path, opts)
+	if v := getDelta(t, restored, "poll"); v != 6 {
+		t.Fatalf("replay of compacted log must not change counter, got %d", v)
+	}
+}
+
+func TestWALTornRecord(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	opts := filestorage.WALOptions{Fsync: filestorage.FsyncNever}
+	var delta int64 = 1
+
+	fs := openWithWAL(t, path, opts)
+	fs.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+
+	f, err := os.OpenFile(path+".wal", os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"kind":"update","metrics":[{"id":"poll"`)
+	f.Close()
+
+	restored := openWithWAL(t, path, opts)
+	if v := getDelta(t, restored, "poll"); v != 1 {
+		t.Fatalf("expected counter 1, got %d", v)
+	}
+
+	// запись после отброшенного хвоста читается при следующем восстановлении
+	restored.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+	restored = openWithWAL(t, path, opts)
+	if v := getDelta(t, restored, "poll"); v != 2 {
+		t.Fatalf("expected counter 2, got %d", v)
+	}
+}
+
+func TestWALReplace(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	opts := filestorage.WALOptions{Fsync: filestorage.FsyncAlways, MaxSize: 1 << 20}
+	var delta int64 = 1
+
+	fs := openWithWAL(t, path, opts)
+	fs.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+	if err := fs.Replace(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := openWithWAL(t, path, opts)
+	list, _ := restored.GetList(ctx)
+	if len(list) != 0 {
+		t.Fatalf("expected empty storage after replace, got %+v", list)
+	}
+
+	if err := filestorage.New(path, false, nopLogger{}).EnableWAL(filestorage.WALOptions{Fsync: "sometimes"}); err == nil {
+		t.Fatal("expected error of invalid fsync policy")
+	}
+}
+
+func TestSnapshotGenerations(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	var delta int64 = 1
+
+	fs := filestorage.New(path, false, nopLogger{})
+	fs.KeepGenerations(2)
+	for i := 0; i < 3; i++ {
+		fs.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+		if err := fs.Sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("only 2 previous generations must be kept, got %v", err)
+	}
+
+	restore := func() *filestorage.FileMetricRepository {
+		restored := filestorage.New(path, false, nopLogger{})
+		if err := restored.Restore(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return restored
+	}
+
+	if v := getDelta(t, restore(), "poll"); v != 3 {
+		t.Fatalf("expected counter 3, got %d", v)
+	}
+
+	// поврежденный снимок: тело не совпадает с контрольной суммой
+	content, _ := os.ReadFile(path)
+	content[len(content)-3] = '9'
+	os.WriteFile(path, content, 0666)
+	if v := getDelta(t, restore(), "poll"); v != 2 {
+		t.Fatalf("expected counter 2 from previous generation, got %d", v)
+	}
+
+	// сбой во время ротации: текущего снимка нет
+	os.Remove(path)
+	os.WriteFile(path+".1", []byte("garbage"), 0666)
+	if v := getDelta(t, restore(), "poll"); v != 1 {
+		t.Fatalf("expected counter 1 from the oldest generation, got %d", v)
+	}
+
+	os.Remove(path + ".2")
+	if err := filestorage.New(path, false, nopLogger{}).Restore(ctx); !errors.Is(err, filestorage.ErrCorruptSnapshot) {
+		t.Fatalf("expected ErrCorruptSnapshot, got %v", err)
+	}
+}
+
+func TestRestoreLegacySnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	// снимок без заголовка, записанный предыдущими версиями
+	legacy := `{"id":"poll","type":"counter","delta":5}` + "\n" + `{"id":"load","type":"gauge","value":1.5}` + "\n"
+	if err := os.WriteFile(path, []byte(legacy), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := filestorage.New(path, false, nopLogger{})
+	if err := fs.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := getDelta(t, fs, "poll"); v != 5 {
+		t.Fatalf("expected counter 5, got %d", v)
+	}
+
+	// новый снимок короче старого файла и полностью его заменяет
+	if err := fs.Replace(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	restored := filestorage.New(path, false, nopLogger{})
+	if err := restored.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := restored.GetList(ctx); len(list) != 0 {
+		t.Fatalf("expected empty storage, got %+v", list)
+	}
+}
+
+func TestBinarySnapshot(t *testing.T) {
+	dir := t.TempDir()
+	var delta int64 = -7
+	value := 2.5
+
+	h := histogram.New(1, 5)
+	h.Observe(0.5)
+	h.Observe(10)
+	summary, _ := ddsketch.New(0.01)
+	summary.Add(3)
+	set, _ := hll.New(hll.DefaultPrecision)
+	set.AddString("a")
+
+	metrics := []repository.Metrics{
+		{ID: "poll", MType: "counter", Delta: &delta},
+		{ID: "load", MType: "gauge", Value: &value},
+		{ID: "latency", MType: "histogram", Histogram: h},
+		{ID: "duration", MType: "summary", Summary: summary},
+		{ID: "users", MType: "set", Set: set},
+	}
+
+	for _, format := range []string{filestorage.FormatBinary, filestorage.FormatBinaryGzip} {
+		path := filepath.Join(dir, format)
+		if err := filestorage.WriteSnapshot(path, format, metrics); err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := filestorage.ReadSnapshot(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != len(metrics) || *res[0].Delta != delta || *res[1].Value != value {
+			t.Fatalf("%s: unexpected metrics %+v", format, res)
+		}
+		if res[2].Histogram.Count != 2 || res[2].Histogram.Counts[2] != 1 || res[2].Histogram.Sum != 10.5 {
+			t.Fatalf("%s: unexpected histogram %+v", format, res[2].Histogram)
+		}
+		if q, _ := res[3].Summary.Quantile(0.5); q < 2.9 || q > 3.1 {
+			t.Fatalf("%s: unexpected summary quantile %v", format, q)
+		}
+		if res[4].Set.Estimate() != 1 {
+			t.Fatalf("%s: unexpected set estimate %d", format, res[4].Set.Estimate())
+		}
+
+		content, _ := os.ReadFile(path)
+		content[len(content)-1] ^= 0xff
+		os.WriteFile(path, content, 0666)
+		if _, err := filestorage.ReadSnapshot(path); !errors.Is(err, filestorage.ErrCorruptSnapshot) {
+			t.Fatalf("%s: expected ErrCorruptSnapshot, got %v", format, err)
+		}
+	}
+
+	if err := filestorage.WriteSnapshot(filepath.Join(dir, "xml"), "xml", metrics); err == nil {
+		t.Fatal("expected error of invalid format")
+	}
+}
+
+func TestRestoreDetectsFormat(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	var delta int64 = 1
+
+	fs := filestorage.New(path, false, nopLogger{})
+	fs.KeepGenerations(1)
+	fs.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+	if err := fs.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// формат меняется между перезапусками, предыдущий снимок остается в json
+	if err := fs.SetSnapshotFormat(filestorage.FormatBinaryGzip); err != nil {
+		t.Fatal(err)
+	}
+	fs.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+	if err := fs.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := filestorage.New(path, false, nopLogger{})
+	if err := restored.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := getDelta(t, restored, "poll"); v != 2 {
+		t.Fatalf("expected counter 2 from binary snapshot, got %d", v)
+	}
+
+	os.WriteFile(path, []byte("MSNB"), 0666)
+	restored = filestorage.New(path, false, nopLogger{})
+	if err := restored.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := getDelta(t, restored, "poll"); v != 1 {
+		t.Fatalf("expected counter 1 from json generation, got %d", v)
+	}
+}
+
+func TestClose(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	var delta int64 = 4
+
+	fs := filestorage.New(path, false, nopLogger{})
+	fs.SetStoreInterval(3600)
+	if err := fs.EnableWAL(filestorage.WALOptions{Fsync: filestorage.FsyncNever}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	fs.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+
+	// финальный снимок сохраняется при закрытии, журнал очищается
+	if err := fs.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path + ".wal"); err != nil || info.Size() != 0 {
+		t.Fatalf("expected empty wal after close, got %v %v", info, err)
+	}
+
+	restored := filestorage.New(path, false, nopLogger{})
+	if err := restored.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := getDelta(t, restored, "poll"); v != 4 {
+		t.Fatalf("expected counter 4, got %d", v)
+	}
+
+	// без изменений снимок не перезаписывается
+	before, _ := os.Stat(path)
+	if err := restored.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.Stat(path); !after.ModTime().Equal(before.ModTime()) {
+		t.Fatal("unchanged storage must not write snapshot")
+	}
+}
+
+func TestSyncConcurrentUpdates(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	// в режиме sync без журнала снимок пишется при каждом обновлении параллельно с другими обновлениями
+	fs := filestorage.New(path, true, nopLogger{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				delta := int64(1)
+				if err := fs.BulkUpdate(ctx, []repository.Metrics{{ID: "poll" + strconv.Itoa(i%5), MType: "counter", Delta: &delta}}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if err := fs.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	restored := filestorage.New(path, false, nopLogger{})
+	if err := restored.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := getDelta(t, restored, "poll0"); v != 40 {
+		t.Fatalf("expected counter 40, got %d", v)
+	}
+}
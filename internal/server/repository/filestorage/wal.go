@@ -0,0 +1,197 @@
+package filestorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+// Fsync policies of WAL.
+const (
+	FsyncAlways   = "always"   // fsync after every record, update is confirmed only after it's flushed to disk
+	FsyncInterval = "interval" // fsync at most once per walSyncInterval, records are flushed to disk not later than after it
+	FsyncNever    = "never"    // OS flushes to disk, records survive crash of process but not power failure
+)
+
+// walSyncInterval period of fsync in seconds with FsyncInterval policy
+const walSyncInterval = 1
+
+// Kinds of WAL records.
+const (
+	walUpdate  = "update"  // new values of metrics, applied as BulkUpdate
+	walReplace = "replace" // storage is replaced with batch as Replace
+)
+
+// WALOptions settings of write-ahead log.
+type WALOptions struct {
+	Fsync   string // fsync policy: FsyncAlways, FsyncInterval or FsyncNever
+	MaxSize int64  // size of log in bytes, exceeding log is compacted to snapshot, 0 - no limit
+}
+
+// walRecord line of WAL file
+type walRecord struct {
+	Kind    string               `json:"kind"`
+	Metrics []repository.Metrics `json:"metrics"` // values with op=set
+}
+
+// wal is append-only log of metric values changed since snapshot, one json record per line.
+//
+// It's not safe for concurrent use, FileMetricRepository serializes access.
+type wal struct {
+	file     *os.File
+	opts     WALOptions
+	size     int64
+	prev     int64 // size before the last record, see Undo
+	lastSync time.Time
+	unsynced bool // there are records not flushed to disk
+}
+
+// openWAL opens or creates log file, records are appended to the end
+func openWAL(path string, opts WALOptions) (*wal, error) {
+	switch opts.Fsync {
+	case FsyncAlways, FsyncInterval, FsyncNever:
+	default:
+		return nil, fmt.Errorf("invalid wal fsync policy %q", opts.Fsync)
+	}
+	if opts.MaxSize < 0 {
+		return nil, fmt.Errorf("invalid wal max size %d", opts.MaxSize)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &wal{file: file, opts: opts, size: size, lastSync: time.Now()}, nil
+}
+
+// Append writes record and syncs file according to fsync policy
+func (w *wal) Append(rec walRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.file.Write(append(line, '\n')); err != nil {
+		// partially written record is cut, otherwise records after it wouldn't be read
+		w.truncate(w.size)
+		return err
+	}
+	w.prev = w.size
+	w.size += int64(len(line) + 1)
+	w.unsynced = true
+
+	if w.opts.Fsync == FsyncAlways || (w.opts.Fsync == FsyncInterval && time.Since(w.lastSync) >= walSyncInterval*time.Second) {
+		return w.sync()
+	}
+	return nil
+}
+
+// Undo removes the last appended record, it's called if change of the record wasn't applied
+func (w *wal) Undo() error {
+	if err := w.truncate(w.prev); err != nil {
+		return err
+	}
+	return w.sync()
+}
+
+// Flush syncs records appended since the last fsync, it's called periodically with FsyncInterval policy
+func (w *wal) Flush() error {
+	if !w.unsynced {
+		return nil
+	}
+	return w.sync()
+}
+
+// Replay calls fn for every record from the beginning of log.
+//
+// Incomplete or broken record at the end of file (e.g. written partially on crash) is truncated,
+// so next records are appended after the last valid one.
+func (w *wal) Replay(fn func(rec walRecord) error) error {
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(w.file)
+	var valid int64
+	for {
+		var rec walRecord
+		err := decoder.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// tail after the last complete record is dropped
+			if err := w.file.Truncate(valid); err != nil {
+				return err
+			}
+			break
+		}
+		valid = decoder.InputOffset()
+
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	size, err := w.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	w.size = size
+	w.prev = size
+	return nil
+}
+
+// Reset removes all records, it's called after records are saved to snapshot
+func (w *wal) Reset() error {
+	if err := w.truncate(0); err != nil {
+		return err
+	}
+	return w.sync()
+}
+
+// truncate cuts log to size, next record is appended after it
+func (w *wal) truncate(size int64) error {
+	if err := w.file.Truncate(size); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	w.size = size
+	w.prev = size
+	return nil
+}
+
+// Full reports whether log exceeded max size and should be compacted
+func (w *wal) Full() bool {
+	return w.opts.MaxSize > 0 && w.size > w.opts.MaxSize
+}
+
+func (w *wal) Close() error {
+	if err := w.sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+func (w *wal) sync() error {
+	w.lastSync = time.Now()
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.unsynced = false
+	return nil
+}
@@ -0,0 +1,81 @@
+package filestorage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Errorln(args ...interface{}) {}
+
+func TestWriteAhead(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	var delta int64 = 1
+
+	fs := New(path, false, nopLogger{})
+	if err := fs.EnableWAL(WALOptions{Fsync: FsyncAlways}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta}); err != nil {
+		t.Fatal(err)
+	}
+
+	// запись в журнал не удается, изменение не должно попасть в память
+	fs.wal.file.Close()
+	if _, err := fs.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta}); err == nil {
+		t.Fatal("expected wal write error")
+	}
+	if err := fs.BulkUpdate(ctx, []repository.Metrics{{ID: "new", MType: "counter", Delta: &delta}}); err == nil {
+		t.Fatal("expected wal write error")
+	}
+
+	m, _ := fs.Get(ctx, "poll")
+	if *m.Delta != 1 {
+		t.Fatalf("expected counter 1 after failed write, got %d", *m.Delta)
+	}
+	if m, _ := fs.Get(ctx, "new"); m != nil {
+		t.Fatal("metric of failed batch must not be stored")
+	}
+}
+
+func TestWALFsyncInterval(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	var delta int64 = 1
+
+	fs := New(path, false, nopLogger{})
+	if err := fs.EnableWAL(WALOptions{Fsync: FsyncInterval}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close(ctx)
+
+	// последняя запись сбрасывается фоновой задачей, даже если новых записей нет
+	fs.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+	fs.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+
+	unsynced := func() bool {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		return fs.wal.unsynced
+	}
+	if !unsynced() {
+		t.Fatal("expected record not synced on append")
+	}
+
+	deadline := time.Now().Add(3 * walSyncInterval * time.Second)
+	for unsynced() {
+		if time.Now().After(deadline) {
+			t.Fatal("record is not synced in background")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
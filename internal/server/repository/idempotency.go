@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IdempotencyTTL time while processed request key is remembered.
+//
+// Clients retry requests within seconds, so an hour is enough to detect duplicates.
+const IdempotencyTTL = time.Hour
+
+// Deduplicator keeps keys of processed requests in memory, it's embedded into MetricRepository implementations.
+//
+// Zero value is ready to use. It's safe for concurrent use by multiple goroutines.
+type Deduplicator struct {
+	mu        sync.Mutex
+	keys      map[string]*reservation
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// reservation state of key: batch is in progress or already applied
+type reservation struct {
+	at      time.Time     // время применения пачки
+	applied bool          // пачка применена, повтор не выполняется
+	done    chan struct{} // закрывается, когда обработка пачки завершена
+}
+
+// Do applies batch once per key: apply is called if key was not used within IdempotencyTTL,
+// otherwise error wrapping ErrDuplicate is returned.
+//
+// apply must not change anything on error: then key is released and request can be repeated.
+// Duplicate of batch in progress waits for its result, see Reserve.
+func (d *Deduplicator) Do(ctx context.Context, key string, apply func() error) error {
+	ok, err := d.Reserve(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrDuplicate, key)
+	}
+
+	if err := apply(); err != nil {
+		d.Release(key)
+		return err
+	}
+	d.Commit(key)
+	return nil
+}
+
+// Reserve reserves key for batch, false is returned if batch with the key is already applied and not expired.
+//
+// If batch with the same key is in progress, Reserve waits until it's committed or released,
+// so duplicate isn't reported as applied before the original batch succeeds. Error of ctx is returned if it's done while waiting.
+func (d *Deduplicator) Reserve(ctx context.Context, key string) (bool, error) {
+	for {
+		d.mu.Lock()
+		if d.keys == nil {
+			d.keys = make(map[string]*reservation)
+		}
+		if d.now == nil {
+			d.now = time.Now
+		}
+
+		now := d.now()
+		d.sweep(now)
+
+		r, ok := d.keys[key]
+		if !ok || (r.applied && now.Sub(r.at) >= IdempotencyTTL) {
+			d.keys[key] = &reservation{done: make(chan struct{})}
+			d.mu.Unlock()
+			return true, nil
+		}
+		d.mu.Unlock()
+
+		if r.applied {
+			return false, nil
+		}
+
+		select {
+		case <-r.done:
+			// пачка применена или ключ освобожден, проверяем снова
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// Commit marks batch of reserved key as applied, the key is remembered within IdempotencyTTL.
+func (d *Deduplicator) Commit(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if r, ok := d.keys[key]; ok && !r.applied {
+		r.applied = true
+		r.at = d.now()
+		close(r.done)
+	}
+}
+
+// Release forgets reserved key, e.g. if request failed without changes and can be repeated.
+func (d *Deduplicator) Release(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if r, ok := d.keys[key]; ok {
+		delete(d.keys, key)
+		if !r.applied {
+			close(r.done)
+		}
+	}
+}
+
+// sweep removes expired keys of applied batches at most once per minute
+func (d *Deduplicator) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < time.Minute {
+		return
+	}
+	d.lastSweep = now
+
+	for key, r := range d.keys {
+		if r.applied && now.Sub(r.at) >= IdempotencyTTL {
+			delete(d.keys, key)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+func TestDeduplicator(t *testing.T) {
+	ctx := context.Background()
+	var d repository.Deduplicator
+
+	reserve := func(key string) bool {
+		ok, err := d.Reserve(ctx, key)
+		require.NoError(t, err)
+		return ok
+	}
+
+	assert.True(t, reserve("a"))
+	d.Commit("a")
+	assert.False(t, reserve("a"), "applied key must be rejected")
+	assert.True(t, reserve("b"))
+
+	d.Release("b")
+	assert.True(t, reserve("b"), "released key can be reserved again")
+
+	t.Run("duplicate waits for batch in progress", func(t *testing.T) {
+		timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, err := d.Reserve(timeout, "b")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			d.Release("b")
+		}()
+		assert.True(t, reserve("b"), "key of failed batch is reserved by duplicate")
+	})
+
+	t.Run("failed batch can be repeated", func(t *testing.T) {
+		failed := errors.New("failed")
+		assert.ErrorIs(t, d.Do(ctx, "c", func() error { return failed }), failed)
+		assert.NoError(t, d.Do(ctx, "c", func() error { return nil }))
+		assert.ErrorIs(t, d.Do(ctx, "c", func() error { return nil }), repository.ErrDuplicate)
+	})
+}
@@ -0,0 +1,47 @@
+package inmemory
+
+import "github.com/benderr/metrics/internal/server/repository"
+
+// stage вычисляет новые значения метрик пачки на копиях, хранилище не изменяется.
+//
+// get returns stored metric or nil, it's called under lock of repository.
+// values contains final value of every ID of batch in order of first occurrence,
+// updated contains new value after every item for subscribers.
+// If some item can't be applied, error of the item is returned.
+func stage(metrics []repository.Metrics, get func(id string) *repository.Metrics) (values, updated []repository.Metrics, err error) {
+	index := make(map[string]int, len(metrics)) // ID => индекс в values
+	values = make([]repository.Metrics, 0, len(metrics))
+	updated = make([]repository.Metrics, 0, len(metrics))
+
+	for i, v := range metrics {
+		j, ok := index[v.ID]
+		if !ok {
+			j = len(values)
+			index[v.ID] = j
+
+			exist := get(v.ID)
+			if exist == nil {
+				values = append(values, newMetric(v))
+				updated = append(updated, values[j])
+				continue
+			}
+			values = append(values, *exist)
+		}
+
+		// Merge не изменяет значения по указателям, поэтому копия не затрагивает хранимую метрику
+		if err := values[j].Merge(v); err != nil {
+			return nil, nil, repository.NewItemError(i, v.ID, err)
+		}
+		updated = append(updated, values[j])
+	}
+	return values, updated, nil
+}
+
+// newMetric returns copy of metric to store, sketches aren't shared with caller
+func newMetric(mtr repository.Metrics) repository.Metrics {
+	mtr.Histogram = mtr.Histogram.Clone()
+	mtr.Summary = mtr.Summary.Clone()
+	mtr.Set = mtr.Set.Clone()
+	mtr.Op = ""
+	return mtr
+}
@@ -0,0 +1,41 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+// iterate calls fn for metrics of query in ID order, iteration stops on first error of fn.
+//
+// ids is snapshot of stored IDs, lookup returns copy of metric matching query or false if it doesn't match
+// or was removed after snapshot. Metrics are fetched one by one, so only IDs are held in memory.
+func iterate(ctx context.Context, q repository.ListQuery, ids []string, lookup func(id string) (repository.Metrics, bool), fn func(m repository.Metrics) error) error {
+	if q.Desc {
+		sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	} else {
+		sort.Strings(ids)
+	}
+
+	count := 0
+	for _, id := range ids {
+		if q.Limit > 0 && count >= q.Limit {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		m, ok := lookup(id)
+		if !ok {
+			continue
+		}
+		count++
+
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
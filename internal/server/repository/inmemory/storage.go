@@ -8,6 +8,8 @@ import (
 )
 
 type InMemoryMetricRepository struct {
+	repository.Notifier
+	repository.Deduplicator
 	Metrics []repository.Metrics
 	mu      sync.Mutex
 }
@@ -22,29 +24,37 @@ func New() *InMemoryMetricRepository {
 	}
 }
 
+// Update insert or update metric, subscribers are notified with new value.
 func (m *InMemoryMetricRepository) Update(ctx context.Context, mtr repository.Metrics) (*repository.Metrics, error) {
+	res, err := m.update(ctx, mtr)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Notify(res)
+	return &res, nil
+}
+
+// update изменяет метрику под блокировкой и возвращает копию нового значения
+func (m *InMemoryMetricRepository) update(ctx context.Context, mtr repository.Metrics) (repository.Metrics, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	metric, err := m.Get(ctx, mtr.ID)
 	if err != nil {
-		return nil, err
+		return repository.Metrics{}, err
 	}
 
 	if metric != nil {
-		switch mtr.MType {
-		case "gauge":
-			metric.Value = mtr.Value
-		case "counter":
-			newVal := *metric.Delta + *mtr.Delta
-			metric.Delta = &newVal
+		if err := metric.Merge(mtr); err != nil {
+			return repository.Metrics{}, err
 		}
-		return metric, nil
+		return *metric, nil
 	} else {
-
+		mtr = newMetric(mtr)
 		m.Metrics = append(m.Metrics, mtr)
 
-		return &mtr, nil
+		return mtr, nil
 	}
 }
 
@@ -58,24 +68,170 @@ func (m *InMemoryMetricRepository) Get(ctx context.Context, id string) (*reposit
 	return nil, nil
 }
 
+// GetList returns copy of all metrics taken under lock
 func (m *InMemoryMetricRepository) GetList(ctx context.Context) ([]repository.Metrics, error) {
-	return m.Metrics, nil
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append(make([]repository.Metrics, 0, len(m.Metrics)), m.Metrics...), nil
+}
+
+// List returns page of metrics matching query.
+func (m *InMemoryMetricRepository) List(ctx context.Context, q repository.ListQuery) (*repository.ListPage, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	match := q.Matcher()
+	res := make([]repository.Metrics, 0)
+
+	m.mu.Lock()
+	for i := range m.Metrics {
+		if match(&m.Metrics[i]) {
+			res = append(res, m.Metrics[i])
+		}
+	}
+	m.mu.Unlock()
+
+	return q.Paginate(res), nil
+}
+
+// Iterate calls fn for every metric matching query in ID order, iteration stops on first error of fn.
+//
+// Only IDs are copied under lock, metrics are fetched one by one and fn is called without lock,
+// so slow consumer doesn't block updates and storage isn't copied.
+func (m *InMemoryMetricRepository) Iterate(ctx context.Context, q repository.ListQuery, fn func(m repository.Metrics) error) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	ids := make([]string, len(m.Metrics))
+	positions := make(map[string]int, len(m.Metrics))
+	for i := range m.Metrics {
+		ids[i] = m.Metrics[i].ID
+		positions[ids[i]] = i
+	}
+	m.mu.Unlock()
+
+	match := q.Matcher()
+	return iterate(ctx, q, ids, func(id string) (repository.Metrics, bool) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		// слайс только дополняется, позиция меняется лишь после Replace
+		var metric *repository.Metrics
+		if i := positions[id]; i < len(m.Metrics) && m.Metrics[i].ID == id {
+			metric = &m.Metrics[i]
+		} else {
+			metric, _ = m.Get(ctx, id)
+		}
+
+		if metric == nil || !match(metric) {
+			return repository.Metrics{}, false
+		}
+		return *metric, true
+	}, fn)
 }
 
 func (m *InMemoryMetricRepository) PingContext(ctx context.Context) error {
 	return nil
 }
 
+// Start does nothing, in-memory repository has no background jobs
+func (m *InMemoryMetricRepository) Start(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing, metrics are kept in memory until repository is released
+func (m *InMemoryMetricRepository) Close(ctx context.Context) error {
+	return nil
+}
+
 // BulkUpdate insert or update slice of metric to slice-storage.
+//
+// Batch is applied atomically: new values are calculated on copies under lock,
+// if some item fails, storage is not changed and error of the item is returned.
 func (m *InMemoryMetricRepository) BulkUpdate(ctx context.Context, metrics []repository.Metrics) error {
 
 	if len(metrics) == 0 {
 		return nil
 	}
 
-	for _, v := range metrics {
-		m.Update(ctx, v)
+	updated, err := m.bulkUpdate(ctx, metrics)
+	if err != nil {
+		return err
 	}
 
+	m.Notify(updated...)
 	return nil
 }
+
+// bulkUpdate применяет пачку под блокировкой и возвращает новые значения элементов
+func (m *InMemoryMetricRepository) bulkUpdate(ctx context.Context, metrics []repository.Metrics) ([]repository.Metrics, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	values, updated, err := stage(metrics, func(id string) *repository.Metrics {
+		exist, _ := m.Get(ctx, id)
+		return exist
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range values {
+		if exist, _ := m.Get(ctx, v.ID); exist != nil {
+			*exist = v
+		} else {
+			m.Metrics = append(m.Metrics, v)
+		}
+	}
+	return updated, nil
+}
+
+// Values returns values of metrics which BulkUpdate of batch would store, every ID once, storage is not changed.
+//
+// If some item can't be applied, error of the item is returned like by BulkUpdate.
+func (m *InMemoryMetricRepository) Values(ctx context.Context, metrics []repository.Metrics) ([]repository.Metrics, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	values, _, err := stage(metrics, func(id string) *repository.Metrics {
+		exist, _ := m.Get(ctx, id)
+		return exist
+	})
+	return values, err
+}
+
+// Replace removes all metrics and stores given ones, items with the same ID are merged.
+//
+// If some item is invalid, storage is not changed.
+func (m *InMemoryMetricRepository) Replace(ctx context.Context, metrics []repository.Metrics) error {
+	fresh := New()
+	for i, v := range metrics {
+		if _, err := fresh.update(ctx, v); err != nil {
+			return repository.NewItemError(i, v.ID, err)
+		}
+	}
+
+	// подписчики получают копию, сделанную под блокировкой, хранимый слайс может измениться после нее
+	m.mu.Lock()
+	m.Metrics = fresh.Metrics
+	stored := append([]repository.Metrics(nil), fresh.Metrics...)
+	m.mu.Unlock()
+
+	m.Notify(stored...)
+	return nil
+}
+
+// BulkUpdateOnce applies batch like BulkUpdate if key was not used within repository.IdempotencyTTL,
+// otherwise error wrapping repository.ErrDuplicate is returned.
+//
+// Batch is atomic, so key of failed batch is released and can be used again.
+// Duplicate of batch in progress waits for its result.
+func (m *InMemoryMetricRepository) BulkUpdateOnce(ctx context.Context, key string, metrics []repository.Metrics) error {
+	return m.Do(ctx, key, func() error {
+		return m.BulkUpdate(ctx, metrics)
+	})
+}
@@ -8,6 +8,8 @@ import (
 )
 
 type KeyValueMetricRepository struct {
+	repository.Notifier
+	repository.Deduplicator
 	Metrics map[string]*repository.Metrics
 	mu      sync.Mutex
 }
@@ -24,27 +26,36 @@ func NewFast() *KeyValueMetricRepository {
 	}
 }
 
+// Update insert or update metric, subscribers are notified with new value.
 func (m *KeyValueMetricRepository) Update(ctx context.Context, mtr repository.Metrics) (*repository.Metrics, error) {
+	res, err := m.update(ctx, mtr)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Notify(res)
+	return &res, nil
+}
+
+// update изменяет метрику под блокировкой и возвращает копию нового значения
+func (m *KeyValueMetricRepository) update(ctx context.Context, mtr repository.Metrics) (repository.Metrics, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	metric, err := m.Get(ctx, mtr.ID)
 	if err != nil {
-		return nil, err
+		return repository.Metrics{}, err
 	}
 
 	if metric != nil {
-		switch mtr.MType {
-		case "gauge":
-			metric.Value = mtr.Value
-		case "counter":
-			newVal := *metric.Delta + *mtr.Delta
-			metric.Delta = &newVal
+		if err := metric.Merge(mtr); err != nil {
+			return repository.Metrics{}, err
 		}
-		return metric, nil
+		return *metric, nil
 	} else {
+		mtr = newMetric(mtr)
 		m.Metrics[mtr.ID] = &mtr
-		return &mtr, nil
+		return mtr, nil
 	}
 }
 
@@ -56,28 +67,151 @@ func (m *KeyValueMetricRepository) Get(ctx context.Context, id string) (*reposit
 	return nil, nil
 }
 
+// GetList returns copy of all metrics taken under lock
 func (m *KeyValueMetricRepository) GetList(ctx context.Context) ([]repository.Metrics, error) {
-	res := make([]repository.Metrics, 0)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := make([]repository.Metrics, 0, len(m.Metrics))
 	for _, val := range m.Metrics {
 		res = append(res, *val)
 	}
 	return res, nil
 }
 
+// List returns page of metrics matching query.
+func (m *KeyValueMetricRepository) List(ctx context.Context, q repository.ListQuery) (*repository.ListPage, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	match := q.Matcher()
+	res := make([]repository.Metrics, 0)
+
+	m.mu.Lock()
+	for _, v := range m.Metrics {
+		if match(v) {
+			res = append(res, *v)
+		}
+	}
+	m.mu.Unlock()
+
+	return q.Paginate(res), nil
+}
+
+// Iterate calls fn for every metric matching query in ID order, iteration stops on first error of fn.
+//
+// Only IDs are copied under lock, metrics are fetched one by one and fn is called without lock,
+// so slow consumer doesn't block updates and storage isn't copied.
+func (m *KeyValueMetricRepository) Iterate(ctx context.Context, q repository.ListQuery, fn func(m repository.Metrics) error) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	ids := make([]string, 0, len(m.Metrics))
+	for id := range m.Metrics {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+
+	match := q.Matcher()
+	return iterate(ctx, q, ids, func(id string) (repository.Metrics, bool) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		metric, ok := m.Metrics[id]
+		if !ok || !match(metric) {
+			return repository.Metrics{}, false
+		}
+		return *metric, true
+	}, fn)
+}
+
 func (m *KeyValueMetricRepository) PingContext(ctx context.Context) error {
 	return nil
 }
 
+// Start does nothing, in-memory repository has no background jobs
+func (m *KeyValueMetricRepository) Start(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing, metrics are kept in memory until repository is released
+func (m *KeyValueMetricRepository) Close(ctx context.Context) error {
+	return nil
+}
+
 // BulkUpdate insert or update slice of metric to map-storage.
+//
+// Batch is applied atomically: new values are calculated on copies under lock,
+// if some item fails, storage is not changed and error of the item is returned.
 func (m *KeyValueMetricRepository) BulkUpdate(ctx context.Context, metrics []repository.Metrics) error {
 
 	if len(metrics) == 0 {
 		return nil
 	}
 
-	for _, v := range metrics {
-		m.Update(ctx, v)
+	updated, err := m.bulkUpdate(ctx, metrics)
+	if err != nil {
+		return err
 	}
 
+	m.Notify(updated...)
 	return nil
 }
+
+// bulkUpdate применяет пачку под блокировкой и возвращает новые значения элементов
+func (m *KeyValueMetricRepository) bulkUpdate(ctx context.Context, metrics []repository.Metrics) ([]repository.Metrics, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	values, updated, err := stage(metrics, func(id string) *repository.Metrics {
+		exist, _ := m.Get(ctx, id)
+		return exist
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range values {
+		v := v
+		m.Metrics[v.ID] = &v
+	}
+	return updated, nil
+}
+
+// Replace removes all metrics and stores given ones, items with the same ID are merged.
+//
+// If some item is invalid, storage is not changed.
+func (m *KeyValueMetricRepository) Replace(ctx context.Context, metrics []repository.Metrics) error {
+	fresh := NewFast()
+	for i, v := range metrics {
+		if _, err := fresh.update(ctx, v); err != nil {
+			return repository.NewItemError(i, v.ID, err)
+		}
+	}
+
+	// подписчики получают копию, сделанную под блокировкой, хранимая карта может измениться после нее
+	m.mu.Lock()
+	m.Metrics = fresh.Metrics
+	stored := make([]repository.Metrics, 0, len(fresh.Metrics))
+	for _, v := range fresh.Metrics {
+		stored = append(stored, *v)
+	}
+	m.mu.Unlock()
+
+	m.Notify(stored...)
+	return nil
+}
+
+// BulkUpdateOnce applies batch like BulkUpdate if key was not used within repository.IdempotencyTTL,
+// otherwise error wrapping repository.ErrDuplicate is returned.
+//
+// Batch is atomic, so key of failed batch is released and can be used again.
+// Duplicate of batch in progress waits for its result.
+func (m *KeyValueMetricRepository) BulkUpdateOnce(ctx context.Context, key string, metrics []repository.Metrics) error {
+	return m.Do(ctx, key, func() error {
+		return m.BulkUpdate(ctx, metrics)
+	})
+}
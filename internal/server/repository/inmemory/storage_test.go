@@ -2,6 +2,7 @@ package inmemory_test
 
 import (
 	"context"
+	"errors"
 	"strconv"
 	"testing"
 	"time"
@@ -127,3 +128,271 @@ func ExampleKeyValueMetricRepository_BulkUpdate() {
 		},
 	})
 }
+
+func TestOnChange(t *testing.T) {
+	ctx := context.Background()
+	var delta int64 = 1
+
+	for name, repo := range map[string]repository.MetricRepository{
+		"slice storage": inmemory.New(),
+		"map storage":   inmemory.NewFast(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var changes [][]repository.Metrics
+			repo.OnChange(func(metrics []repository.Metrics) {
+				changes = append(changes, metrics)
+			})
+
+			repo.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+
+			// пачка с ошибкой не применяется целиком и не вызывает уведомлений
+			err := repo.BulkUpdate(ctx, []repository.Metrics{
+				{ID: "poll", MType: "counter", Delta: &delta},
+				{ID: "new", MType: "counter", Delta: &delta},
+				{ID: "poll", MType: "gauge"},
+			})
+			var itemErr *repository.ItemError
+			if !errors.As(err, &itemErr) || itemErr.Index != 2 {
+				t.Fatalf("expected error of item 2, got %v", err)
+			}
+			if m, _ := repo.Get(ctx, "poll"); *m.Delta != 1 {
+				t.Fatalf("failed batch must not change counter, got %d", *m.Delta)
+			}
+			if m, _ := repo.Get(ctx, "new"); m != nil {
+				t.Fatalf("failed batch must not add metrics, got %+v", m)
+			}
+
+			repo.BulkUpdate(ctx, []repository.Metrics{
+				{ID: "poll", MType: "counter", Delta: &delta},
+				{ID: "poll", MType: "counter", Delta: &delta},
+			})
+
+			if len(changes) != 2 {
+				t.Fatalf("expected 2 notifications, got %d", len(changes))
+			}
+			if len(changes[1]) != 2 || *changes[1][1].Delta != 3 {
+				t.Errorf("expected counter with delta 3, got %+v", changes[1])
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	value := 1.0
+
+	for name, repo := range map[string]repository.MetricRepository{
+		"slice storage": inmemory.New(),
+		"map storage":   inmemory.NewFast(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			for _, id := range []string{"cpu.3", "cpu.1", "mem", "cpu.2"} {
+				repo.Update(ctx, repository.Metrics{ID: id, MType: "gauge", Value: &value})
+			}
+
+			q := repository.ListQuery{Prefix: "cpu.", Limit: 2}
+			page, err := repo.List(ctx, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Metrics) != 2 || page.Metrics[0].ID != "cpu.1" || page.NextCursor != "cpu.2" {
+				t.Fatalf("unexpected first page %+v", page)
+			}
+
+			q.Cursor = page.NextCursor
+			page, err = repo.List(ctx, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Metrics) != 1 || page.Metrics[0].ID != "cpu.3" || page.NextCursor != "" {
+				t.Fatalf("unexpected last page %+v", page)
+			}
+
+			if _, err := repo.List(ctx, repository.ListQuery{Regex: "("}); err == nil {
+				t.Error("expected invalid query error")
+			}
+		})
+	}
+}
+
+func TestIterate(t *testing.T) {
+	ctx := context.Background()
+	value := 1.0
+	stop := errors.New("stop")
+
+	for name, repo := range map[string]repository.MetricRepository{
+		"slice storage": inmemory.New(),
+		"map storage":   inmemory.NewFast(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			for _, id := range []string{"cpu.2", "mem", "cpu.1"} {
+				repo.Update(ctx, repository.Metrics{ID: id, MType: "gauge", Value: &value})
+			}
+
+			ids := make([]string, 0)
+			err := repo.Iterate(ctx, repository.ListQuery{Prefix: "cpu."}, func(m repository.Metrics) error {
+				ids = append(ids, m.ID)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ids) != 2 || ids[0] != "cpu.1" || ids[1] != "cpu.2" {
+				t.Fatalf("unexpected metrics %v", ids)
+			}
+
+			// метрики читаются по одной, изменение во время обхода видно в следующих элементах
+			updated := 2.0
+			var seen float64
+			err = repo.Iterate(ctx, repository.ListQuery{Prefix: "cpu."}, func(m repository.Metrics) error {
+				if m.ID == "cpu.1" {
+					repo.Update(ctx, repository.Metrics{ID: "cpu.2", MType: "gauge", Value: &updated})
+				} else {
+					seen = *m.Value
+				}
+				return nil
+			})
+			if err != nil || seen != updated {
+				t.Fatalf("expected lazily fetched value %v, got %v (%v)", updated, seen, err)
+			}
+
+			ids = ids[:0]
+			repo.Iterate(ctx, repository.ListQuery{Desc: true, Limit: 2}, func(m repository.Metrics) error {
+				ids = append(ids, m.ID)
+				return nil
+			})
+			if len(ids) != 2 || ids[0] != "mem" || ids[1] != "cpu.2" {
+				t.Fatalf("unexpected metrics %v", ids)
+			}
+
+			err = repo.Iterate(ctx, repository.ListQuery{}, func(m repository.Metrics) error {
+				return stop
+			})
+			if !errors.Is(err, stop) {
+				t.Fatalf("expected error of callback, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReplace(t *testing.T) {
+	ctx := context.Background()
+	value := 1.0
+	var delta int64 = 2
+
+	for name, repo := range map[string]repository.MetricRepository{
+		"slice storage": inmemory.New(),
+		"map storage":   inmemory.NewFast(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			repo.Update(ctx, repository.Metrics{ID: "old", MType: "gauge", Value: &value})
+			repo.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+
+			err := repo.Replace(ctx, []repository.Metrics{{ID: "poll", MType: "gauge", Value: &value}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			list, _ := repo.GetList(ctx)
+			if len(list) != 1 || list[0].ID != "poll" || list[0].MType != "gauge" {
+				t.Fatalf("unexpected metrics after replace %+v", list)
+			}
+
+			err = repo.Replace(ctx, []repository.Metrics{
+				{ID: "poll", MType: "counter", Delta: &delta},
+				{ID: "poll", MType: "gauge", Value: &value},
+			})
+			var itemErr *repository.ItemError
+			if !errors.As(err, &itemErr) || itemErr.Index != 1 {
+				t.Fatalf("expected error of item 1, got %v", err)
+			}
+
+			list, _ = repo.GetList(ctx)
+			if len(list) != 1 || list[0].MType != "gauge" {
+				t.Fatalf("storage must not be changed on error, got %+v", list)
+			}
+
+			// подписчик получает копию, изменения после Replace ее не затрагивают
+			var notified []repository.Metrics
+			repo.OnChange(func(metrics []repository.Metrics) {
+				if notified == nil {
+					notified = metrics
+					other := 5.0
+					repo.Update(ctx, repository.Metrics{ID: "poll", MType: "gauge", Value: &other})
+				}
+			})
+			if err := repo.Replace(ctx, []repository.Metrics{{ID: "poll", MType: "gauge", Value: &value}}); err != nil {
+				t.Fatal(err)
+			}
+			if len(notified) != 1 || *notified[0].Value != value {
+				t.Fatalf("notified metrics must not be changed by later update, got %+v", notified)
+			}
+		})
+	}
+}
+
+func TestUpdateOpSet(t *testing.T) {
+	ctx := context.Background()
+	var delta int64 = 5
+	var fixed int64 = 2
+
+	for name, repo := range map[string]repository.MetricRepository{
+		"slice storage": inmemory.New(),
+		"map storage":   inmemory.NewFast(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			repo.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+
+			res, err := repo.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &fixed, Op: repository.OpSet})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *res.Delta != 2 || res.Op != "" {
+				t.Fatalf("expected counter set to 2, got %+v", res)
+			}
+
+			res, _ = repo.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+			if *res.Delta != 7 {
+				t.Fatalf("expected counter 7 after add, got %d", *res.Delta)
+			}
+		})
+	}
+}
+
+func TestBulkUpdateOnce(t *testing.T) {
+	ctx := context.Background()
+	var delta int64 = 1
+
+	for name, repo := range map[string]repository.MetricRepository{
+		"slice storage": inmemory.New(),
+		"map storage":   inmemory.NewFast(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			batch := []repository.Metrics{{ID: "poll", MType: "counter", Delta: &delta}}
+
+			if err := repo.BulkUpdateOnce(ctx, "key", batch); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.BulkUpdateOnce(ctx, "key", batch); !errors.Is(err, repository.ErrDuplicate) {
+				t.Fatalf("expected ErrDuplicate, got %v", err)
+			}
+
+			m, _ := repo.Get(ctx, "poll")
+			if *m.Delta != 1 {
+				t.Fatalf("duplicate batch must not be applied, got %d", *m.Delta)
+			}
+
+			// пачка с ошибкой не применяется и не запоминается, повтор с тем же ключом применяет ее один раз
+			invalid := []repository.Metrics{{ID: "poll", MType: "counter", Delta: &delta}, {ID: "poll", MType: "gauge"}}
+			if err := repo.BulkUpdateOnce(ctx, "other", invalid); err == nil {
+				t.Fatal("expected type conflict")
+			}
+			if err := repo.BulkUpdateOnce(ctx, "other", batch); err != nil {
+				t.Fatalf("key of failed batch must be released, got %v", err)
+			}
+			if m, _ := repo.Get(ctx, "poll"); *m.Delta != 2 {
+				t.Fatalf("retried batch must be applied once, got %d", *m.Delta)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package repository
+
+import "context"
+
+// Lifecycle starts and stops background jobs and resources of storage.
+type Lifecycle interface {
+	// Start runs background jobs (e.g. periodic snapshot), they work until Close.
+	Start(ctx context.Context) error
+	// Close stops background jobs, flushes buffered data and releases connections and files,
+	// storage must not be used after Close. ctx limits waiting for jobs in progress.
+	Close(ctx context.Context) error
+}
+
+// Storage is repository managed by application: started after creation and closed on shutdown.
+type Storage interface {
+	MetricRepository
+	Lifecycle
+}
@@ -0,0 +1,46 @@
+package repository
+
+import "sync"
+
+// ChangeHook is called after successful Update/BulkUpdate with new values of metrics.
+//
+// Hook is called synchronously, so it must not block for long.
+type ChangeHook func(metrics []Metrics)
+
+// Notifier keeps subscribers of metric changes, it's embedded into MetricRepository implementations.
+//
+// It's safe for concurrent use by multiple goroutines.
+type Notifier struct {
+	mu    sync.RWMutex
+	hooks []ChangeHook
+}
+
+// OnChange registers hook called on every metrics update.
+func (n *Notifier) OnChange(hook ChangeHook) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.hooks = append(n.hooks, hook)
+}
+
+// HasHooks reports whether any hook is registered,
+// so storages can skip loading updated values when nobody listens.
+func (n *Notifier) HasHooks() bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return len(n.hooks) > 0
+}
+
+// Notify passes updated metrics to all hooks.
+func (n *Notifier) Notify(metrics ...Metrics) {
+	if len(metrics) == 0 {
+		return
+	}
+
+	n.mu.RLock()
+	hooks := n.hooks
+	n.mu.RUnlock()
+
+	for _, hook := range hooks {
+		hook(metrics)
+	}
+}
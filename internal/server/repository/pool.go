@@ -0,0 +1,22 @@
+package repository
+
+// PoolStats statistics of storage connection pool.
+type PoolStats struct {
+	MaxConns             int32   `json:"max_conns"`              // максимальный размер пула
+	TotalConns           int32   `json:"total_conns"`            // открытые соединения, включая устанавливаемые
+	IdleConns            int32   `json:"idle_conns"`             // свободные соединения
+	AcquiredConns        int32   `json:"acquired_conns"`         // соединения, занятые запросами
+	ConstructingConns    int32   `json:"constructing_conns"`     // устанавливаемые соединения
+	AcquireCount         int64   `json:"acquire_count"`          // количество успешных получений соединения
+	EmptyAcquireCount    int64   `json:"empty_acquire_count"`    // получения соединения с ожиданием, пул был пуст
+	CanceledAcquireCount int64   `json:"canceled_acquire_count"` // получения соединения, отмененные контекстом
+	AcquireDurationMs    float64 `json:"acquire_duration_ms"`    // суммарное время получения соединений
+	NewConnsCount        int64   `json:"new_conns_count"`        // количество установленных соединений
+	MaxLifetimeDestroyed int64   `json:"max_lifetime_destroyed"` // соединения, закрытые по времени жизни
+	MaxIdleDestroyed     int64   `json:"max_idle_destroyed"`     // соединения, закрытые по времени простоя
+}
+
+// PoolStatsProvider is implemented by storages with connection pool.
+type PoolStatsProvider interface {
+	PoolStats() PoolStats
+}
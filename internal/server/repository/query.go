@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ListQuery parameters of metrics selection for MetricRepository.List.
+//
+// Filters are combined with AND, empty filter doesn't limit selection.
+// Metrics are sorted by ID, cursor is ID of the last metric of previous page.
+type ListQuery struct {
+	Prefix string   // префикс ID
+	Glob   string   // шаблон ID в синтаксисе path.Match, например cpu.*
+	Regex  string   // регулярное выражение для ID в синтаксисе RE2 для всех хранилищ
+	Types  []string // типы метрик
+	Desc   bool     // сортировка по убыванию ID
+	Limit  int      // размер страницы, 0 - без ограничения
+	Cursor string   // выборка продолжается после метрики с этим ID
+}
+
+// ListPage page of metrics returned by MetricRepository.List.
+type ListPage struct {
+	Metrics    []Metrics
+	NextCursor string // пусто, если страница последняя
+}
+
+// Validate checks glob and regex syntax and limit, error wraps ErrInvalidQuery.
+func (q ListQuery) Validate() error {
+	if q.Limit < 0 {
+		return fmt.Errorf("%w: negative limit", ErrInvalidQuery)
+	}
+	if q.Glob != "" {
+		if _, err := path.Match(q.Glob, ""); err != nil {
+			return fmt.Errorf("%w: glob %q: %w", ErrInvalidQuery, q.Glob, err)
+		}
+	}
+	if q.Regex != "" {
+		if _, err := regexp.Compile(q.Regex); err != nil {
+			return fmt.Errorf("%w: regex: %w", ErrInvalidQuery, err)
+		}
+	}
+	return nil
+}
+
+// Matcher returns function checking metric against query filters, query must be valid.
+func (q ListQuery) Matcher() func(m *Metrics) bool {
+	var re *regexp.Regexp
+	if q.Regex != "" {
+		re = regexp.MustCompile(q.Regex)
+	}
+
+	return func(m *Metrics) bool {
+		if q.Prefix != "" && !strings.HasPrefix(m.ID, q.Prefix) {
+			return false
+		}
+		if q.Glob != "" {
+			if ok, _ := path.Match(q.Glob, m.ID); !ok {
+				return false
+			}
+		}
+		if re != nil && !re.MatchString(m.ID) {
+			return false
+		}
+		if len(q.Types) > 0 && !containsString(q.Types, m.MType) {
+			return false
+		}
+		return !q.afterCursor(m.ID)
+	}
+}
+
+// afterCursor reports whether metric with id was returned on previous pages
+func (q ListQuery) afterCursor(id string) bool {
+	if q.Cursor == "" {
+		return false
+	}
+	if q.Desc {
+		return id >= q.Cursor
+	}
+	return id <= q.Cursor
+}
+
+// Paginate sorts filtered metrics and cuts page with limit,
+// in-memory storages use it after filtering with Matcher.
+func (q ListQuery) Paginate(metrics []Metrics) *ListPage {
+	sort.Slice(metrics, func(i, j int) bool {
+		if q.Desc {
+			return metrics[i].ID > metrics[j].ID
+		}
+		return metrics[i].ID < metrics[j].ID
+	})
+
+	page := &ListPage{Metrics: metrics}
+	if q.Limit > 0 && len(metrics) > q.Limit {
+		page.Metrics = metrics[:q.Limit]
+		page.NextCursor = page.Metrics[q.Limit-1].ID
+	}
+	return page
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
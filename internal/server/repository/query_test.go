@@ -0,0 +1,71 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+func TestListQuery(t *testing.T) {
+	metrics := []repository.Metrics{
+		{ID: "cpu.1", MType: "gauge"},
+		{ID: "cpu.2", MType: "gauge"},
+		{ID: "cpu.10", MType: "counter"},
+		{ID: "mem.alloc", MType: "gauge"},
+		{ID: "PollCount", MType: "counter"},
+	}
+
+	ids := func(q repository.ListQuery) []string {
+		match := q.Matcher()
+		filtered := make([]repository.Metrics, 0)
+		for i := range metrics {
+			if match(&metrics[i]) {
+				filtered = append(filtered, metrics[i])
+			}
+		}
+		res := make([]string, 0)
+		for _, m := range q.Paginate(filtered).Metrics {
+			res = append(res, m.ID)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name string
+		q    repository.ListQuery
+		want []string
+	}{
+		{name: "all", q: repository.ListQuery{}, want: []string{"PollCount", "cpu.1", "cpu.10", "cpu.2", "mem.alloc"}},
+		{name: "prefix", q: repository.ListQuery{Prefix: "cpu."}, want: []string{"cpu.1", "cpu.10", "cpu.2"}},
+		{name: "glob", q: repository.ListQuery{Glob: "cpu.?"}, want: []string{"cpu.1", "cpu.2"}},
+		{name: "regex", q: repository.ListQuery{Regex: `^cpu\.\d{2}$`}, want: []string{"cpu.10"}},
+		{name: "types", q: repository.ListQuery{Types: []string{"counter"}}, want: []string{"PollCount", "cpu.10"}},
+		{name: "desc", q: repository.ListQuery{Prefix: "cpu.", Desc: true}, want: []string{"cpu.2", "cpu.10", "cpu.1"}},
+		{name: "cursor", q: repository.ListQuery{Cursor: "cpu.10", Limit: 2}, want: []string{"cpu.2", "mem.alloc"}},
+		{name: "desc cursor", q: repository.ListQuery{Cursor: "cpu.10", Desc: true}, want: []string{"cpu.1", "PollCount"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.NoError(t, test.q.Validate())
+			assert.Equal(t, test.want, ids(test.q))
+		})
+	}
+
+	t.Run("next cursor", func(t *testing.T) {
+		page := repository.ListQuery{Limit: 2}.Paginate(append([]repository.Metrics{}, metrics...))
+		assert.Len(t, page.Metrics, 2)
+		assert.Equal(t, "cpu.1", page.NextCursor)
+
+		page = repository.ListQuery{Limit: 5}.Paginate(append([]repository.Metrics{}, metrics...))
+		assert.Empty(t, page.NextCursor)
+	})
+
+	t.Run("invalid query", func(t *testing.T) {
+		assert.ErrorIs(t, repository.ListQuery{Glob: "cpu.["}.Validate(), repository.ErrInvalidQuery)
+		assert.ErrorIs(t, repository.ListQuery{Regex: "cpu.("}.Validate(), repository.ErrInvalidQuery)
+		assert.ErrorIs(t, repository.ListQuery{Limit: -1}.Validate(), repository.ErrInvalidQuery)
+	})
+}
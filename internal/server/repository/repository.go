@@ -3,22 +3,46 @@ package repository
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/benderr/metrics/pkg/ddsketch"
+	"github.com/benderr/metrics/pkg/histogram"
+	"github.com/benderr/metrics/pkg/hll"
 )
 
 type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID        string               `json:"id"`                  // имя метрики
+	MType     string               `json:"type"`                // параметр, принимающий значение gauge, counter, histogram, summary или set
+	Delta     *int64               `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Value     *float64             `json:"value,omitempty"`     // значение метрики в случае передачи gauge
+	Histogram *histogram.Histogram `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
+	Summary   *ddsketch.Sketch     `json:"summary,omitempty"`   // значение метрики в случае передачи summary
+	Set       *hll.Sketch          `json:"set,omitempty"`       // значение метрики в случае передачи set
+	Op        string               `json:"op,omitempty"`        // операция обновления: add (по умолчанию) или set
 }
 
+// Operations of metric update.
+const (
+	OpAdd = "add" // counter delta is added, sketches are merged with stored ones
+	OpSet = "set" // stored value is overwritten, e.g. to correct counter or restore it from backup
+)
+
+// DefaultQuantiles are reported for summary metrics when quantiles are not requested explicitly.
+var DefaultQuantiles = []float64{0.5, 0.9, 0.99}
+
 type MetricRepository interface {
 	BulkUpdate(ctx context.Context, metrics []Metrics) error
+	BulkUpdateOnce(ctx context.Context, key string, metrics []Metrics) error
+	Replace(ctx context.Context, metrics []Metrics) error
 	Update(ctx context.Context, metric Metrics) (*Metrics, error)
 	Get(ctx context.Context, id string) (*Metrics, error)
 	GetList(ctx context.Context) ([]Metrics, error)
+	List(ctx context.Context, q ListQuery) (*ListPage, error)
+	Iterate(ctx context.Context, q ListQuery, fn func(m Metrics) error) error
 	PingContext(ctx context.Context) error
+	OnChange(hook ChangeHook)
 }
 
 type Logger interface {
@@ -40,6 +64,120 @@ func (m *Metrics) GetStringValue() string {
 		} else {
 			return "<nil>"
 		}
+
+	case "histogram":
+		if m.Histogram != nil {
+			return fmt.Sprintf("count=%d sum=%v", m.Histogram.Count, m.Histogram.Sum)
+		} else {
+			return "<nil>"
+		}
+
+	case "summary":
+		if m.Summary != nil {
+			parts := make([]string, 0, len(DefaultQuantiles))
+			for q, v := range m.GetQuantiles(DefaultQuantiles) {
+				parts = append(parts, fmt.Sprintf("%s=%v", q, v))
+			}
+			sort.Strings(parts)
+			return strings.Join(parts, " ")
+		} else {
+			return "<nil>"
+		}
+
+	case "set":
+		if m.Set != nil {
+			return fmt.Sprintf("%v", m.Set.Estimate())
+		} else {
+			return "<nil>"
+		}
 	}
 	return ""
 }
+
+// Merge applies update mtr to the existing metric m.
+//
+// Gauge value is overwritten, counter delta is added,
+// histogram buckets, summary and set sketches are merged (bounds, accuracy and precision must be equal).
+// If mtr.Op is OpSet, value of any type is overwritten.
+// Sketches are never mutated in place, a merged copy is assigned instead,
+// so readers holding the previous value are not affected.
+//
+// If types of metrics differ or sketches are incompatible, error wrapping ErrTypeConflict returned.
+func (m *Metrics) Merge(mtr Metrics) error {
+	if m.MType != mtr.MType {
+		return fmt.Errorf("%w: %s is %s, got %s", ErrTypeConflict, m.ID, m.MType, mtr.MType)
+	}
+
+	if mtr.Op == OpSet {
+		m.Delta = mtr.Delta
+		m.Value = mtr.Value
+		m.Histogram = mtr.Histogram.Clone()
+		m.Summary = mtr.Summary.Clone()
+		m.Set = mtr.Set.Clone()
+		return nil
+	}
+
+	switch mtr.MType {
+	case "gauge":
+		m.Value = mtr.Value
+	case "counter":
+		var newVal int64
+		if m.Delta != nil {
+			newVal = *m.Delta
+		}
+		if mtr.Delta != nil {
+			newVal += *mtr.Delta
+		}
+		m.Delta = &newVal
+	case "histogram":
+		if m.Histogram == nil {
+			m.Histogram = mtr.Histogram.Clone()
+			return nil
+		}
+		merged := m.Histogram.Clone()
+		if err := merged.Merge(mtr.Histogram); err != nil {
+			return fmt.Errorf("%w: %w", ErrTypeConflict, err)
+		}
+		m.Histogram = merged
+	case "summary":
+		if m.Summary == nil {
+			m.Summary = mtr.Summary.Clone()
+			return nil
+		}
+		merged := m.Summary.Clone()
+		if err := merged.Merge(mtr.Summary); err != nil {
+			return fmt.Errorf("%w: %w", ErrTypeConflict, err)
+		}
+		m.Summary = merged
+	case "set":
+		if m.Set == nil {
+			m.Set = mtr.Set.Clone()
+			return nil
+		}
+		merged := m.Set.Clone()
+		if err := merged.Merge(mtr.Set); err != nil {
+			return fmt.Errorf("%w: %w", ErrTypeConflict, err)
+		}
+		m.Set = merged
+	}
+	return nil
+}
+
+// GetQuantiles returns values of summary metric for each quantile,
+// keys are percentiles with "p" prefix ("p50", "p99.9").
+//
+// Invalid quantiles are skipped, for other metric types nil is returned.
+func (m *Metrics) GetQuantiles(quantiles []float64) map[string]float64 {
+	if m.Summary == nil {
+		return nil
+	}
+	res := make(map[string]float64, len(quantiles))
+	for _, q := range quantiles {
+		v, err := m.Summary.Quantile(q)
+		if err != nil {
+			continue
+		}
+		res["p"+strconv.FormatFloat(q*100, 'f', -1, 64)] = v
+	}
+	return res
+}
@@ -0,0 +1,101 @@
+package sqlitestorage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// LatestVersion target of Migrate to apply all migrations.
+const LatestVersion = -1
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.sql$`)
+
+// Migration is a versioned schema change, files are named {version}_{name}.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+}
+
+// Migrations returns embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Migration, 0, len(entries))
+	for _, e := range entries {
+		parts := migrationName.FindStringSubmatch(e.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", e.Name())
+		}
+
+		version, _ := strconv.Atoi(parts[1])
+		content, err := migrationFiles.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, Migration{Version: version, Name: parts[2], Up: string(content)})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Version < res[j].Version
+	})
+	return res, nil
+}
+
+// Migrate applies migrations up to target version, LatestVersion applies all of them.
+//
+// Version of schema is stored in user_version pragma and is updated in the transaction of migration.
+// Database file is usually copied to roll back, so migrations down are not supported.
+func (m *MetricSQLiteRepository) Migrate(ctx context.Context, target int) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	if target < LatestVersion {
+		return fmt.Errorf("invalid schema version %d", target)
+	}
+	if target == LatestVersion && len(migrations) > 0 {
+		target = migrations[len(migrations)-1].Version
+	}
+
+	var current int
+	if err := m.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&current); err != nil {
+		return err
+	}
+	if current > target {
+		return fmt.Errorf("schema version %d is newer than %d, rollback is not supported", current, target)
+	}
+
+	for _, mg := range migrations {
+		if mg.Version <= current || mg.Version > target {
+			continue
+		}
+		err := m.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, mg.Up); err != nil {
+				return err
+			}
+			// pragma не поддерживает параметры
+			_, err := tx.ExecContext(ctx, "PRAGMA user_version = "+strconv.Itoa(mg.Version))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("apply migration %d_%s: %w", mg.Version, mg.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS metrics
+(
+    id text NOT NULL PRIMARY KEY,
+    type text NOT NULL,
+    delta integer,
+    value real,
+    histogram text,
+    summary blob,
+    hll blob
+);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    key text NOT NULL PRIMARY KEY,
+    created_at integer NOT NULL
+);
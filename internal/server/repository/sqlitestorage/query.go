@@ -0,0 +1,178 @@
+package sqlitestorage
+
+import (
+	"context"
+	"strings"
+
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+// List returns page of metrics matching query.
+//
+// Types, cursor, prefix and glob are filtered in SQL, then ID patterns are checked with repository.ListQuery.Matcher,
+// so glob and regex syntax is the same as for in-memory storages.
+func (m *MetricSQLiteRepository) List(ctx context.Context, q repository.ListQuery) (*repository.ListPage, error) {
+	metrics := make([]repository.Metrics, 0)
+	err := m.scan(ctx, q, func(v repository.Metrics) error {
+		metrics = append(metrics, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &repository.ListPage{Metrics: metrics}
+	// scan читает на одну запись больше лимита, чтобы узнать о следующей странице
+	if q.Limit > 0 && len(metrics) > q.Limit {
+		page.Metrics = metrics[:q.Limit]
+		page.NextCursor = page.Metrics[q.Limit-1].ID
+	}
+	return page, nil
+}
+
+// Iterate calls fn for every metric matching query in ID order, iteration stops on first error of fn.
+func (m *MetricSQLiteRepository) Iterate(ctx context.Context, q repository.ListQuery, fn func(m repository.Metrics) error) error {
+	count := 0
+	return m.scan(ctx, q, func(v repository.Metrics) error {
+		// лишняя запись для курсора следующей страницы не передается
+		if q.Limit > 0 && count == q.Limit {
+			return nil
+		}
+		count++
+		return fn(v)
+	})
+}
+
+// scan reads matching metrics with cursor, at most q.Limit+1 metrics are passed to fn
+func (m *MetricSQLiteRepository) scan(ctx context.Context, q repository.ListQuery, fn func(m repository.Metrics) error) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+
+	query, args := buildListQuery(q)
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	match := q.Matcher()
+	count := 0
+	for rows.Next() {
+		if q.Limit > 0 && count > q.Limit {
+			break
+		}
+		v, err := scanMetric(rows)
+		if err != nil {
+			return err
+		}
+		if !match(v) {
+			continue
+		}
+		count++
+		if err := fn(*v); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// buildListQuery returns SELECT with type, cursor, prefix and glob filters of query and its arguments.
+//
+// Text is compared with BINARY collation, so order is the same as for in-memory storages.
+// Prefix is range of primary key, glob is translated to SQLite GLOB which matches the same IDs or more,
+// regex isn't supported by SQLite and is checked by Matcher only.
+func buildListQuery(q repository.ListQuery) (string, []any) {
+	where := make([]string, 0)
+	args := make([]any, 0)
+
+	if q.Prefix != "" {
+		where = append(where, "id >= ?")
+		args = append(args, q.Prefix)
+		if end, ok := prefixEnd(q.Prefix); ok {
+			where = append(where, "id < ?")
+			args = append(args, end)
+		}
+	}
+	if q.Glob != "" {
+		where = append(where, "id GLOB ?")
+		args = append(args, sqliteGlob(q.Glob))
+	}
+	if len(q.Types) > 0 {
+		where = append(where, "type IN (?"+strings.Repeat(", ?", len(q.Types)-1)+")")
+		for _, t := range q.Types {
+			args = append(args, t)
+		}
+	}
+
+	order := "ASC"
+	if q.Cursor != "" {
+		op := ">"
+		if q.Desc {
+			op = "<"
+		}
+		where = append(where, "id "+op+" ?")
+		args = append(args, q.Cursor)
+	}
+	if q.Desc {
+		order = "DESC"
+	}
+
+	var b strings.Builder
+	b.WriteString("SELECT id, type, delta, value, histogram, summary, hll FROM metrics")
+	if len(where) > 0 {
+		b.WriteString(" WHERE " + strings.Join(where, " AND "))
+	}
+	b.WriteString(" ORDER BY id " + order)
+
+	return b.String(), args
+}
+
+// prefixEnd returns the least string greater than all strings with prefix in byte order,
+// false is returned if there is no such string (prefix consists of 0xff bytes).
+func prefixEnd(prefix string) (string, bool) {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+// sqliteGlob translates valid path.Match pattern to SQLite GLOB pattern matching the same strings or more.
+//
+// Wildcards * and ? are kept (in GLOB they match / too), escaped characters are matched literally,
+// character class is replaced with ? because syntax of ranges differs.
+func sqliteGlob(pattern string) string {
+	var b strings.Builder
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*', '?':
+			b.WriteRune(r)
+		case '[':
+			// класс продолжается до ], \ экранирует следующий символ
+			for i++; i < len(runes) && runes[i] != ']'; i++ {
+				if runes[i] == '\\' {
+					i++
+				}
+			}
+			b.WriteRune('?')
+		default:
+			if r == '\\' && i+1 < len(runes) {
+				i++
+				r = runes[i]
+			}
+			if r == '*' || r == '?' || r == '[' {
+				// в GLOB специальный символ экранируется классом из одного символа
+				b.WriteString("[" + string(r) + "]")
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
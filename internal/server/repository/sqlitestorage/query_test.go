@@ -0,0 +1,36 @@
+package sqlitestorage
+
+import "testing"
+
+func TestSQLiteGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"cpu.*", "cpu.*"},
+		{"cpu.?", "cpu.?"},
+		{"cpu[0-9]", "cpu?"},
+		{"cpu[^\\]]x", "cpu?x"},
+		{"a\\*b", "a[*]b"},
+		{"a\\[b", "a[[]b"},
+		{"a\\xb", "axb"},
+		{"a]b", "a]b"},
+	}
+	for _, tt := range tests {
+		if got := sqliteGlob(tt.pattern); got != tt.want {
+			t.Errorf("sqliteGlob(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	if end, ok := prefixEnd("cpu"); !ok || end != "cpv" {
+		t.Errorf("expected cpv, got %q", end)
+	}
+	if end, ok := prefixEnd("a\xff"); !ok || end != "b" {
+		t.Errorf("expected b, got %q", end)
+	}
+	if _, ok := prefixEnd("\xff\xff"); ok {
+		t.Error("expected no end of 0xff prefix")
+	}
+}
@@ -0,0 +1,362 @@
+// Package sqlitestorage implements MetricRepository on embedded SQLite database.
+//
+// Storage doesn't require database server and keeps every committed update on disk,
+// so it's suitable for single node installations. Driver is pure Go, cgo is not required.
+package sqlitestorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/pkg/ddsketch"
+	"github.com/benderr/metrics/pkg/histogram"
+	"github.com/benderr/metrics/pkg/hll"
+)
+
+// Scheme prefix of database DSN selecting SQLite storage, e.g. sqlite:///var/lib/metrics.db
+const Scheme = "sqlite://"
+
+// busyTimeout время ожидания блокировки базы другим соединением
+const busyTimeout = 5 * time.Second
+
+// MetricSQLiteRepository is a SQLite database handle, which implements MetricRepository
+type MetricSQLiteRepository struct {
+	repository.Notifier
+	db  *sql.DB
+	log repository.Logger
+}
+
+// Open opens database file of dsn (sqlite:///path/to/file.db), file is created if not exists.
+//
+// Database works in WAL journal mode, so readers don't block writer,
+// and transactions take write lock on begin to avoid deadlocks of concurrent read-modify-write.
+func Open(dsn string) (*sql.DB, error) {
+	path, params, _ := strings.Cut(strings.TrimPrefix(dsn, Scheme), "?")
+	if path == "" {
+		return nil, errors.New("sqlite dsn requires file path")
+	}
+
+	pragmas := fmt.Sprintf("_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_txlock=immediate", busyTimeout.Milliseconds())
+	if params != "" {
+		pragmas = params + "&" + pragmas
+	}
+
+	return sql.Open("sqlite", "file:"+path+"?"+pragmas)
+}
+
+func New(db *sql.DB, log repository.Logger) *MetricSQLiteRepository {
+	return &MetricSQLiteRepository{
+		db:  db,
+		log: log,
+	}
+}
+
+// querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Update merges metric with the stored one in transaction, see repository.Metrics.Merge
+func (m *MetricSQLiteRepository) Update(ctx context.Context, mtr repository.Metrics) (*repository.Metrics, error) {
+	var res *repository.Metrics
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		res, err = apply(ctx, tx, mtr)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.Notify(*res)
+	return res, nil
+}
+
+// BulkUpdate applies batch in one transaction, nothing is changed if some item fails.
+//
+// Error of item is returned as *repository.ItemError.
+func (m *MetricSQLiteRepository) BulkUpdate(ctx context.Context, metrics []repository.Metrics) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	var updated []repository.Metrics
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		updated, err = applyBatch(ctx, tx, metrics)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	m.Notify(updated...)
+	return nil
+}
+
+// BulkUpdateOnce applies batch like BulkUpdate if key was not used within repository.IdempotencyTTL,
+// otherwise error wrapping repository.ErrDuplicate is returned.
+//
+// Key is written in the same transaction as metrics, so it's saved only if batch is committed.
+func (m *MetricSQLiteRepository) BulkUpdateOnce(ctx context.Context, key string, metrics []repository.Metrics) error {
+	var updated []repository.Metrics
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		if err := reserveKey(ctx, tx, key); err != nil {
+			return err
+		}
+		var err error
+		updated, err = applyBatch(ctx, tx, metrics)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	m.Notify(updated...)
+	return nil
+}
+
+// Replace removes all metrics and stores given ones in one transaction.
+func (m *MetricSQLiteRepository) Replace(ctx context.Context, metrics []repository.Metrics) error {
+	var updated []repository.Metrics
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM metrics"); err != nil {
+			return err
+		}
+		var err error
+		updated, err = applyBatch(ctx, tx, metrics)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	m.Notify(updated...)
+	return nil
+}
+
+// inTx runs fn in transaction, transaction is committed if fn succeeds
+func (m *MetricSQLiteRepository) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// reserveKey removes expired keys and saves new one, ErrDuplicate is returned if key exists
+func reserveKey(ctx context.Context, tx *sql.Tx, key string) error {
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created_at < ?", now.Add(-repository.IdempotencyTTL).Unix()); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO idempotency_keys (key, created_at) VALUES (?, ?)", key, now.Unix())
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %s", repository.ErrDuplicate, key)
+	}
+	return nil
+}
+
+// applyBatch applies metrics one by one and returns new values of updated metrics in order of first appearance
+func applyBatch(ctx context.Context, tx *sql.Tx, metrics []repository.Metrics) ([]repository.Metrics, error) {
+	updated := make([]repository.Metrics, 0, len(metrics))
+	positions := make(map[string]int, len(metrics))
+	for i, mtr := range metrics {
+		res, err := apply(ctx, tx, mtr)
+		if err != nil {
+			return nil, repository.NewItemError(i, mtr.ID, err)
+		}
+
+		if pos, ok := positions[mtr.ID]; ok {
+			updated[pos] = *res
+			continue
+		}
+		positions[mtr.ID] = len(updated)
+		updated = append(updated, *res)
+	}
+	return updated, nil
+}
+
+// apply merges metric with the stored one in memory and writes result back.
+//
+// Transaction holds write lock of database, so read-modify-write is not interleaved with other writers.
+func apply(ctx context.Context, tx *sql.Tx, mtr repository.Metrics) (*repository.Metrics, error) {
+	stored, err := get(ctx, tx, mtr.ID)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		stored = &repository.Metrics{ID: mtr.ID, MType: mtr.MType}
+	}
+
+	if err := stored.Merge(mtr); err != nil {
+		return nil, err
+	}
+
+	var hist []byte
+	if stored.Histogram != nil {
+		if hist, err = json.Marshal(stored.Histogram); err != nil {
+			return nil, err
+		}
+	}
+
+	var summary []byte
+	if stored.Summary != nil {
+		if summary, err = stored.Summary.MarshalBinary(); err != nil {
+			return nil, err
+		}
+	}
+
+	var set []byte
+	if stored.Set != nil {
+		if set, err = stored.Set.MarshalBinary(); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO metrics (id, type, delta, value, histogram, summary, hll)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (id)
+	DO UPDATE SET delta=excluded.delta, value=excluded.value, histogram=excluded.histogram, summary=excluded.summary, hll=excluded.hll`,
+		stored.ID, stored.MType, stored.Delta, stored.Value, nullString(hist), summary, set)
+	if err != nil {
+		return nil, err
+	}
+
+	return stored, nil
+}
+
+// nullString stores histogram json as text, nil is NULL
+func nullString(b []byte) sql.NullString {
+	return sql.NullString{Valid: b != nil, String: string(b)}
+}
+
+// Get return pointer of existed metric by ID or return nil
+func (m *MetricSQLiteRepository) Get(ctx context.Context, id string) (*repository.Metrics, error) {
+	return get(ctx, m.db, id)
+}
+
+func get(ctx context.Context, q querier, id string) (*repository.Metrics, error) {
+	row := q.QueryRowContext(ctx, "SELECT id, type, delta, value, histogram, summary, hll FROM metrics WHERE id = ?", id)
+	v, err := scanMetric(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return v, err
+}
+
+// GetList return all existed metrics in db
+func (m *MetricSQLiteRepository) GetList(ctx context.Context) ([]repository.Metrics, error) {
+	metrics := make([]repository.Metrics, 0)
+	err := m.Iterate(ctx, repository.ListQuery{}, func(v repository.Metrics) error {
+		metrics = append(metrics, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+func (m *MetricSQLiteRepository) PingContext(ctx context.Context) error {
+	if m.db == nil {
+		return errors.New("no initialized")
+	}
+	return m.db.PingContext(ctx)
+}
+
+// Start does nothing, changes are written in transactions
+func (m *MetricSQLiteRepository) Start(ctx context.Context) error {
+	return nil
+}
+
+// Close closes database, WAL of sqlite is checkpointed on close of the last connection
+func (m *MetricSQLiteRepository) Close(ctx context.Context) error {
+	return m.db.Close()
+}
+
+// Prepare checks connection and applies all embedded migrations, see Migrate
+func (m *MetricSQLiteRepository) Prepare(ctx context.Context) error {
+	if err := m.PingContext(ctx); err != nil {
+		return err
+	}
+
+	return m.Migrate(ctx, LatestVersion)
+}
+
+// PoolStats returns statistics of database/sql connection pool.
+func (m *MetricSQLiteRepository) PoolStats() repository.PoolStats {
+	s := m.db.Stats()
+	return repository.PoolStats{
+		MaxConns:             int32(s.MaxOpenConnections),
+		TotalConns:           int32(s.OpenConnections),
+		IdleConns:            int32(s.Idle),
+		AcquiredConns:        int32(s.InUse),
+		EmptyAcquireCount:    s.WaitCount,
+		AcquireDurationMs:    float64(s.WaitDuration) / float64(time.Millisecond),
+		MaxLifetimeDestroyed: s.MaxLifetimeClosed,
+		MaxIdleDestroyed:     s.MaxIdleTimeClosed,
+	}
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanMetric reads metric from row with columns id, type, delta, value, histogram, summary, hll
+func scanMetric(row scanner) (*repository.Metrics, error) {
+	var v repository.Metrics
+	var hist sql.NullString
+	var summary, set []byte
+	if err := row.Scan(&v.ID, &v.MType, &v.Delta, &v.Value, &hist, &summary, &set); err != nil {
+		return nil, err
+	}
+
+	if hist.Valid {
+		v.Histogram = &histogram.Histogram{}
+		if err := json.Unmarshal([]byte(hist.String), v.Histogram); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(summary) > 0 {
+		v.Summary = &ddsketch.Sketch{}
+		if err := v.Summary.UnmarshalBinary(summary); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(set) > 0 {
+		v.Set = &hll.Sketch{}
+		if err := v.Set.UnmarshalBinary(set); err != nil {
+			return nil, err
+		}
+	}
+
+	return &v, nil
+}
@@ -0,0 +1,221 @@
+package sqlitestorage_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/internal/server/repository/sqlitestorage"
+	"github.com/benderr/metrics/pkg/hll"
+)
+
+func openRepo(t *testing.T, path string) *sqlitestorage.MetricSQLiteRepository {
+	t.Helper()
+
+	db, err := sqlitestorage.Open(sqlitestorage.Scheme + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo := sqlitestorage.New(db, nil)
+	if err := repo.Prepare(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	repo := openRepo(t, path)
+
+	var delta, fixed int64 = 5, 2
+	value := 1.5
+
+	var changes [][]repository.Metrics
+	repo.OnChange(func(metrics []repository.Metrics) {
+		changes = append(changes, metrics)
+	})
+
+	repo.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+	res, err := repo.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *res.Delta != 10 {
+		t.Fatalf("expected counter 10, got %d", *res.Delta)
+	}
+
+	res, _ = repo.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &fixed, Op: repository.OpSet})
+	if *res.Delta != 2 {
+		t.Fatalf("expected counter set to 2, got %d", *res.Delta)
+	}
+
+	if _, err := repo.Update(ctx, repository.Metrics{ID: "poll", MType: "gauge", Value: &value}); !errors.Is(err, repository.ErrTypeConflict) {
+		t.Fatalf("expected type conflict, got %v", err)
+	}
+
+	set, _ := hll.New(hll.DefaultPrecision)
+	set.AddString("a")
+	set.AddString("b")
+	repo.Update(ctx, repository.Metrics{ID: "users", MType: "set", Set: set})
+
+	if len(changes) != 4 {
+		t.Fatalf("expected 4 notifications, got %d", len(changes))
+	}
+
+	// данные сохраняются в файле
+	reopened := openRepo(t, path)
+	m, err := reopened.Get(ctx, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Set == nil || m.Set.Estimate() != 2 {
+		t.Fatalf("expected set with 2 values, got %+v", m)
+	}
+
+	m, _ = reopened.Get(ctx, "unknown")
+	if m != nil {
+		t.Fatalf("expected nil for unknown metric, got %+v", m)
+	}
+}
+
+func TestBulkUpdate(t *testing.T) {
+	ctx := context.Background()
+	repo := openRepo(t, filepath.Join(t.TempDir(), "metrics.db"))
+
+	var delta int64 = 1
+	value := 1.5
+
+	err := repo.BulkUpdate(ctx, []repository.Metrics{
+		{ID: "poll", MType: "counter", Delta: &delta},
+		{ID: "load", MType: "gauge", Value: &value},
+		{ID: "poll", MType: "counter", Delta: &delta},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.BulkUpdate(ctx, []repository.Metrics{
+		{ID: "poll", MType: "counter", Delta: &delta},
+		{ID: "load", MType: "counter", Delta: &delta},
+	})
+	var itemErr *repository.ItemError
+	if !errors.As(err, &itemErr) || itemErr.Index != 1 {
+		t.Fatalf("expected error of item 1, got %v", err)
+	}
+
+	m, _ := repo.Get(ctx, "poll")
+	if *m.Delta != 2 {
+		t.Fatalf("failed batch must be rolled back, got counter %d", *m.Delta)
+	}
+
+	batch := []repository.Metrics{{ID: "poll", MType: "counter", Delta: &delta}}
+	if err := repo.BulkUpdateOnce(ctx, "key", batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.BulkUpdateOnce(ctx, "key", batch); !errors.Is(err, repository.ErrDuplicate) {
+		t.Fatalf("expected ErrDuplicate, got %v", err)
+	}
+
+	m, _ = repo.Get(ctx, "poll")
+	if *m.Delta != 3 {
+		t.Fatalf("duplicate batch must not be applied, got %d", *m.Delta)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	ctx := context.Background()
+	repo := openRepo(t, filepath.Join(t.TempDir(), "metrics.db"))
+
+	var delta int64 = 2
+	value := 1.0
+
+	repo.Update(ctx, repository.Metrics{ID: "old", MType: "gauge", Value: &value})
+	repo.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta})
+
+	if err := repo.Replace(ctx, []repository.Metrics{{ID: "poll", MType: "gauge", Value: &value}}); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := repo.GetList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != "poll" || list[0].MType != "gauge" {
+		t.Fatalf("unexpected metrics after replace %+v", list)
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	repo := openRepo(t, filepath.Join(t.TempDir(), "metrics.db"))
+
+	value := 1.0
+	for _, id := range []string{"cpu.3", "cpu.1", "mem", "cpu.2", "cpu_total"} {
+		repo.Update(ctx, repository.Metrics{ID: id, MType: "gauge", Value: &value})
+	}
+
+	q := repository.ListQuery{Glob: "cpu.*", Limit: 2}
+	page, err := repo.List(ctx, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Metrics) != 2 || page.Metrics[0].ID != "cpu.1" || page.NextCursor != "cpu.2" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+
+	q.Cursor = page.NextCursor
+	page, err = repo.List(ctx, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Metrics) != 1 || page.Metrics[0].ID != "cpu.3" || page.NextCursor != "" {
+		t.Fatalf("unexpected last page %+v", page)
+	}
+
+	ids := make([]string, 0)
+	err = repo.Iterate(ctx, repository.ListQuery{Prefix: "cpu", Desc: true, Limit: 3}, func(m repository.Metrics) error {
+		ids = append(ids, m.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 || ids[0] != "cpu_total" || ids[2] != "cpu.2" {
+		t.Fatalf("unexpected metrics %v", ids)
+	}
+
+	// GLOB в SQL шире path.Match, лишние метрики отбрасываются Matcher
+	repo.Update(ctx, repository.Metrics{ID: "cpu/4", MType: "gauge", Value: &value})
+	repo.Update(ctx, repository.Metrics{ID: "cpu*", MType: "gauge", Value: &value})
+	for glob, want := range map[string]int{"cpu.*": 3, "cpu*": 5, "cpu\\*": 1, "cpu[._]*": 4, "cpu?4": 0} {
+		page, err := repo.List(ctx, repository.ListQuery{Glob: glob})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Metrics) != want {
+			t.Errorf("glob %q: expected %d metrics, got %+v", glob, want, page.Metrics)
+		}
+	}
+
+	if _, err := repo.List(ctx, repository.ListQuery{Regex: "("}); !errors.Is(err, repository.ErrInvalidQuery) {
+		t.Errorf("expected invalid query error, got %v", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	repo := openRepo(t, filepath.Join(t.TempDir(), "metrics.db"))
+
+	// повторное применение миграций ничего не делает
+	if err := repo.Migrate(ctx, sqlitestorage.LatestVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Migrate(ctx, 0); err == nil {
+		t.Fatal("expected error of rollback")
+	}
+}
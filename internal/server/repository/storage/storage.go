@@ -2,36 +2,56 @@ package storage
 
 import (
 	"context"
-	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/benderr/metrics/internal/server/config"
-	"github.com/benderr/metrics/internal/server/dump"
 	"github.com/benderr/metrics/internal/server/repository"
 	"github.com/benderr/metrics/internal/server/repository/dbstorage"
 	"github.com/benderr/metrics/internal/server/repository/filestorage"
 	"github.com/benderr/metrics/internal/server/repository/inmemory"
+	"github.com/benderr/metrics/internal/server/repository/sqlitestorage"
 )
 
 // New is Factory Method for create storage, depends on config.
 //
-// If config.DatabaseDsn is defined then the sql database based repository is returned.
+// If config.DatabaseDsn is defined then the sql database based repository is returned:
+// SQLite for DSN with sqlite:// scheme (sqlite:///var/lib/metrics.db), otherwise Postgres.
 //
-// If config.FileStoragePath is defined then returned in-memory repository with backup/restore features
+// If config.FileStoragePath is defined then returned in-memory repository with backup/restore features,
+// config.FileWAL enables write-ahead log of changes between snapshots. With WAL failed restore is returned as error,
+// and the log is cleared if restore is disabled.
 //
-// Otherwise method returned clean in-memory repository
-func New(ctx context.Context, config *config.Config, logger repository.Logger) (repository.MetricRepository, error) {
-	var repo repository.MetricRepository
+// Otherwise method returned clean in-memory repository.
+//
+// Background jobs of storage are started by Start, Close must be called on shutdown to save data and release connections.
+func New(ctx context.Context, config *config.Config, logger repository.Logger) (repository.Storage, error) {
+	var repo repository.Storage
 	switch {
-	case config.DatabaseDsn != "":
-		db, dberr := sql.Open("pgx", config.DatabaseDsn)
-		if dberr != nil {
+	case strings.HasPrefix(config.DatabaseDsn, sqlitestorage.Scheme):
+		db, err := sqlitestorage.Open(config.DatabaseDsn)
+		if err != nil {
+			return nil, err
+		}
+
+		sqliteRepo := sqlitestorage.New(db, logger)
+		if err := sqliteRepo.Prepare(ctx); err != nil {
 			db.Close()
-			return nil, dberr
+			return nil, err
+		}
+		repo = sqliteRepo
+
+	case config.DatabaseDsn != "":
+		pool, err := dbstorage.NewPool(ctx, config.DatabaseDsn, poolConfig(config))
+		if err != nil {
+			return nil, err
 		}
 
-		dbRepo := dbstorage.NewWithRetry(db, logger)
+		dbRepo := dbstorage.NewWithRetry(pool, logger)
 		if err := dbRepo.Prepare(ctx); err != nil {
-			db.Close()
+			pool.Close()
 			return nil, err
 		}
 		repo = dbRepo
@@ -39,14 +59,32 @@ func New(ctx context.Context, config *config.Config, logger repository.Logger) (
 	case config.FileStoragePath != "":
 		sync := config.StoreInterval == 0
 		fs := filestorage.New(config.FileStoragePath, sync, logger)
-		dumper := dump.New(fs.Sync)
-		if !sync {
-			go dumper.Start(ctx, config.StoreInterval)
+		fs.KeepGenerations(config.SnapshotGenerations)
+		if err := fs.SetSnapshotFormat(config.SnapshotFormat); err != nil {
+			return nil, err
 		}
+		if config.FileWAL {
+			opts := filestorage.WALOptions{Fsync: config.WALFsync, MaxSize: config.WALMaxSize}
+			if err := fs.EnableWAL(opts); err != nil {
+				return nil, err
+			}
+		}
+		// снимок не должен сохраняться до восстановления, иначе журнал будет очищен до воспроизведения
 		if config.Restore {
-			fs.Restore(ctx)
+			if err := fs.Restore(ctx); err != nil {
+				// сжатие журнала после старта без снимка удалило бы единственную копию записанных изменений
+				if config.FileWAL {
+					return nil, fmt.Errorf("restore: %w", err)
+				}
+				logger.Errorln("restore error", err)
+			}
+		} else if config.FileWAL {
+			// записи прошлого запуска не должны воспроизводиться при следующем восстановлении
+			if err := fs.ResetWAL(); err != nil {
+				return nil, err
+			}
 		}
-
+		fs.SetStoreInterval(config.StoreInterval)
 		repo = fs
 
 	default:
@@ -54,3 +92,128 @@ func New(ctx context.Context, config *config.Config, logger repository.Logger) (
 	}
 	return repo, nil
 }
+
+// Open opens existing storage to read it, e.g. source of migration.
+//
+// Nothing is written to the storage: database migrations are not applied and SQLite is opened read-only.
+// File storage is restored from snapshot, error is returned if snapshot is missing or damaged.
+func Open(ctx context.Context, config *config.Config, logger repository.Logger) (repository.Storage, error) {
+	switch {
+	case strings.HasPrefix(config.DatabaseDsn, sqlitestorage.Scheme):
+		dsn := config.DatabaseDsn
+		if strings.Contains(dsn, "?") {
+			dsn += "&mode=ro"
+		} else {
+			dsn += "?mode=ro"
+		}
+		db, err := sqlitestorage.Open(dsn)
+		if err != nil {
+			return nil, err
+		}
+
+		repo := sqlitestorage.New(db, logger)
+		if err := repo.PingContext(ctx); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return repo, nil
+
+	case config.DatabaseDsn != "":
+		pool, err := dbstorage.NewPool(ctx, config.DatabaseDsn, poolConfig(config))
+		if err != nil {
+			return nil, err
+		}
+
+		repo := dbstorage.NewWithRetry(pool, logger)
+		if err := repo.PingContext(ctx); err != nil {
+			pool.Close()
+			return nil, err
+		}
+		return repo, nil
+
+	case config.FileStoragePath != "":
+		// без снимка Restore возвращает пустое хранилище, для источника это ошибка
+		if _, err := os.Stat(config.FileStoragePath); err != nil {
+			return nil, err
+		}
+
+		fs := filestorage.New(config.FileStoragePath, false, logger)
+		if err := fs.Restore(ctx); err != nil {
+			return nil, fmt.Errorf("restore %s: %w", config.FileStoragePath, err)
+		}
+		return fs, nil
+
+	default:
+		return nil, errors.New("storage requires database dsn or file path")
+	}
+}
+
+// Migrate applies database migrations up or down to config.MigrateTo version without starting storage.
+func Migrate(ctx context.Context, config *config.Config) error {
+	if config.DatabaseDsn == "" {
+		return errors.New("migrations require database dsn")
+	}
+
+	if strings.HasPrefix(config.DatabaseDsn, sqlitestorage.Scheme) {
+		db, err := sqlitestorage.Open(config.DatabaseDsn)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		return sqlitestorage.New(db, nil).Migrate(ctx, config.MigrateTo)
+	}
+
+	pool, err := dbstorage.NewPool(ctx, config.DatabaseDsn, poolConfig(config))
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	return dbstorage.New(pool, nil).Migrate(ctx, config.MigrateTo)
+}
+
+func poolConfig(config *config.Config) dbstorage.PoolConfig {
+	return dbstorage.PoolConfig{
+		MaxConns:          int32(config.DBMaxConns),
+		MinConns:          int32(config.DBMinConns),
+		MaxConnLifetime:   config.DBMaxConnLifetime,
+		MaxConnIdleTime:   config.DBMaxConnIdleTime,
+		HealthCheckPeriod: config.DBHealthCheckPeriod,
+		StatementCache:    config.DBStatementCache,
+	}
+}
+
+// Copy transfers all metrics from src to dst with batches of batchSize, count of copied metrics is returned.
+//
+// Metrics are written with repository.OpSet, so counters keep absolute values
+// and metrics existing in dst are overwritten. Batches are applied one by one, on error dst keeps already copied ones.
+func Copy(ctx context.Context, dst, src repository.MetricRepository, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("invalid batch size %d", batchSize)
+	}
+
+	count := 0
+	batch := make([]repository.Metrics, 0, batchSize)
+	flush := func() error {
+		if err := dst.BulkUpdate(ctx, batch); err != nil {
+			return err
+		}
+		count += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	err := src.Iterate(ctx, repository.ListQuery{}, func(m repository.Metrics) error {
+		m.Op = repository.OpSet
+		batch = append(batch, m)
+		if len(batch) < batchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	return count, err
+}
@@ -0,0 +1,160 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/benderr/metrics/internal/server/config"
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/internal/server/repository/inmemory"
+	"github.com/benderr/metrics/internal/server/repository/sqlitestorage"
+	"github.com/benderr/metrics/internal/server/repository/storage"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Errorln(args ...interface{}) {}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+
+	src := inmemory.NewFast()
+	for i := 0; i < 5; i++ {
+		delta := int64(i + 10)
+		src.Update(ctx, repository.Metrics{ID: "counter" + strconv.Itoa(i), MType: "counter", Delta: &delta})
+	}
+
+	dst := inmemory.New()
+	count, err := storage.Copy(ctx, dst, src, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Fatalf("expected 5 copied metrics, got %d", count)
+	}
+
+	m, _ := dst.Get(ctx, "counter3")
+	if m == nil || *m.Delta != 13 {
+		t.Fatalf("expected counter with absolute value 13, got %+v", m)
+	}
+
+	// повторное копирование не удваивает счетчики
+	if _, err := storage.Copy(ctx, dst, src, 2); err != nil {
+		t.Fatal(err)
+	}
+	m, _ = dst.Get(ctx, "counter3")
+	if *m.Delta != 13 {
+		t.Fatalf("expected counter with absolute value 13 after second copy, got %d", *m.Delta)
+	}
+}
+
+// TestListBackends runs the same queries against every storage, postgres is tested if DATABASE_DSN is set
+func TestListBackends(t *testing.T) {
+	ctx := context.Background()
+
+	backends := map[string]repository.MetricRepository{
+		"inmemory": inmemory.New(),
+		"fast":     inmemory.NewFast(),
+	}
+	dsns := map[string]string{"sqlite": sqlitestorage.Scheme + filepath.Join(t.TempDir(), "metrics.db")}
+	if dsn := os.Getenv("DATABASE_DSN"); dsn != "" {
+		dsns["postgres"] = dsn
+	}
+	for name, dsn := range dsns {
+		repo, err := storage.New(ctx, &config.Config{DatabaseDsn: dsn}, nopLogger{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { repo.Close(ctx) })
+		backends[name] = repo
+	}
+
+	value := 1.0
+	metrics := make([]repository.Metrics, 0)
+	for _, id := range []string{"cpu.1", "cpu.2", "cpu.10", "cpu/1", "cpu_total", "CPU.3", "mem", "ёж.1"} {
+		metrics = append(metrics, repository.Metrics{ID: id, MType: "gauge", Value: &value})
+	}
+
+	tests := []struct {
+		q    repository.ListQuery
+		want string // ID через пробел и курсор следующей страницы
+	}{
+		{repository.ListQuery{Prefix: "cpu"}, "cpu.1 cpu.10 cpu.2 cpu/1 cpu_total | "},
+		{repository.ListQuery{Prefix: "cpu", Desc: true, Limit: 2}, "cpu_total cpu/1 | cpu/1"},
+		{repository.ListQuery{Glob: "cpu.*"}, "cpu.1 cpu.10 cpu.2 | "},
+		{repository.ListQuery{Glob: "cpu?1"}, "cpu.1 | "},
+		{repository.ListQuery{Glob: "[^c]*"}, "CPU.3 mem ёж.1 | "},
+		// синтаксис RE2: \z, (?i) и \p{...} с именованной группой не поддерживаются Postgres
+		{repository.ListQuery{Regex: `^cpu\.\d\z`}, "cpu.1 cpu.2 | "},
+		{repository.ListQuery{Regex: `(?i)^cpu\.`, Limit: 2}, "CPU.3 cpu.1 | cpu.1"},
+		{repository.ListQuery{Regex: `(?P<name>\p{Cyrillic}+)`}, "ёж.1 | "},
+	}
+
+	for name, repo := range backends {
+		if err := repo.Replace(ctx, metrics); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		for _, tt := range tests {
+			page, err := repo.List(ctx, tt.q)
+			if err != nil {
+				t.Fatalf("%s %+v: %v", name, tt.q, err)
+			}
+			ids := make([]string, 0, len(page.Metrics))
+			for _, m := range page.Metrics {
+				ids = append(ids, m.ID)
+			}
+			if got := strings.Join(ids, " ") + " | " + page.NextCursor; got != tt.want {
+				t.Errorf("%s %+v: got %q, want %q", name, tt.q, got, tt.want)
+			}
+		}
+	}
+
+	for name, repo := range backends {
+		if _, err := repo.List(ctx, repository.ListQuery{Regex: "("}); !errors.Is(err, repository.ErrInvalidQuery) {
+			t.Errorf("%s: expected invalid query error, got %v", name, err)
+		}
+	}
+}
+
+func TestNewFileStorageWAL(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	cfg := &config.Config{FileStoragePath: path, FileWAL: true, WALFsync: "always", Restore: true}
+	delta := int64(1)
+
+	repo, err := storage.New(ctx, cfg, nopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Update(ctx, repository.Metrics{ID: "poll", MType: "counter", Delta: &delta}); err != nil {
+		t.Fatal(err)
+	}
+
+	// журнал прошлого запуска очищается, если восстановление отключено
+	noRestore := *cfg
+	noRestore.Restore = false
+	if _, err := storage.New(ctx, &noRestore, nopLogger{}); err != nil {
+		t.Fatal(err)
+	}
+	repo, err = storage.New(ctx, cfg, nopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := repo.Get(ctx, "poll"); m != nil {
+		t.Fatalf("stale wal record replayed: %+v", m)
+	}
+
+	// поврежденный снимок не позволяет открыть хранилище с журналом
+	if err := os.WriteFile(path, []byte(`{"id":"poll"`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.New(ctx, cfg, nopLogger{}); err == nil {
+		t.Fatal("expected restore error")
+	}
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+)
+
+const maxNameLength = 255
+
+var nameRegexp = regexp.MustCompile(`^[\p{L}\p{N}_.:\-]+$`)
+
+// Validate checks metric name, type and that value matching the type is specified and finite.
+//
+// Returned error wraps one of ErrInvalidName, ErrInvalidType, ErrMissingValue, ErrInvalidValue, ErrInvalidOp.
+func (m *Metrics) Validate() error {
+	if len(m.ID) == 0 || len(m.ID) > maxNameLength || !nameRegexp.MatchString(m.ID) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, m.ID)
+	}
+
+	if m.Op != "" && m.Op != OpAdd && m.Op != OpSet {
+		return fmt.Errorf("%w: %q", ErrInvalidOp, m.Op)
+	}
+
+	switch m.MType {
+	case "gauge":
+		if m.Value == nil {
+			return fmt.Errorf("%w: gauge requires value", ErrMissingValue)
+		}
+		if math.IsNaN(*m.Value) || math.IsInf(*m.Value, 0) {
+			return fmt.Errorf("%w: gauge value must be finite", ErrInvalidValue)
+		}
+	case "counter":
+		if m.Delta == nil {
+			return fmt.Errorf("%w: counter requires delta", ErrMissingValue)
+		}
+	case "histogram":
+		if m.Histogram == nil {
+			return fmt.Errorf("%w: histogram requires histogram", ErrMissingValue)
+		}
+		if err := m.Histogram.Validate(); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidValue, err)
+		}
+	case "summary":
+		if m.Summary == nil {
+			return fmt.Errorf("%w: summary requires summary", ErrMissingValue)
+		}
+		if err := m.Summary.Validate(); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidValue, err)
+		}
+	case "set":
+		if m.Set == nil {
+			return fmt.Errorf("%w: set requires set", ErrMissingValue)
+		}
+		if err := m.Set.Validate(); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidValue, err)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidType, m.MType)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+// Package stream рассылает изменения метрик подписчикам в реальном времени.
+package stream
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/benderr/metrics/internal/server/repository"
+)
+
+// DefaultBufferSize размер буфера подписчика по умолчанию.
+const DefaultBufferSize = 256
+
+// Filter selects metrics for subscription, empty filter matches all metrics.
+type Filter struct {
+	IDs      []string // точные имена метрик
+	Prefixes []string // префиксы имен метрик, например "cpu."
+}
+
+// Match reports whether metric with id passes filter.
+func (f Filter) Match(id string) bool {
+	if len(f.IDs) == 0 && len(f.Prefixes) == 0 {
+		return true
+	}
+	for _, v := range f.IDs {
+		if v == id {
+			return true
+		}
+	}
+	for _, p := range f.Prefixes {
+		if strings.HasPrefix(id, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription receives changed metrics matching filter.
+//
+// Channel C is closed when subscriber is too slow to read updates,
+// broker is closed or subscription is cancelled.
+type Subscription struct {
+	C <-chan repository.Metrics
+
+	ch     chan repository.Metrics
+	filter Filter
+	broker *Broker
+}
+
+// Close cancels subscription.
+func (s *Subscription) Close() {
+	s.broker.remove(s)
+}
+
+// Broker fans out metric changes to subscribers.
+//
+// Publish doesn't block: if subscriber buffer is full, subscription is closed,
+// so client reconnects and gets actual values instead of reading stale ones.
+type Broker struct {
+	mu         sync.Mutex
+	subs       map[*Subscription]struct{}
+	bufferSize int
+	closed     bool
+}
+
+// New returns broker, bufferSize is a count of updates buffered for every subscriber.
+func New(bufferSize int) *Broker {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	return &Broker{
+		subs:       make(map[*Subscription]struct{}),
+		bufferSize: bufferSize,
+	}
+}
+
+// Subscribe registers subscriber, nil is returned if broker is closed.
+func (b *Broker) Subscribe(filter Filter) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+
+	ch := make(chan repository.Metrics, b.bufferSize)
+	s := &Subscription{C: ch, ch: ch, filter: filter, broker: b}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// Publish sends metrics to matching subscribers, it's compatible with repository.ChangeHook.
+func (b *Broker) Publish(metrics []repository.Metrics) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subs {
+		for _, m := range metrics {
+			if !s.filter.Match(m.ID) {
+				continue
+			}
+			select {
+			case s.ch <- m:
+			default:
+				b.removeLocked(s)
+			}
+			if _, ok := b.subs[s]; !ok {
+				break
+			}
+		}
+	}
+}
+
+// Close closes all subscriptions, new subscriptions are rejected.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subs {
+		b.removeLocked(s)
+	}
+}
+
+func (b *Broker) remove(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removeLocked(s)
+}
+
+func (b *Broker) removeLocked(s *Subscription) {
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
@@ -0,0 +1,53 @@
+package stream_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/internal/server/stream"
+)
+
+func TestFilter(t *testing.T) {
+	assert.True(t, stream.Filter{}.Match("any"))
+
+	f := stream.Filter{IDs: []string{"Alloc"}, Prefixes: []string{"cpu."}}
+	assert.True(t, f.Match("Alloc"))
+	assert.True(t, f.Match("cpu.1"))
+	assert.False(t, f.Match("Allocs"))
+	assert.False(t, f.Match("mem.cpu"))
+}
+
+func TestBroker(t *testing.T) {
+	b := stream.New(2)
+
+	all := b.Subscribe(stream.Filter{})
+	cpu := b.Subscribe(stream.Filter{Prefixes: []string{"cpu."}})
+
+	b.Publish([]repository.Metrics{{ID: "cpu.1"}, {ID: "mem"}})
+
+	assert.Equal(t, "cpu.1", (<-all.C).ID)
+	assert.Equal(t, "mem", (<-all.C).ID)
+	assert.Equal(t, "cpu.1", (<-cpu.C).ID)
+
+	t.Run("Slow subscriber is closed", func(t *testing.T) {
+		b.Publish([]repository.Metrics{{ID: "cpu.2"}, {ID: "cpu.3"}, {ID: "cpu.4"}})
+
+		var received []string
+		for m := range cpu.C {
+			received = append(received, m.ID)
+		}
+		assert.Equal(t, []string{"cpu.2", "cpu.3"}, received)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		s := b.Subscribe(stream.Filter{})
+		b.Close()
+
+		_, ok := <-s.C
+		require.False(t, ok)
+		assert.Nil(t, b.Subscribe(stream.Filter{}))
+	})
+}
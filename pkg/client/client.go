@@ -0,0 +1,410 @@
+// Package client is a Go client of metrics server API.
+//
+// Usage:
+//
+//	c := client.New("http://localhost:8080").
+//		SetSecret(secret).
+//		SetRetries(3)
+//
+//	err := c.Updates(ctx, []client.Metric{
+//		client.Counter("requests", 1),
+//		client.Gauge("load", 0.75),
+//	})
+//
+// Server errors are returned as *problem.Problem, so the failed items of batch can be inspected with errors.As.
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+
+	"github.com/benderr/metrics/pkg/problem"
+	"github.com/benderr/metrics/pkg/sign"
+)
+
+const (
+	defaultRetryWait    = time.Second
+	defaultRetryMaxWait = 5 * time.Second
+
+	idempotencyKeyHeader = "Idempotency-Key"
+)
+
+// Client sends requests to metrics server.
+type Client struct {
+	http   *resty.Client
+	secret string
+	gzip   bool
+}
+
+// New returns client of server, e.g. http://localhost:8080.
+//
+// Requests are compressed with gzip and are not retried by default.
+func New(server string) *Client {
+	c := &Client{
+		http: resty.New().SetBaseURL(server),
+		gzip: true,
+	}
+
+	c.http.
+		SetRetryWaitTime(defaultRetryWait).
+		SetRetryMaxWaitTime(defaultRetryMaxWait).
+		AddRetryCondition(func(r *resty.Response, err error) bool {
+			return r != nil && canRepeat(r.Request) && failed(r, err)
+		})
+
+	return c
+}
+
+// SetSecret sets key to sign request body, signature is sent in HashSHA256 header.
+func (c *Client) SetSecret(secret string) *Client {
+	c.secret = secret
+	return c
+}
+
+// SetGzip enables or disables gzip compression of request body.
+func (c *Client) SetGzip(enabled bool) *Client {
+	c.gzip = enabled
+	return c
+}
+
+// SetRetries sets count of retries on network errors and 5xx responses.
+//
+// Only requests which are safe to repeat are retried: reading requests, batch updates and import,
+// batch updates and import in merge mode are sent with Idempotency-Key, so server applies them once.
+// Single metric updates (Update, UpdateByURL, Put) are not retried, counter could be added twice.
+func (c *Client) SetRetries(count int) *Client {
+	c.http.SetRetryCount(count)
+	return c
+}
+
+// SetRetryWait sets wait time between retries, it grows with backoff up to maxWait.
+func (c *Client) SetRetryWait(wait, maxWait time.Duration) *Client {
+	c.http.SetRetryWaitTime(wait).SetRetryMaxWaitTime(maxWait)
+	return c
+}
+
+// SetTimeout sets timeout of single request attempt.
+func (c *Client) SetTimeout(timeout time.Duration) *Client {
+	c.http.SetTimeout(timeout)
+	return c
+}
+
+// SetTransport replaces http transport, e.g. with fake one in tests.
+func (c *Client) SetTransport(transport http.RoundTripper) *Client {
+	c.http.SetTransport(transport)
+	return c
+}
+
+// SetRootCertificate adds PEM encoded root certificate for TLS connections.
+func (c *Client) SetRootCertificate(pem string) *Client {
+	c.http.SetRootCertificateFromString(pem)
+	return c
+}
+
+// Ping checks server storage connection.
+func (c *Client) Ping(ctx context.Context) error {
+	return checkResponse(c.http.R().SetContext(ctx).Get("/ping"))
+}
+
+// Update creates or updates metric, updated metric is returned.
+func (c *Client) Update(ctx context.Context, m Metric) (*Metric, error) {
+	var res Metric
+	if err := c.send(ctx, http.MethodPost, "/update", m, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// UpdateByURL updates metric with value passed in URL, e.g. UpdateByURL(ctx, "counter", "requests", "1").
+func (c *Client) UpdateByURL(ctx context.Context, mtype, id, value string) error {
+	return checkResponse(c.http.R().
+		SetContext(ctx).
+		SetPathParams(map[string]string{"type": mtype, "name": id, "value": value}).
+		Post("/update/{type}/{name}/{value}"))
+}
+
+// Updates updates batch of metrics, if some items are invalid nothing is updated.
+//
+// Batch is sent with new Idempotency-Key, the same key is sent on retries.
+func (c *Client) Updates(ctx context.Context, metrics []Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return err
+	}
+	return c.send(ctx, http.MethodPost, "/updates/", metrics, nil, withIdempotencyKey(key))
+}
+
+// Value returns metric by ID, for summary metric quantiles are calculated (by default 0.5, 0.9, 0.99).
+//
+// If metric is not found, *problem.Problem with not_found code is returned.
+func (c *Client) Value(ctx context.Context, id string, quantiles ...float64) (*Metric, error) {
+	req := struct {
+		ID        string    `json:"id"`
+		Quantiles []float64 `json:"quantiles,omitempty"`
+	}{ID: id, Quantiles: quantiles}
+
+	var res Metric
+	if err := c.send(ctx, http.MethodPost, "/value", req, &res, retrySafe); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ValueByURL returns metric value as text, e.g. ValueByURL(ctx, "gauge", "load").
+func (c *Client) ValueByURL(ctx context.Context, mtype, id string) (string, error) {
+	resp, err := c.http.R().
+		SetContext(ctx).
+		SetPathParams(map[string]string{"type": mtype, "name": id}).
+		Get("/value/{type}/{name}")
+	if err := checkResponse(resp, err); err != nil {
+		return "", err
+	}
+	return resp.String(), nil
+}
+
+// List returns all metrics, pages are requested until the last one.
+func (c *Client) List(ctx context.Context) ([]Metric, error) {
+	res := make([]Metric, 0)
+	q := Query{}
+	for {
+		page, err := c.Query(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, page.Metrics...)
+		if page.NextCursor == "" {
+			return res, nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+// Query returns page of metrics matching query.
+func (c *Client) Query(ctx context.Context, q Query) (*Page, error) {
+	resp, err := c.http.R().
+		SetContext(ctx).
+		SetQueryParamsFromValues(q.values()).
+		Get("/api/v1/metrics")
+	if err := checkResponse(resp, err); err != nil {
+		return nil, err
+	}
+
+	page := &Page{Metrics: make([]Metric, 0)}
+	if err := json.Unmarshal(resp.Body(), &page.Metrics); err != nil {
+		return nil, err
+	}
+
+	page.NextCursor, err = nextCursor(resp.Header().Get("Link"))
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Get returns metric by ID with API v1, quantiles are used for summary metric only.
+func (c *Client) Get(ctx context.Context, id string, quantiles ...float64) (*Metric, error) {
+	q := url.Values{}
+	for _, v := range quantiles {
+		q.Add("q", strconv.FormatFloat(v, 'f', -1, 64))
+	}
+
+	resp, err := c.http.R().
+		SetContext(ctx).
+		SetPathParam("id", id).
+		SetQueryParamsFromValues(q).
+		Get("/api/v1/metrics/{id}")
+	if err := checkResponse(resp, err); err != nil {
+		return nil, err
+	}
+
+	var res Metric
+	if err := json.Unmarshal(resp.Body(), &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Put creates or updates metric with API v1, updated metric is returned.
+func (c *Client) Put(ctx context.Context, m Metric) (*Metric, error) {
+	var res Metric
+	if err := c.send(ctx, http.MethodPut, "/api/v1/metrics/"+url.PathEscape(m.ID), m, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Batch updates batch of metrics with API v1, if some items are invalid nothing is updated.
+//
+// Batch is sent with new Idempotency-Key, the same key is sent on retries.
+func (c *Client) Batch(ctx context.Context, metrics []Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return err
+	}
+	return c.send(ctx, http.MethodPost, "/api/v1/metrics:batch", metrics, nil, withIdempotencyKey(key))
+}
+
+// MaxImportSize is limit of import data, server rejects larger body.
+const MaxImportSize = 64 << 20
+
+// Import loads metrics from file storage snapshot or ndjson of /export, mode is "merge" or "replace".
+//
+// Data isn't streamed: it's read completely before sending, because the whole body is signed,
+// and server applies it as one batch. Data larger than MaxImportSize is rejected without sending.
+// In merge mode data is sent with new Idempotency-Key, so retried import isn't added twice.
+// Count of imported metrics is returned.
+func (c *Client) Import(ctx context.Context, r io.Reader, mode string) (int, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxImportSize+1))
+	if err != nil {
+		return 0, err
+	}
+	if len(data) > MaxImportSize {
+		return 0, fmt.Errorf("import data is larger than %d bytes", MaxImportSize)
+	}
+
+	// replace не зависит от хранимых данных, повтор безопасен
+	option := retrySafe
+	if mode != "replace" {
+		key, err := newIdempotencyKey()
+		if err != nil {
+			return 0, err
+		}
+		option = withIdempotencyKey(key)
+	}
+
+	var res struct {
+		Imported int `json:"imported"`
+	}
+	path := "/import?mode=" + url.QueryEscape(mode)
+	if err := c.sendRaw(ctx, http.MethodPost, path, "application/x-ndjson", data, &res, option); err != nil {
+		return 0, err
+	}
+	return res.Imported, nil
+}
+
+// requestOption configures request before sending
+type requestOption func(req *resty.Request)
+
+// withIdempotencyKey sends key in Idempotency-Key header, request with key is retried
+func withIdempotencyKey(key string) requestOption {
+	return func(req *resty.Request) {
+		req.SetHeader(idempotencyKeyHeader, key)
+	}
+}
+
+// retrySafe marks request which doesn't change data or gives the same result when repeated
+func retrySafe(req *resty.Request) {
+	req.AddRetryCondition(failed)
+}
+
+// canRepeat reports if request is retried without options: it reads data or has Idempotency-Key
+func canRepeat(req *resty.Request) bool {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return true
+	}
+	return req.Header.Get(idempotencyKeyHeader) != ""
+}
+
+// failed reports if attempt failed with network error or 5xx response
+func failed(r *resty.Response, err error) bool {
+	return err != nil || r == nil || r.StatusCode() >= http.StatusInternalServerError
+}
+
+// newIdempotencyKey returns random key of request
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// send marshals body, compresses and signs it, response is decoded into res if it is not nil.
+func (c *Client) send(ctx context.Context, method, path string, body any, res any, opts ...requestOption) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return c.sendRaw(ctx, method, path, "application/json", data, res, opts...)
+}
+
+// sendRaw compresses and signs data, response is decoded into res if it is not nil.
+func (c *Client) sendRaw(ctx context.Context, method, path, contentType string, data []byte, res any, opts ...requestOption) error {
+	var err error
+	req := c.http.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", contentType)
+
+	for _, opt := range opts {
+		opt(req)
+	}
+
+	if c.gzip {
+		if data, err = compress(data); err != nil {
+			return err
+		}
+		req.SetHeader("Content-Encoding", "gzip")
+	}
+
+	if c.secret != "" {
+		req.SetHeader("HashSHA256", sign.New(c.secret, data))
+	}
+
+	resp, err := req.SetBody(data).Execute(method, path)
+	if err := checkResponse(resp, err); err != nil {
+		return err
+	}
+
+	if res == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Body(), res)
+}
+
+// checkResponse returns error of failed request,
+// if server replied with application/problem+json the error is *problem.Problem
+func checkResponse(resp *resty.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	if !resp.IsError() {
+		return nil
+	}
+	if p, ok := problem.Parse(resp.Header().Get("Content-Type"), resp.Body()); ok {
+		return p
+	}
+	return fmt.Errorf("unexpected status %d: %s", resp.StatusCode(), resp.String())
+}
+
+func compress(s []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	zipped := gzip.NewWriter(&buf)
+	if _, err := zipped.Write(s); err != nil {
+		return nil, err
+	}
+
+	if err := zipped.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,267 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/benderr/metrics/internal/server/handlers"
+	"github.com/benderr/metrics/internal/server/middleware/sign"
+	"github.com/benderr/metrics/internal/server/repository/inmemory"
+	"github.com/benderr/metrics/pkg/client"
+	"github.com/benderr/metrics/pkg/ddsketch"
+	"github.com/benderr/metrics/pkg/gziper"
+	"github.com/benderr/metrics/pkg/problem"
+)
+
+func newServer(t *testing.T, secret string) *httptest.Server {
+	t.Helper()
+
+	log := zap.NewNop().Sugar()
+	h := handlers.New(inmemory.NewFast(), log, secret)
+	g := gziper.New(1, "application/json", "text/html")
+
+	r := chi.NewRouter()
+	r.Use(sign.New(secret, log).CheckSign)
+	r.Use(g.TransformWriter)
+	r.Use(g.TransformReader)
+	h.AddHandlers(r)
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClient(t *testing.T) {
+	server := newServer(t, "secret")
+	c := client.New(server.URL).SetSecret("secret")
+	ctx := context.Background()
+
+	t.Run("Ping", func(t *testing.T) {
+		assert.NoError(t, c.Ping(ctx))
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		m, err := c.Update(ctx, client.Counter("requests", 2))
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), *m.Delta)
+
+		require.NoError(t, c.UpdateByURL(ctx, "counter", "requests", "3"))
+
+		v, err := c.ValueByURL(ctx, "counter", "requests")
+		require.NoError(t, err)
+		assert.Equal(t, "5", v)
+	})
+
+	t.Run("Updates", func(t *testing.T) {
+		sketch, err := ddsketch.New(0.01)
+		require.NoError(t, err)
+		for i := 1; i <= 100; i++ {
+			sketch.Add(float64(i))
+		}
+
+		require.NoError(t, c.Updates(ctx, []client.Metric{
+			client.Gauge("load", 0.5),
+			client.Summary("latency", sketch),
+		}))
+
+		m, err := c.Value(ctx, "latency", 0.5)
+		require.NoError(t, err)
+		assert.InDelta(t, 50, m.Quantiles["p50"], 1)
+	})
+
+	t.Run("API v1", func(t *testing.T) {
+		_, err := c.Put(ctx, client.Gauge("temp", 36.6))
+		require.NoError(t, err)
+		require.NoError(t, c.Batch(ctx, []client.Metric{client.Counter("requests", 1)}))
+
+		m, err := c.Get(ctx, "requests")
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), *m.Delta)
+
+		m, err = c.Update(ctx, client.Counter("requests", 1).WithOp(client.OpSet))
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), *m.Delta)
+
+		list, err := c.List(ctx)
+		require.NoError(t, err)
+		assert.Len(t, list, 4)
+	})
+
+	t.Run("Query", func(t *testing.T) {
+		page, err := c.Query(ctx, client.Query{Types: []string{"gauge"}, Limit: 1})
+		require.NoError(t, err)
+		require.Len(t, page.Metrics, 1)
+		assert.Equal(t, "load", page.Metrics[0].ID)
+		assert.Equal(t, "load", page.NextCursor)
+
+		page, err = c.Query(ctx, client.Query{Types: []string{"gauge"}, Limit: 1, Cursor: page.NextCursor})
+		require.NoError(t, err)
+		require.Len(t, page.Metrics, 1)
+		assert.Equal(t, "temp", page.Metrics[0].ID)
+		assert.Empty(t, page.NextCursor)
+
+		_, err = c.Query(ctx, client.Query{Regex: "("})
+		var p *problem.Problem
+		require.ErrorAs(t, err, &p)
+		assert.Equal(t, problem.CodeInvalidQuery, p.Code)
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		count, err := c.Import(ctx, strings.NewReader(`{"id":"imported","type":"counter","delta":3}`+"\n"), "merge")
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		m, err := c.Get(ctx, "imported")
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), *m.Delta)
+		_, err = c.Import(ctx, bytes.NewReader(make([]byte, client.MaxImportSize+1)), "merge")
+		assert.ErrorContains(t, err, "larger than")
+	})
+
+	t.Run("Problem errors", func(t *testing.T) {
+		err := c.Updates(ctx, []client.Metric{
+			client.Gauge("ok", 1),
+			{ID: "broken", MType: "counter"},
+		})
+
+		var p *problem.Problem
+		require.ErrorAs(t, err, &p)
+		assert.Equal(t, http.StatusBadRequest, p.Status)
+		require.Len(t, p.Errors, 1)
+		assert.Equal(t, 1, p.Errors[0].Index)
+		assert.Equal(t, problem.CodeMissingValue, p.Errors[0].Code)
+
+		_, err = c.Value(ctx, "unknown")
+		require.ErrorAs(t, err, &p)
+		assert.Equal(t, problem.CodeNotFound, p.Code)
+	})
+
+	t.Run("Invalid sign", func(t *testing.T) {
+		_, err := client.New(server.URL).SetSecret("other").Update(ctx, client.Gauge("load", 1))
+
+		var p *problem.Problem
+		require.ErrorAs(t, err, &p)
+		assert.Equal(t, problem.CodeInvalidSign, p.Code)
+	})
+
+	t.Run("Without gzip", func(t *testing.T) {
+		m, err := c.SetGzip(false).Update(ctx, client.Gauge("load", 1))
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, *m.Value)
+	})
+}
+
+// roundTripFunc позволяет подменить транспорт функцией
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestClientRetries(t *testing.T) {
+	server := newServer(t, "")
+
+	var attempts atomic.Int32
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if attempts.Add(1) < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return http.DefaultTransport.RoundTrip(r)
+	})
+
+	c := client.New(server.URL).
+		SetTransport(transport).
+		SetRetries(3).
+		SetRetryWait(time.Millisecond, 10*time.Millisecond)
+
+	require.NoError(t, c.Updates(context.Background(), []client.Metric{client.Counter("requests", 1)}))
+	assert.Equal(t, int32(3), attempts.Load())
+
+	m, err := c.Get(context.Background(), "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *m.Delta)
+}
+
+func TestClientRetriesIdempotency(t *testing.T) {
+	server := newServer(t, "")
+
+	// каждый нечетный ответ теряется после применения запроса сервером
+	var attempts atomic.Int32
+	var keys []string
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method == http.MethodPost {
+			keys = append(keys, r.Header.Get("Idempotency-Key"))
+		}
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil || attempts.Add(1)%2 == 0 {
+			return resp, err
+		}
+		resp.Body.Close()
+		return nil, errors.New("connection reset")
+	})
+
+	c := client.New(server.URL).
+		SetTransport(transport).
+		SetRetries(3).
+		SetRetryWait(time.Millisecond, 10*time.Millisecond)
+	ctx := context.Background()
+
+	t.Run("Batch is applied once", func(t *testing.T) {
+		require.NoError(t, c.Updates(ctx, []client.Metric{client.Counter("requests", 1)}))
+		require.NoError(t, c.Batch(ctx, []client.Metric{client.Counter("requests", 1)}))
+
+		require.Len(t, keys, 4)
+		assert.NotEmpty(t, keys[0])
+		assert.Equal(t, keys[0], keys[1], "the same key is sent on retry")
+		assert.Equal(t, keys[2], keys[3])
+		assert.NotEqual(t, keys[0], keys[2], "key is generated per batch")
+
+		m, err := c.Get(ctx, "requests")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), *m.Delta)
+	})
+
+	t.Run("Single update isn't retried", func(t *testing.T) {
+		attempts.Store(0)
+		_, err := c.Update(ctx, client.Counter("requests", 1))
+		require.Error(t, err)
+		assert.Equal(t, int32(1), attempts.Load())
+
+		m, err := c.Get(ctx, "requests")
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), *m.Delta, "update is applied, but its response is lost")
+	})
+}
+
+func TestClientContext(t *testing.T) {
+	server := newServer(t, "")
+
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	c := client.New(server.URL).
+		SetTransport(transport).
+		SetRetries(10).
+		SetRetryWait(time.Second, time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := c.Ping(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
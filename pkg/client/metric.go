@@ -0,0 +1,58 @@
+package client
+
+import (
+	"github.com/benderr/metrics/pkg/ddsketch"
+	"github.com/benderr/metrics/pkg/histogram"
+	"github.com/benderr/metrics/pkg/hll"
+)
+
+// Metric model of metric in server API.
+type Metric struct {
+	ID        string               `json:"id"`                  // имя метрики
+	MType     string               `json:"type"`                // gauge, counter, histogram, summary или set
+	Delta     *int64               `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Value     *float64             `json:"value,omitempty"`     // значение метрики в случае передачи gauge
+	Histogram *histogram.Histogram `json:"histogram,omitempty"` // бакеты метрики в случае передачи histogram
+	Summary   *ddsketch.Sketch     `json:"summary,omitempty"`   // скетч метрики в случае передачи summary
+	Set       *hll.Sketch          `json:"set,omitempty"`       // скетч метрики в случае передачи set
+	Quantiles map[string]float64   `json:"quantiles,omitempty"` // квантили summary, заполняются сервером
+	Op        string               `json:"op,omitempty"`        // операция обновления: OpAdd (по умолчанию) или OpSet
+}
+
+// Operations of metric update.
+const (
+	OpAdd = "add" // counter delta is added, sketches are merged with stored ones
+	OpSet = "set" // stored value is overwritten
+)
+
+// WithOp returns copy of metric with update operation, e.g. client.Counter("requests", 10).WithOp(client.OpSet)
+// sets counter to 10 instead of adding.
+func (m Metric) WithOp(op string) Metric {
+	m.Op = op
+	return m
+}
+
+// Counter returns counter metric, delta is added to stored value.
+func Counter(id string, delta int64) Metric {
+	return Metric{ID: id, MType: "counter", Delta: &delta}
+}
+
+// Gauge returns gauge metric, value overwrites stored value.
+func Gauge(id string, value float64) Metric {
+	return Metric{ID: id, MType: "gauge", Value: &value}
+}
+
+// Histogram returns histogram metric, buckets are merged with stored histogram.
+func Histogram(id string, h *histogram.Histogram) Metric {
+	return Metric{ID: id, MType: "histogram", Histogram: h}
+}
+
+// Summary returns summary metric, sketch is merged with stored sketch.
+func Summary(id string, s *ddsketch.Sketch) Metric {
+	return Metric{ID: id, MType: "summary", Summary: s}
+}
+
+// Set returns set metric, sketch is merged with stored sketch.
+func Set(id string, s *hll.Sketch) Metric {
+	return Metric{ID: id, MType: "set", Set: s}
+}
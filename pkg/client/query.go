@@ -0,0 +1,67 @@
+package client
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Query filters and paginates metric list, filters are combined with AND.
+type Query struct {
+	Prefix string   // префикс ID
+	Glob   string   // шаблон ID, например cpu.*
+	Regex  string   // регулярное выражение для ID
+	Types  []string // типы метрик
+	Desc   bool     // сортировка по убыванию ID
+	Limit  int      // размер страницы, 0 - значение сервера по умолчанию
+	Cursor string   // курсор следующей страницы из Page.NextCursor
+}
+
+// Page of metrics returned by Query.
+type Page struct {
+	Metrics    []Metric
+	NextCursor string // пусто, если страница последняя
+}
+
+func (q Query) values() url.Values {
+	v := url.Values{}
+	if q.Prefix != "" {
+		v.Set("prefix", q.Prefix)
+	}
+	if q.Glob != "" {
+		v.Set("glob", q.Glob)
+	}
+	if q.Regex != "" {
+		v.Set("regex", q.Regex)
+	}
+	for _, t := range q.Types {
+		v.Add("type", t)
+	}
+	if q.Desc {
+		v.Set("order", "desc")
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Cursor != "" {
+		v.Set("cursor", q.Cursor)
+	}
+	return v
+}
+
+// nextCursor returns cursor from Link header with rel="next", e.g. </api/v1/metrics?cursor=cpu.9>; rel="next"
+func nextCursor(link string) (string, error) {
+	for _, part := range strings.Split(link, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(part), ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+
+		u, err := url.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return "", err
+		}
+		return u.Query().Get("cursor"), nil
+	}
+	return "", nil
+}
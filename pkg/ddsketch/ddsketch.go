@@ -0,0 +1,328 @@
+// Package ddsketch contains a mergeable quantile sketch with relative-error guarantees (DDSketch).
+//
+// Values are mapped to logarithmic buckets, so a quantile returned by the sketch
+// differs from the exact one by at most RelativeAccuracy (relative to the value).
+// Sketches with equal accuracy can be merged without loss, which allows agents
+// to ship compressed distribution state and the server to combine it.
+package ddsketch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// DefaultRelativeAccuracy is used by New when accuracy is not specified.
+const DefaultRelativeAccuracy = 0.01
+
+// minIndexableValue values with smaller magnitude are counted in the zero bucket.
+const minIndexableValue = 1e-9
+
+const binaryVersion byte = 1
+
+var (
+	ErrInvalidAccuracy  = errors.New("sketch relative accuracy must be between 0 and 1")
+	ErrInvalidSketch    = errors.New("sketch counts are inconsistent")
+	ErrAccuracyMismatch = errors.New("sketch relative accuracy mismatch")
+	ErrInvalidQuantile  = errors.New("quantile must be between 0 and 1")
+	ErrUnknownVersion   = errors.New("unknown sketch binary version")
+)
+
+// Sketch is a DDSketch with unbounded bucket stores.
+//
+// Fields are exported to be serialised as JSON, use New to create a sketch.
+type Sketch struct {
+	RelativeAccuracy float64          `json:"alpha"`              // relative accuracy of quantiles
+	Positive         map[int32]uint64 `json:"positive,omitempty"` // bucket index => count for positive values
+	Negative         map[int32]uint64 `json:"negative,omitempty"` // bucket index => count for negative values (by magnitude)
+	Zero             uint64           `json:"zero"`               // count of values close to zero
+	Count            uint64           `json:"count"`              // number of values
+	Sum              float64          `json:"sum"`                // sum of values
+	Min              float64          `json:"min"`                // minimal value
+	Max              float64          `json:"max"`                // maximal value
+}
+
+// New returns an empty sketch, if relativeAccuracy is 0 DefaultRelativeAccuracy is used.
+func New(relativeAccuracy float64) (*Sketch, error) {
+	if relativeAccuracy == 0 {
+		relativeAccuracy = DefaultRelativeAccuracy
+	}
+	if relativeAccuracy <= 0 || relativeAccuracy >= 1 {
+		return nil, ErrInvalidAccuracy
+	}
+	return &Sketch{
+		RelativeAccuracy: relativeAccuracy,
+		Positive:         make(map[int32]uint64),
+		Negative:         make(map[int32]uint64),
+	}, nil
+}
+
+func (s *Sketch) gamma() float64 {
+	return (1 + s.RelativeAccuracy) / (1 - s.RelativeAccuracy)
+}
+
+func (s *Sketch) index(v float64) int32 {
+	return int32(math.Ceil(math.Log(v) / math.Log(s.gamma())))
+}
+
+func (s *Sketch) value(index int32) float64 {
+	g := s.gamma()
+	return 2 * math.Pow(g, float64(index)) / (g + 1)
+}
+
+// Add inserts value to the sketch, NaN and infinite values are ignored.
+func (s *Sketch) Add(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+
+	switch {
+	case v > minIndexableValue:
+		if s.Positive == nil {
+			s.Positive = make(map[int32]uint64)
+		}
+		s.Positive[s.index(v)]++
+	case v < -minIndexableValue:
+		if s.Negative == nil {
+			s.Negative = make(map[int32]uint64)
+		}
+		s.Negative[s.index(-v)]++
+	default:
+		s.Zero++
+	}
+
+	if s.Count == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Count == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Count++
+	s.Sum += v
+}
+
+// Quantile returns approximated value at quantile q (0 <= q <= 1).
+//
+// For an empty sketch 0 is returned.
+func (s *Sketch) Quantile(q float64) (float64, error) {
+	if q < 0 || q > 1 || math.IsNaN(q) {
+		return 0, ErrInvalidQuantile
+	}
+	if s.Count == 0 {
+		return 0, nil
+	}
+
+	rank := uint64(q * float64(s.Count-1))
+	var seen uint64
+
+	// negative values ordered from the largest magnitude
+	negKeys := sortedKeys(s.Negative)
+	for i := len(negKeys) - 1; i >= 0; i-- {
+		seen += s.Negative[negKeys[i]]
+		if seen > rank {
+			return s.clamp(-s.value(negKeys[i])), nil
+		}
+	}
+
+	seen += s.Zero
+	if seen > rank {
+		return s.clamp(0), nil
+	}
+
+	for _, k := range sortedKeys(s.Positive) {
+		seen += s.Positive[k]
+		if seen > rank {
+			return s.clamp(s.value(k)), nil
+		}
+	}
+
+	return s.Max, nil
+}
+
+func (s *Sketch) clamp(v float64) float64 {
+	if v < s.Min {
+		return s.Min
+	}
+	if v > s.Max {
+		return s.Max
+	}
+	return v
+}
+
+// Merge adds all values of other sketch into s.
+//
+// Sketches must have equal relative accuracy, otherwise ErrAccuracyMismatch returned.
+func (s *Sketch) Merge(other *Sketch) error {
+	if other == nil {
+		return nil
+	}
+	// параметры проверяются и для пустого скетча, иначе результат зависел бы от наличия значений
+	if s.RelativeAccuracy != other.RelativeAccuracy {
+		return ErrAccuracyMismatch
+	}
+	if other.Count == 0 {
+		return nil
+	}
+
+	if s.Positive == nil {
+		s.Positive = make(map[int32]uint64)
+	}
+	if s.Negative == nil {
+		s.Negative = make(map[int32]uint64)
+	}
+	for k, c := range other.Positive {
+		s.Positive[k] += c
+	}
+	for k, c := range other.Negative {
+		s.Negative[k] += c
+	}
+
+	if s.Count == 0 || other.Min < s.Min {
+		s.Min = other.Min
+	}
+	if s.Count == 0 || other.Max > s.Max {
+		s.Max = other.Max
+	}
+	s.Zero += other.Zero
+	s.Count += other.Count
+	s.Sum += other.Sum
+
+	return nil
+}
+
+// Clone returns a deep copy of sketch.
+func (s *Sketch) Clone() *Sketch {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	c.Positive = make(map[int32]uint64, len(s.Positive))
+	c.Negative = make(map[int32]uint64, len(s.Negative))
+	for k, v := range s.Positive {
+		c.Positive[k] = v
+	}
+	for k, v := range s.Negative {
+		c.Negative[k] = v
+	}
+	return &c
+}
+
+// Validate checks accuracy and that bucket counts match total count.
+func (s *Sketch) Validate() error {
+	if s.RelativeAccuracy <= 0 || s.RelativeAccuracy >= 1 {
+		return ErrInvalidAccuracy
+	}
+
+	total := s.Zero
+	for _, c := range s.Positive {
+		total += c
+	}
+	for _, c := range s.Negative {
+		total += c
+	}
+	if total != s.Count {
+		return ErrInvalidSketch
+	}
+
+	for _, v := range []float64{s.Sum, s.Min, s.Max} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return ErrInvalidSketch
+		}
+	}
+	return nil
+}
+
+// MarshalBinary encodes sketch in a compact binary form:
+// version, accuracy, count, sum, min, max, zero and both stores as varint pairs.
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(binaryVersion)
+
+	for _, f := range []float64{s.RelativeAccuracy, s.Sum, s.Min, s.Max} {
+		binary.Write(&buf, binary.LittleEndian, f)
+	}
+
+	tmp := make([]byte, binary.MaxVarintLen64)
+	writeUvarint := func(v uint64) {
+		n := binary.PutUvarint(tmp, v)
+		buf.Write(tmp[:n])
+	}
+	writeVarint := func(v int64) {
+		n := binary.PutVarint(tmp, v)
+		buf.Write(tmp[:n])
+	}
+
+	writeUvarint(s.Count)
+	writeUvarint(s.Zero)
+
+	for _, store := range []map[int32]uint64{s.Positive, s.Negative} {
+		keys := sortedKeys(store)
+		writeUvarint(uint64(len(keys)))
+		for _, k := range keys {
+			writeVarint(int64(k))
+			writeUvarint(store[k])
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes sketch written by MarshalBinary.
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+
+	version, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if version != binaryVersion {
+		return ErrUnknownVersion
+	}
+
+	for _, f := range []*float64{&s.RelativeAccuracy, &s.Sum, &s.Min, &s.Max} {
+		if err = binary.Read(r, binary.LittleEndian, f); err != nil {
+			return err
+		}
+	}
+
+	if s.Count, err = binary.ReadUvarint(r); err != nil {
+		return err
+	}
+	if s.Zero, err = binary.ReadUvarint(r); err != nil {
+		return err
+	}
+
+	s.Positive = make(map[int32]uint64)
+	s.Negative = make(map[int32]uint64)
+
+	for _, store := range []map[int32]uint64{s.Positive, s.Negative} {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			k, err := binary.ReadVarint(r)
+			if err != nil {
+				return err
+			}
+			c, err := binary.ReadUvarint(r)
+			if err != nil {
+				return err
+			}
+			store[int32(k)] = c
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys(store map[int32]uint64) []int32 {
+	keys := make([]int32, 0, len(store))
+	for k := range store {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
@@ -0,0 +1,126 @@
+package ddsketch_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/pkg/ddsketch"
+)
+
+func ExampleSketch_Quantile() {
+	s, _ := ddsketch.New(0.01)
+	for i := 1; i <= 100; i++ {
+		s.Add(float64(i))
+	}
+
+	p99, _ := s.Quantile(0.99)
+	fmt.Printf("%.0f\n", p99)
+	// Output: 99
+}
+
+func TestQuantile(t *testing.T) {
+	s, err := ddsketch.New(0.01)
+	require.NoError(t, err)
+
+	for i := 1; i <= 1000; i++ {
+		s.Add(float64(i))
+	}
+
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		v, err := s.Quantile(q)
+		require.NoError(t, err)
+
+		exact := q * 999
+		assert.InDelta(t, exact+1, v, (exact+1)*0.02, "quantile %v", q)
+	}
+
+	_, err = s.Quantile(1.5)
+	assert.ErrorIs(t, err, ddsketch.ErrInvalidQuantile)
+}
+
+func TestQuantileNegativeAndZero(t *testing.T) {
+	s, _ := ddsketch.New(0)
+	s.Add(-10)
+	s.Add(0)
+	s.Add(10)
+
+	min, _ := s.Quantile(0)
+	med, _ := s.Quantile(0.5)
+	max, _ := s.Quantile(1)
+
+	assert.Equal(t, -10.0, min)
+	assert.Equal(t, 0.0, med)
+	assert.Equal(t, 10.0, max)
+}
+
+func TestMerge(t *testing.T) {
+	t.Run("should merge sketches", func(t *testing.T) {
+		s1, _ := ddsketch.New(0.01)
+		s2, _ := ddsketch.New(0.01)
+		for i := 1; i <= 50; i++ {
+			s1.Add(float64(i))
+			s2.Add(float64(i + 50))
+		}
+
+		require.NoError(t, s1.Merge(s2))
+		assert.Equal(t, uint64(100), s1.Count)
+		assert.Equal(t, 1.0, s1.Min)
+		assert.Equal(t, 100.0, s1.Max)
+
+		p50, _ := s1.Quantile(0.5)
+		assert.InDelta(t, 50, p50, 1)
+	})
+
+	t.Run("should fail on accuracy mismatch", func(t *testing.T) {
+		s1, _ := ddsketch.New(0.01)
+		s2, _ := ddsketch.New(0.02)
+		s2.Add(1)
+
+		assert.ErrorIs(t, s1.Merge(s2), ddsketch.ErrAccuracyMismatch)
+	})
+
+	t.Run("should fail on accuracy mismatch of empty sketch", func(t *testing.T) {
+		s1, _ := ddsketch.New(0.01)
+		s2, _ := ddsketch.New(0.02)
+
+		assert.ErrorIs(t, s1.Merge(s2), ddsketch.ErrAccuracyMismatch)
+	})
+}
+
+func TestSerialization(t *testing.T) {
+	s, _ := ddsketch.New(0.01)
+	for _, v := range []float64{-3, 0, 0.5, 12, 1500} {
+		s.Add(v)
+	}
+
+	t.Run("binary", func(t *testing.T) {
+		data, err := s.MarshalBinary()
+		require.NoError(t, err)
+
+		var restored ddsketch.Sketch
+		require.NoError(t, restored.UnmarshalBinary(data))
+		assert.Equal(t, s, &restored)
+		assert.NoError(t, restored.Validate())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		data, err := json.Marshal(s)
+		require.NoError(t, err)
+
+		var restored ddsketch.Sketch
+		require.NoError(t, json.Unmarshal(data, &restored))
+		assert.Equal(t, s, &restored)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	s := ddsketch.Sketch{RelativeAccuracy: 0.01, Count: 2, Zero: 1}
+	assert.ErrorIs(t, s.Validate(), ddsketch.ErrInvalidSketch)
+
+	s = ddsketch.Sketch{RelativeAccuracy: 2}
+	assert.ErrorIs(t, s.Validate(), ddsketch.ErrInvalidAccuracy)
+}
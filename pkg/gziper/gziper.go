@@ -143,6 +143,19 @@ func (cw *compressWriter) Write(p []byte) (int, error) {
 	return cw.writer().Write(p)
 }
 
+// Flush отправляет клиенту буферизованные данные, нужен для потоковых ответов (http.Flusher)
+func (cw *compressWriter) Flush() {
+	if zw, ok := cw.writer().(*gzip.Writer); ok {
+		zw.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap returns original writer for http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
 func (cw *compressWriter) Close() error {
 	if c, ok := cw.writer().(io.WriteCloser); ok {
 		return c.Close()
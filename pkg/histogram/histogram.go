@@ -0,0 +1,130 @@
+// Package histogram contains a bucketed histogram that can be merged between producers
+package histogram
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+var (
+	ErrInvalidBounds  = errors.New("histogram bounds must be finite and sorted ascending")
+	ErrInvalidCounts  = errors.New("histogram counts do not match bounds")
+	ErrBoundsMismatch = errors.New("histogram bounds mismatch")
+)
+
+// DefaultBounds are bucket upper bounds (seconds) suitable for request latencies.
+var DefaultBounds = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram counts observations in buckets.
+//
+// Counts[i] holds observations v <= Bounds[i] (and greater than the previous bound),
+// the last element of Counts is the implicit +Inf bucket, so len(Counts) == len(Bounds)+1.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"` // upper bounds of buckets, sorted ascending
+	Counts []uint64  `json:"counts"` // observations per bucket, last one is +Inf
+	Sum    float64   `json:"sum"`    // sum of all observations
+	Count  uint64    `json:"count"`  // number of observations
+}
+
+// New returns an empty histogram with the given bucket bounds,
+// if no bounds are passed DefaultBounds are used.
+func New(bounds ...float64) *Histogram {
+	if len(bounds) == 0 {
+		bounds = DefaultBounds
+	}
+	b := make([]float64, len(bounds))
+	copy(b, bounds)
+
+	return &Histogram{
+		Bounds: b,
+		Counts: make([]uint64, len(b)+1),
+	}
+}
+
+// Observe adds value to the matching bucket.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.Bounds, v)
+	h.Counts[i]++
+	h.Sum += v
+	h.Count++
+}
+
+// Validate checks that bounds are sorted and counts are consistent.
+func (h *Histogram) Validate() error {
+	for i, b := range h.Bounds {
+		if math.IsNaN(b) || math.IsInf(b, 0) {
+			return ErrInvalidBounds
+		}
+		if i > 0 && b <= h.Bounds[i-1] {
+			return ErrInvalidBounds
+		}
+	}
+
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return ErrInvalidCounts
+	}
+
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total != h.Count {
+		return ErrInvalidCounts
+	}
+
+	if math.IsNaN(h.Sum) || math.IsInf(h.Sum, 0) {
+		return ErrInvalidCounts
+	}
+
+	return nil
+}
+
+// Merge adds observations of other histogram into h.
+//
+// Both histograms must have equal bounds, otherwise ErrBoundsMismatch returned.
+func (h *Histogram) Merge(other *Histogram) error {
+	if other == nil {
+		return nil
+	}
+	if len(h.Bounds) != len(other.Bounds) || len(h.Counts) != len(other.Counts) {
+		return ErrBoundsMismatch
+	}
+	for i := range h.Bounds {
+		if h.Bounds[i] != other.Bounds[i] {
+			return ErrBoundsMismatch
+		}
+	}
+
+	for i := range h.Counts {
+		h.Counts[i] += other.Counts[i]
+	}
+	h.Sum += other.Sum
+	h.Count += other.Count
+
+	return nil
+}
+
+// Clone returns a deep copy of histogram.
+func (h *Histogram) Clone() *Histogram {
+	if h == nil {
+		return nil
+	}
+	c := &Histogram{
+		Bounds: make([]float64, len(h.Bounds)),
+		Counts: make([]uint64, len(h.Counts)),
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+	copy(c.Bounds, h.Bounds)
+	copy(c.Counts, h.Counts)
+	return c
+}
+
+// Mean returns the average of observations.
+func (h *Histogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / float64(h.Count)
+}
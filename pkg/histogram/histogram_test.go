@@ -0,0 +1,102 @@
+package histogram_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/pkg/histogram"
+)
+
+func ExampleHistogram_Merge() {
+	h1 := histogram.New(0.1, 0.5, 1)
+	h1.Observe(0.05)
+
+	h2 := histogram.New(0.1, 0.5, 1)
+	h2.Observe(0.7)
+	h2.Observe(3)
+
+	if err := h1.Merge(h2); err != nil {
+		panic(err)
+	}
+
+	fmt.Println(h1.Counts, h1.Count)
+	// Output: [1 0 1 1] 3
+}
+
+func TestObserve(t *testing.T) {
+	h := histogram.New(1, 2, 3)
+
+	h.Observe(0.5)
+	h.Observe(1)
+	h.Observe(2.5)
+	h.Observe(10)
+
+	assert.Equal(t, []uint64{2, 0, 1, 1}, h.Counts)
+	assert.Equal(t, uint64(4), h.Count)
+	assert.Equal(t, 14.0, h.Sum)
+	assert.NoError(t, h.Validate())
+}
+
+func TestMerge(t *testing.T) {
+	t.Run("should merge equal bounds", func(t *testing.T) {
+		h1 := histogram.New(1, 2)
+		h1.Observe(1)
+		h2 := histogram.New(1, 2)
+		h2.Observe(5)
+
+		require.NoError(t, h1.Merge(h2))
+		assert.Equal(t, []uint64{1, 0, 1}, h1.Counts)
+		assert.Equal(t, 6.0, h1.Sum)
+	})
+
+	t.Run("should fail on different bounds", func(t *testing.T) {
+		h1 := histogram.New(1, 2)
+		h2 := histogram.New(1, 3)
+
+		assert.ErrorIs(t, h1.Merge(h2), histogram.ErrBoundsMismatch)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		h    histogram.Histogram
+		err  error
+	}{
+		{
+			name: "unsorted bounds",
+			h:    histogram.Histogram{Bounds: []float64{2, 1}, Counts: []uint64{0, 0, 0}},
+			err:  histogram.ErrInvalidBounds,
+		},
+		{
+			name: "counts length",
+			h:    histogram.Histogram{Bounds: []float64{1}, Counts: []uint64{0}},
+			err:  histogram.ErrInvalidCounts,
+		},
+		{
+			name: "count does not match buckets",
+			h:    histogram.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 1}, Count: 1},
+			err:  histogram.ErrInvalidCounts,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.ErrorIs(t, test.h.Validate(), test.err)
+		})
+	}
+}
+
+func TestClone(t *testing.T) {
+	h := histogram.New(1)
+	h.Observe(0.5)
+
+	c := h.Clone()
+	c.Observe(0.5)
+
+	assert.Equal(t, uint64(1), h.Count)
+	assert.Equal(t, uint64(2), c.Count)
+}
@@ -0,0 +1,183 @@
+// Package hll contains HyperLogLog sketch to estimate the number of distinct values.
+//
+// Sketches with equal precision can be merged, so distinct values
+// counted by several agents are combined without double counting.
+package hll
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	// DefaultPrecision gives 4096 registers and ~1.6% standard error.
+	DefaultPrecision uint8 = 12
+	MinPrecision     uint8 = 4
+	MaxPrecision     uint8 = 16
+)
+
+const binaryVersion byte = 1
+
+var (
+	ErrInvalidPrecision  = errors.New("hll precision must be between 4 and 16")
+	ErrInvalidRegisters  = errors.New("hll registers do not match precision")
+	ErrPrecisionMismatch = errors.New("hll precision mismatch")
+	ErrUnknownVersion    = errors.New("unknown hll binary version")
+)
+
+// Sketch is a dense HyperLogLog sketch.
+type Sketch struct {
+	Precision uint8  `json:"precision"` // number of index bits, registers count is 2^precision
+	Registers []byte `json:"registers"` // max rank per register, base64 encoded in json
+}
+
+// New returns an empty sketch, if precision is 0 DefaultPrecision is used.
+func New(precision uint8) (*Sketch, error) {
+	if precision == 0 {
+		precision = DefaultPrecision
+	}
+	if precision < MinPrecision || precision > MaxPrecision {
+		return nil, ErrInvalidPrecision
+	}
+	return &Sketch{
+		Precision: precision,
+		Registers: make([]byte, 1<<precision),
+	}, nil
+}
+
+// Add inserts value to the sketch.
+func (s *Sketch) Add(value []byte) {
+	h := hash(value)
+	idx := h >> (64 - s.Precision)
+	w := h<<s.Precision | 1<<(s.Precision-1)
+	rank := byte(bits.LeadingZeros64(w) + 1)
+	if rank > s.Registers[idx] {
+		s.Registers[idx] = rank
+	}
+}
+
+// AddString inserts string value to the sketch.
+func (s *Sketch) AddString(value string) {
+	s.Add([]byte(value))
+}
+
+// Estimate returns approximate number of distinct values added to the sketch.
+func (s *Sketch) Estimate() uint64 {
+	m := float64(len(s.Registers))
+	if m == 0 {
+		return 0
+	}
+
+	var sum float64
+	zeros := 0
+	for _, r := range s.Registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha(m) * m * m / sum
+
+	// small range correction with linear counting
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(estimate + 0.5)
+}
+
+// Merge combines other sketch into s, precisions must be equal.
+func (s *Sketch) Merge(other *Sketch) error {
+	if other == nil {
+		return nil
+	}
+	if s.Precision != other.Precision || len(s.Registers) != len(other.Registers) {
+		return ErrPrecisionMismatch
+	}
+	for i, r := range other.Registers {
+		if r > s.Registers[i] {
+			s.Registers[i] = r
+		}
+	}
+	return nil
+}
+
+// Clone returns a deep copy of sketch.
+func (s *Sketch) Clone() *Sketch {
+	if s == nil {
+		return nil
+	}
+	c := &Sketch{
+		Precision: s.Precision,
+		Registers: make([]byte, len(s.Registers)),
+	}
+	copy(c.Registers, s.Registers)
+	return c
+}
+
+// Validate checks precision and registers length.
+func (s *Sketch) Validate() error {
+	if s.Precision < MinPrecision || s.Precision > MaxPrecision {
+		return ErrInvalidPrecision
+	}
+	if len(s.Registers) != 1<<s.Precision {
+		return ErrInvalidRegisters
+	}
+	maxRank := byte(64 - s.Precision + 1)
+	for _, r := range s.Registers {
+		if r > maxRank {
+			return ErrInvalidRegisters
+		}
+	}
+	return nil
+}
+
+// MarshalBinary encodes sketch as version, precision and raw registers.
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	res := make([]byte, 0, len(s.Registers)+2)
+	res = append(res, binaryVersion, s.Precision)
+	return append(res, s.Registers...), nil
+}
+
+// UnmarshalBinary decodes sketch written by MarshalBinary.
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return ErrInvalidRegisters
+	}
+	if data[0] != binaryVersion {
+		return ErrUnknownVersion
+	}
+	s.Precision = data[1]
+	s.Registers = make([]byte, len(data)-2)
+	copy(s.Registers, data[2:])
+	return s.Validate()
+}
+
+func alpha(m float64) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/m)
+	}
+}
+
+// hash is 64-bit FNV-1a with murmur3 finalizer to spread bits for register index.
+func hash(value []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(value)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,91 @@
+package hll_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/pkg/hll"
+)
+
+func ExampleSketch_Estimate() {
+	s, _ := hll.New(hll.DefaultPrecision)
+	s.AddString("user-1")
+	s.AddString("user-2")
+	s.AddString("user-1")
+
+	fmt.Println(s.Estimate())
+	// Output: 2
+}
+
+func TestEstimate(t *testing.T) {
+	for _, n := range []int{100, 10000, 200000} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			s, err := hll.New(0)
+			require.NoError(t, err)
+
+			for i := 0; i < n; i++ {
+				s.AddString("user-" + strconv.Itoa(i))
+			}
+
+			assert.InEpsilon(t, n, s.Estimate(), 0.05)
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	t.Run("should merge without double counting", func(t *testing.T) {
+		s1, _ := hll.New(0)
+		s2, _ := hll.New(0)
+		for i := 0; i < 1000; i++ {
+			s1.AddString(strconv.Itoa(i))
+			s2.AddString(strconv.Itoa(i + 500))
+		}
+
+		require.NoError(t, s1.Merge(s2))
+		assert.InEpsilon(t, 1500, s1.Estimate(), 0.05)
+	})
+
+	t.Run("should fail on precision mismatch", func(t *testing.T) {
+		s1, _ := hll.New(10)
+		s2, _ := hll.New(12)
+
+		assert.ErrorIs(t, s1.Merge(s2), hll.ErrPrecisionMismatch)
+	})
+}
+
+func TestSerialization(t *testing.T) {
+	s, _ := hll.New(8)
+	s.AddString("a")
+	s.AddString("b")
+
+	t.Run("binary", func(t *testing.T) {
+		data, err := s.MarshalBinary()
+		require.NoError(t, err)
+
+		var restored hll.Sketch
+		require.NoError(t, restored.UnmarshalBinary(data))
+		assert.Equal(t, s, &restored)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		data, err := json.Marshal(s)
+		require.NoError(t, err)
+
+		var restored hll.Sketch
+		require.NoError(t, json.Unmarshal(data, &restored))
+		assert.Equal(t, s, &restored)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	_, err := hll.New(20)
+	assert.ErrorIs(t, err, hll.ErrInvalidPrecision)
+
+	s := hll.Sketch{Precision: 8, Registers: make([]byte, 10)}
+	assert.ErrorIs(t, s.Validate(), hll.ErrInvalidRegisters)
+}
@@ -0,0 +1,201 @@
+// Package metrics is an instrumentation library for Go services.
+//
+// Registry collects application counters, gauges and histograms and sends them
+// to the metrics server in batches, the same way as cmd/agent does.
+//
+// Usage:
+//
+//	reg := metrics.New(metrics.Config{Server: "http://localhost:8080"}, log)
+//	reg.Start(ctx)
+//	defer reg.Close()
+//
+//	requests := reg.Counter("requests")
+//	latency := reg.Histogram("latency", 0.01, 0.1, 1)
+//
+//	requests.Inc()
+//	latency.Observe(time.Since(start).Seconds())
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/benderr/metrics/internal/agent/apiclient"
+	"github.com/benderr/metrics/internal/agent/report"
+	"github.com/benderr/metrics/internal/agent/sender"
+	"github.com/benderr/metrics/internal/agent/sender/bulksender"
+	"github.com/benderr/metrics/internal/agent/stats"
+	"github.com/benderr/metrics/pkg/logger"
+)
+
+// DefaultReportInterval interval of sending metrics if Config.ReportInterval is not set.
+const DefaultReportInterval = 10 * time.Second
+
+// Config of registry.
+type Config struct {
+	Server         string        // адрес сервера, например http://localhost:8080
+	SecretKey      string        // ключ для подписи тела запроса
+	ReportInterval time.Duration // интервал отправки метрик на сервер
+	Retries        int           // количество повторов отправки при ошибке
+}
+
+// Registry collects metrics and sends them to server.
+type Registry struct {
+	report   *report.Report
+	sender   sender.MetricSender
+	interval time.Duration
+
+	flushMu sync.Mutex
+
+	mu   sync.Mutex
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// New returns registry, metrics are sent after Start or with explicit Flush.
+//
+// If log is nil, nothing is logged.
+func New(config Config, log logger.Logger) *Registry {
+	if log == nil {
+		log = zap.NewNop().Sugar()
+	}
+
+	client := apiclient.New(config.Server, config.SecretKey, log)
+	client.SetCustomRetries(config.Retries)
+	client.SetSignedHeader()
+
+	interval := config.ReportInterval
+	if interval <= 0 {
+		interval = DefaultReportInterval
+	}
+
+	return &Registry{
+		report:   report.New(),
+		sender:   bulksender.New(client, log),
+		interval: interval,
+	}
+}
+
+// Counter returns counter, added deltas are summed on server.
+func (r *Registry) Counter(name string) *Counter {
+	return &Counter{name: name, report: r.report}
+}
+
+// Gauge returns gauge, the last value is sent to server.
+func (r *Registry) Gauge(name string) *Gauge {
+	return &Gauge{name: name, report: r.report}
+}
+
+// Histogram returns histogram with bucket upper bounds, histogram.DefaultBounds are used if bounds are empty.
+func (r *Registry) Histogram(name string, bounds ...float64) *Histogram {
+	return &Histogram{name: name, bounds: bounds, report: r.report}
+}
+
+// Start sends metrics to server with report interval until ctx is done or Close is called.
+//
+// On stop the collected metrics are flushed.
+func (r *Registry) Start(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stop != nil {
+		return
+	}
+
+	ctx, r.stop = context.WithCancel(ctx)
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+
+		t := time.NewTicker(r.interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				r.Flush()
+				return
+			case <-t.C:
+				r.Flush()
+			}
+		}
+	}()
+}
+
+// Flush sends collected metrics to server.
+//
+// If sending fails, metrics are kept in registry and sent with next flush.
+func (r *Registry) Flush() error {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+
+	metrics := r.report.Flush()
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	err := r.sender.Send(metrics)
+	if err != nil {
+		r.report.Restore(metrics)
+	}
+	return err
+}
+
+// Close stops background sending and flushes collected metrics.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	stop, done := r.stop, r.done
+	r.mu.Unlock()
+
+	if stop == nil {
+		return r.Flush()
+	}
+
+	stop()
+	<-done
+	// метрики, не отправленные при остановке, пробуем отправить еще раз
+	return r.Flush()
+}
+
+// Counter is a monotonic metric, e.g. count of requests.
+type Counter struct {
+	name   string
+	report *report.Report
+}
+
+// Add adds delta to counter.
+func (c *Counter) Add(delta int64) {
+	c.report.Update([]stats.Item{{Name: c.name, Type: "counter", Delta: delta}})
+}
+
+// Inc increments counter.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Gauge is a metric with arbitrary value, e.g. size of queue.
+type Gauge struct {
+	name   string
+	report *report.Report
+}
+
+// Set sets gauge value.
+func (g *Gauge) Set(value float64) {
+	g.report.Update([]stats.Item{{Name: g.name, Type: "gauge", Value: value}})
+}
+
+// Histogram is a distribution of values, e.g. request duration.
+type Histogram struct {
+	name   string
+	bounds []float64
+	report *report.Report
+}
+
+// Observe adds value to histogram.
+func (h *Histogram) Observe(value float64) {
+	h.report.Observe(h.name, h.bounds, value)
+}
@@ -0,0 +1,114 @@
+package metrics_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/benderr/metrics/internal/server/handlers"
+	"github.com/benderr/metrics/internal/server/repository"
+	"github.com/benderr/metrics/internal/server/repository/inmemory"
+	"github.com/benderr/metrics/pkg/gziper"
+	"github.com/benderr/metrics/pkg/metrics"
+)
+
+func newServer(t *testing.T, fail *atomic.Bool) (*httptest.Server, repository.MetricRepository) {
+	t.Helper()
+
+	repo := inmemory.NewFast()
+	h := handlers.New(repo, zap.NewNop().Sugar(), "")
+	g := gziper.New(1, "application/json", "text/html")
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fail.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Use(g.TransformWriter)
+	r.Use(g.TransformReader)
+	h.AddHandlers(r)
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server, repo
+}
+
+func TestRegistryFlush(t *testing.T) {
+	var fail atomic.Bool
+	server, repo := newServer(t, &fail)
+	ctx := context.Background()
+
+	reg := metrics.New(metrics.Config{Server: server.URL}, nil)
+
+	requests := reg.Counter("requests")
+	load := reg.Gauge("load")
+	latency := reg.Histogram("latency", 0.1, 1)
+
+	requests.Inc()
+	requests.Add(2)
+	load.Set(0.5)
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+
+	require.NoError(t, reg.Flush())
+
+	m, err := repo.Get(ctx, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), *m.Delta)
+
+	m, err = repo.Get(ctx, "latency")
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 1, 0}, m.Histogram.Counts)
+
+	t.Run("Counter is sent once", func(t *testing.T) {
+		require.NoError(t, reg.Flush())
+
+		m, err := repo.Get(ctx, "requests")
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), *m.Delta)
+	})
+
+	t.Run("Unsent metrics are kept", func(t *testing.T) {
+		fail.Store(true)
+		requests.Inc()
+		assert.Error(t, reg.Flush())
+
+		fail.Store(false)
+		requests.Inc()
+		require.NoError(t, reg.Flush())
+
+		m, err := repo.Get(ctx, "requests")
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), *m.Delta)
+	})
+}
+
+func TestRegistryClose(t *testing.T) {
+	var fail atomic.Bool
+	server, repo := newServer(t, &fail)
+
+	reg := metrics.New(metrics.Config{Server: server.URL, ReportInterval: time.Hour}, nil)
+	reg.Start(context.Background())
+
+	reg.Gauge("load").Set(1.5)
+
+	require.NoError(t, reg.Close())
+
+	m, err := repo.Get(context.Background(), "load")
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	assert.Equal(t, 1.5, *m.Value)
+}
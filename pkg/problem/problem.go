@@ -0,0 +1,110 @@
+// Package problem contains error model of the metrics API in RFC 7807 format (application/problem+json).
+//
+// Server writes problems with Write, clients parse responses with Parse
+// and can use *Problem as an error.
+package problem
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ContentType is media type of problem response.
+const ContentType = "application/problem+json"
+
+// Machine-readable problem codes.
+const (
+	CodeBadRequest   = "bad_request"   // request body can't be read or decoded
+	CodeInvalidType  = "invalid_type"  // unknown metric type
+	CodeMissingValue = "missing_value" // value matching metric type is not specified
+	CodeInvalidValue = "invalid_value" // value can't be parsed or is not finite
+	CodeInvalidName  = "invalid_name"  // metric ID is empty or contains forbidden characters
+	CodeTypeConflict = "type_conflict" // metric exists with another type or incompatible sketch
+	CodeInvalidQuery = "invalid_query" // list query parameters are invalid
+	CodeInvalidOp    = "invalid_op"    // update operation is not add or set
+	CodeNotFound     = "not_found"     // metric or route not found
+	CodeInvalidSign  = "invalid_sign"  // HashSHA256 header does not match body
+	CodeUnavailable  = "unavailable"   // storage is not available
+	CodeTooLarge     = "too_large"     // request body exceeds size limit
+	CodeInternal     = "internal"      // unexpected server error, details are logged on server
+)
+
+// Problem is a problem details object.
+type Problem struct {
+	Type     string `json:"type"`                // URI reference identifying the problem type
+	Title    string `json:"title"`               // short summary of the problem type
+	Status   int    `json:"status"`              // http status code
+	Detail   string `json:"detail,omitempty"`    // human-readable explanation
+	Instance string `json:"instance,omitempty"`  // request URI
+	Code     string `json:"code"`                // machine-readable problem code
+	MetricID string `json:"metric_id,omitempty"` // offending metric ID
+	Errors   []Item `json:"errors,omitempty"`    // failed items of bulk request
+}
+
+// Item describes failed item of bulk request.
+type Item struct {
+	Index    int    `json:"index"`     // position of item in request
+	MetricID string `json:"metric_id"` // metric ID of item
+	Code     string `json:"code"`      // machine-readable problem code
+	Detail   string `json:"detail"`    // human-readable explanation
+}
+
+// New returns problem with title matching status.
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+// Error implements error interface, so problem can be returned by clients.
+func (p *Problem) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d %s", p.Status, p.Code)
+	if p.Detail != "" {
+		fmt.Fprintf(&b, ": %s", p.Detail)
+	}
+	if p.MetricID != "" {
+		fmt.Fprintf(&b, " (metric %s)", p.MetricID)
+	}
+	for _, item := range p.Errors {
+		fmt.Fprintf(&b, "; [%d] %s %s: %s", item.Index, item.MetricID, item.Code, item.Detail)
+	}
+	return b.String()
+}
+
+// Write writes problem to response with application/problem+json content type.
+func Write(w http.ResponseWriter, p *Problem) error {
+	res, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_, err = w.Write(res)
+	return err
+}
+
+// Parse decodes problem from response with problem content type.
+//
+// If content type is not application/problem+json or body is invalid, false is returned.
+func Parse(contentType string, body []byte) (*Problem, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != ContentType {
+		return nil, false
+	}
+
+	var p Problem
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, false
+	}
+	return &p, true
+}
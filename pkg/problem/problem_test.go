@@ -0,0 +1,42 @@
+package problem_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benderr/metrics/pkg/problem"
+)
+
+func TestWriteAndParse(t *testing.T) {
+	p := problem.New(http.StatusConflict, problem.CodeTypeConflict, "metric type conflict")
+	p.Errors = []problem.Item{
+		{Index: 1, MetricID: "PollCount", Code: problem.CodeTypeConflict, Detail: "PollCount is counter"},
+	}
+
+	rw := httptest.NewRecorder()
+	require.NoError(t, problem.Write(rw, p))
+
+	assert.Equal(t, http.StatusConflict, rw.Code)
+	assert.Equal(t, problem.ContentType, rw.Header().Get("Content-Type"))
+
+	parsed, ok := problem.Parse(rw.Header().Get("Content-Type"), rw.Body.Bytes())
+	require.True(t, ok)
+	assert.Equal(t, p, parsed)
+	assert.Equal(t, "Conflict", parsed.Title)
+}
+
+func TestParseOtherContentType(t *testing.T) {
+	_, ok := problem.Parse("text/plain; charset=utf-8", []byte("not found"))
+	assert.False(t, ok)
+}
+
+func TestError(t *testing.T) {
+	p := problem.New(http.StatusBadRequest, problem.CodeMissingValue, "counter requires delta")
+	p.MetricID = "PollCount"
+
+	assert.Equal(t, "400 missing_value: counter requires delta (metric PollCount)", p.Error())
+}
@@ -15,43 +15,808 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/": {
+            "get": {
+                "produces": [
+                    "text/html"
+                ],
+                "tags": [
+                    "dashboard"
+                ],
+                "summary": "Metrics dashboard",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID prefix",
+                        "name": "prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID pattern, e.g. cpu.*",
+                        "name": "glob",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID regular expression",
+                        "name": "regex",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "metric types",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size, default 500, max 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "cursor of next page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "html page",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid query",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/admin/db": {
+            "get": {
+                "description": "Available for database storage only.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Connection pool statistics",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/repository.PoolStats"
+                        }
+                    },
+                    "404": {
+                        "description": "Storage has no connection pool",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/history": {
+            "get": {
+                "description": "Values are numeric: gauge value, counter delta, histogram mean, summary median and set distinct count.\nHistory is kept in memory and is empty after restart.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "v1"
+                ],
+                "summary": "Metrics history",
+                "parameters": [
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "metric IDs, all metrics if empty",
+                        "name": "id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "$ref": "#/definitions/history.Series"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/metrics": {
+            "get": {
+                "description": "Metrics are ordered by ID, filters are combined with AND.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "v1"
+                ],
+                "summary": "List metrics",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID prefix",
+                        "name": "prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID pattern, e.g. cpu.*",
+                        "name": "glob",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID regular expression",
+                        "name": "regex",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "metric types",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "asc",
+                            "desc"
+                        ],
+                        "type": "string",
+                        "description": "order by ID",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size, default 100, max 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "cursor from Link header of previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/repository.Metrics"
+                            }
+                        },
+                        "headers": {
+                            "Link": {
+                                "type": "string",
+                                "description": "URL of next page with rel=next"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid query",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/metrics/{id}": {
+            "get": {
+                "description": "For summary metric quantiles are calculated, by default 0.5, 0.9 and 0.99.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "v1"
+                ],
+                "summary": "Get metric",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "metric ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "number"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "quantiles for summary metric",
+                        "name": "q",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "metric, quantiles are present for summary only",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.summaryDto"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid quantile",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Metric not found",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Gauge value is overwritten, counter delta is added, histogram, summary and set are merged.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "v1"
+                ],
+                "summary": "Create/update metric",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "metric ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "metric, id can be omitted",
+                        "name": "metric",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/repository.Metrics"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/repository.Metrics"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request, invalid name, type or value",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Metric exists with another type",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/metrics:batch": {
+            "post": {
+                "description": "Items are validated before update, if some items are invalid nothing is updated\nand the problem contains errors with item indexes.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "v1"
+                ],
+                "summary": "Batch update",
+                "parameters": [
+                    {
+                        "description": "metrics",
+                        "name": "metrics",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/repository.Metrics"
+                            }
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "unique key of batch, repeated batch with the same key is not applied",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "headers": {
+                            "Idempotent-Replayed": {
+                                "type": "string",
+                                "description": "true if batch with the same key is already applied"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid items",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Items conflict with stored metrics",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/export": {
+            "get": {
+                "description": "ndjson has one metric per line in file storage format with updated_at field.\ncsv has columns id, type, value, updated_at, where value is numeric: gauge value, counter delta,\nhistogram mean, summary median or set distinct count.\nWith history=true ndjson lines have history field and csv has one row per point with columns id, type, time, value.",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "export"
+                ],
+                "summary": "Export metrics",
+                "parameters": [
+                    {
+                        "enum": [
+                            "csv",
+                            "ndjson"
+                        ],
+                        "type": "string",
+                        "description": "file format, default ndjson",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "include history of values since server start",
+                        "name": "history",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID prefix",
+                        "name": "prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID pattern, e.g. cpu.*",
+                        "name": "glob",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID regular expression",
+                        "name": "regex",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "metric types",
+                        "name": "type",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "file",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid format or query",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/import": {
+            "post": {
+                "description": "Body is ndjson of /export or file storage snapshot (json or binary). Extra fields of /export (updated_at, history) are ignored.",
+                "consumes": [
+                    "application/x-ndjson",
+                    "application/octet-stream"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "export"
+                ],
+                "summary": "Import metrics",
+                "parameters": [
+                    {
+                        "enum": [
+                            "merge",
+                            "replace"
+                        ],
+                        "type": "string",
+                        "description": "import mode, default merge",
+                        "name": "mode",
+                        "in": "query"
+                    },
+                    {
+                        "description": "metric per line",
+                        "name": "metrics",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/repository.Metrics"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.importResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid mode, line or items",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Items conflict with stored metrics",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "413": {
+                        "description": "Body is larger than 64 MiB",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/ping": {
+            "get": {
+                "tags": [
+                    "legacy"
+                ],
+                "summary": "Ping storage",
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "500": {
+                        "description": "Storage is unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/stream": {
+            "get": {
+                "description": "Each event has type \"metric\" and metric json as data. If client reads too slowly the stream is closed,\nEventSource reconnects and receives current values again.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "stream"
+                ],
+                "summary": "Stream metric changes",
+                "parameters": [
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "metric IDs",
+                        "name": "id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "metric ID prefixes",
+                        "name": "prefix",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "event data",
+                        "schema": {
+                            "$ref": "#/definitions/repository.Metrics"
+                        }
+                    },
+                    "500": {
+                        "description": "Streaming is not supported",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Server is shutting down",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
         "/update": {
             "post": {
-                "description": "Create/update metric",
+                "description": "Create/update metric",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "legacy"
+                ],
+                "summary": "Update metric",
+                "parameters": [
+                    {
+                        "description": "metric",
+                        "name": "metric",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/repository.Metrics"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/repository.Metrics"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request, invalid name, type or value",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Metric exists with another type",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/update/{type}/{name}/{value}": {
+            "post": {
+                "description": "Counter and gauge value is a number, histogram and summary value is an observation, set value is a member.",
+                "tags": [
+                    "legacy"
+                ],
+                "summary": "Update metric by URL",
                 "parameters": [
                     {
-                        "description": "metric ID and MType",
-                        "name": "metric",
+                        "enum": [
+                            "gauge",
+                            "counter",
+                            "histogram",
+                            "summary",
+                            "set"
+                        ],
+                        "type": "string",
+                        "description": "metric type",
+                        "name": "type",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "metric ID",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "metric value",
+                        "name": "value",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "add",
+                            "set"
+                        ],
+                        "type": "string",
+                        "description": "update operation, default add",
+                        "name": "op",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Invalid name, type, value or operation",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Metric exists with another type",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/updates/": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "legacy"
+                ],
+                "summary": "Batch update",
+                "parameters": [
+                    {
+                        "description": "metrics",
+                        "name": "metrics",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/handlers.metricsDto"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/repository.Metrics"
+                            }
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "unique key of batch, repeated batch with the same key is not applied",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/repository.Metrics"
+                        "headers": {
+                            "Idempotent-Replayed": {
+                                "type": "string",
+                                "description": "true if batch with the same key is already applied"
+                            }
                         }
                     },
                     "400": {
-                        "description": "Bad request, id not specified",
+                        "description": "Invalid items",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Problem"
                         }
                     },
-                    "404": {
-                        "description": "Metric not found",
+                    "409": {
+                        "description": "Items conflict with stored metrics",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Problem"
                         }
                     },
                     "500": {
                         "description": "Internal error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Problem"
                         }
                     }
                 }
@@ -60,6 +825,16 @@ const docTemplate = `{
         "/value": {
             "post": {
                 "description": "Fetch metric info",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "legacy"
+                ],
+                "summary": "Get metric",
                 "parameters": [
                     {
                         "description": "metric ID and MType",
@@ -73,34 +848,156 @@ const docTemplate = `{
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "metric, quantiles are present for summary only",
                         "schema": {
-                            "$ref": "#/definitions/repository.Metrics"
+                            "$ref": "#/definitions/handlers.summaryDto"
                         }
                     },
                     "400": {
                         "description": "Bad request, id not specified",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Problem"
                         }
                     },
                     "404": {
                         "description": "Metric not found",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Problem"
                         }
                     },
                     "500": {
                         "description": "Internal error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/value/{type}/{name}": {
+            "get": {
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "legacy"
+                ],
+                "summary": "Get metric value by URL",
+                "parameters": [
+                    {
+                        "enum": [
+                            "gauge",
+                            "counter",
+                            "histogram",
+                            "summary",
+                            "set"
+                        ],
+                        "type": "string",
+                        "description": "metric type",
+                        "name": "type",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "metric ID",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "quantile for summary metric",
+                        "name": "q",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "metric value",
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "400": {
+                        "description": "Invalid type or quantile",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Metric not found",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
                     }
                 }
             }
         }
     },
     "definitions": {
+        "ddsketch.Sketch": {
+            "type": "object",
+            "properties": {
+                "alpha": {
+                    "description": "relative accuracy of quantiles",
+                    "type": "number"
+                },
+                "count": {
+                    "description": "number of values",
+                    "type": "integer"
+                },
+                "max": {
+                    "description": "maximal value",
+                    "type": "number"
+                },
+                "min": {
+                    "description": "minimal value",
+                    "type": "number"
+                },
+                "negative": {
+                    "description": "bucket index =\u003e count for negative values (by magnitude)",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                },
+                "positive": {
+                    "description": "bucket index =\u003e count for positive values",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                },
+                "sum": {
+                    "description": "sum of values",
+                    "type": "number"
+                },
+                "zero": {
+                    "description": "count of values close to zero",
+                    "type": "integer"
+                }
+            }
+        },
+        "handlers.importResult": {
+            "type": "object",
+            "properties": {
+                "imported": {
+                    "description": "количество загруженных строк",
+                    "type": "integer"
+                },
+                "mode": {
+                    "description": "режим импорта",
+                    "type": "string"
+                }
+            }
+        },
         "handlers.metricsDto": {
             "description": "metrics dto for fetch full information",
             "type": "object",
@@ -109,8 +1006,205 @@ const docTemplate = `{
                     "description": "unique metric name",
                     "type": "string"
                 },
+                "quantiles": {
+                    "description": "quantiles to calculate for summary metric (default 0.5, 0.9, 0.99)",
+                    "type": "array",
+                    "items": {
+                        "type": "number"
+                    }
+                },
+                "type": {
+                    "description": "metric type enum gauge, counter, histogram, summary or set",
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.summaryDto": {
+            "description": "summary metric with calculated quantiles",
+            "type": "object",
+            "properties": {
+                "delta": {
+                    "description": "значение метрики в случае передачи counter",
+                    "type": "integer"
+                },
+                "histogram": {
+                    "description": "значение метрики в случае передачи histogram",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/histogram.Histogram"
+                        }
+                    ]
+                },
+                "id": {
+                    "description": "имя метрики",
+                    "type": "string"
+                },
+                "op": {
+                    "description": "операция обновления: add (по умолчанию) или set",
+                    "type": "string"
+                },
+                "quantiles": {
+                    "description": "percentile =\u003e value, e.g. p99",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "number"
+                    }
+                },
+                "set": {
+                    "description": "значение метрики в случае передачи set",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/hll.Sketch"
+                        }
+                    ]
+                },
+                "summary": {
+                    "description": "значение метрики в случае передачи summary",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/ddsketch.Sketch"
+                        }
+                    ]
+                },
+                "type": {
+                    "description": "параметр, принимающий значение gauge, counter, histogram, summary или set",
+                    "type": "string"
+                },
+                "value": {
+                    "description": "значение метрики в случае передачи gauge",
+                    "type": "number"
+                }
+            }
+        },
+        "histogram.Histogram": {
+            "type": "object",
+            "properties": {
+                "bounds": {
+                    "description": "upper bounds of buckets, sorted ascending",
+                    "type": "array",
+                    "items": {
+                        "type": "number"
+                    }
+                },
+                "count": {
+                    "description": "number of observations",
+                    "type": "integer"
+                },
+                "counts": {
+                    "description": "observations per bucket, last one is +Inf",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "sum": {
+                    "description": "sum of all observations",
+                    "type": "number"
+                }
+            }
+        },
+        "history.Point": {
+            "type": "object",
+            "properties": {
+                "t": {
+                    "description": "время обновления",
+                    "type": "string"
+                },
+                "v": {
+                    "description": "числовое значение метрики, см. Value",
+                    "type": "number"
+                }
+            }
+        },
+        "history.Series": {
+            "type": "object",
+            "properties": {
+                "points": {
+                    "description": "последние значения, от старых к новым",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/history.Point"
+                    }
+                },
+                "updated_at": {
+                    "description": "время последнего обновления",
+                    "type": "string"
+                }
+            }
+        },
+        "hll.Sketch": {
+            "type": "object",
+            "properties": {
+                "precision": {
+                    "description": "number of index bits, registers count is 2^precision",
+                    "type": "integer"
+                },
+                "registers": {
+                    "description": "max rank per register, base64 encoded in json",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "problem.Item": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "machine-readable problem code",
+                    "type": "string"
+                },
+                "detail": {
+                    "description": "human-readable explanation",
+                    "type": "string"
+                },
+                "index": {
+                    "description": "position of item in request",
+                    "type": "integer"
+                },
+                "metric_id": {
+                    "description": "metric ID of item",
+                    "type": "string"
+                }
+            }
+        },
+        "problem.Problem": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "machine-readable problem code",
+                    "type": "string"
+                },
+                "detail": {
+                    "description": "human-readable explanation",
+                    "type": "string"
+                },
+                "errors": {
+                    "description": "failed items of bulk request",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/problem.Item"
+                    }
+                },
+                "instance": {
+                    "description": "request URI",
+                    "type": "string"
+                },
+                "metric_id": {
+                    "description": "offending metric ID",
+                    "type": "string"
+                },
+                "status": {
+                    "description": "http status code",
+                    "type": "integer"
+                },
+                "title": {
+                    "description": "short summary of the problem type",
+                    "type": "string"
+                },
                 "type": {
-                    "description": "metric type enum gauge или counter",
+                    "description": "URI reference identifying the problem type",
                     "type": "string"
                 }
             }
@@ -122,12 +1216,40 @@ const docTemplate = `{
                     "description": "значение метрики в случае передачи counter",
                     "type": "integer"
                 },
+                "histogram": {
+                    "description": "значение метрики в случае передачи histogram",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/histogram.Histogram"
+                        }
+                    ]
+                },
                 "id": {
                     "description": "имя метрики",
                     "type": "string"
                 },
+                "op": {
+                    "description": "операция обновления: add (по умолчанию) или set",
+                    "type": "string"
+                },
+                "set": {
+                    "description": "значение метрики в случае передачи set",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/hll.Sketch"
+                        }
+                    ]
+                },
+                "summary": {
+                    "description": "значение метрики в случае передачи summary",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/ddsketch.Sketch"
+                        }
+                    ]
+                },
                 "type": {
-                    "description": "параметр, принимающий значение gauge или counter",
+                    "description": "параметр, принимающий значение gauge, counter, histogram, summary или set",
                     "type": "string"
                 },
                 "value": {
@@ -135,6 +1257,59 @@ const docTemplate = `{
                     "type": "number"
                 }
             }
+        },
+        "repository.PoolStats": {
+            "type": "object",
+            "properties": {
+                "acquire_count": {
+                    "description": "количество успешных получений соединения",
+                    "type": "integer"
+                },
+                "acquire_duration_ms": {
+                    "description": "суммарное время получения соединений",
+                    "type": "number"
+                },
+                "acquired_conns": {
+                    "description": "соединения, занятые запросами",
+                    "type": "integer"
+                },
+                "canceled_acquire_count": {
+                    "description": "получения соединения, отмененные контекстом",
+                    "type": "integer"
+                },
+                "constructing_conns": {
+                    "description": "устанавливаемые соединения",
+                    "type": "integer"
+                },
+                "empty_acquire_count": {
+                    "description": "получения соединения с ожиданием, пул был пуст",
+                    "type": "integer"
+                },
+                "idle_conns": {
+                    "description": "свободные соединения",
+                    "type": "integer"
+                },
+                "max_conns": {
+                    "description": "максимальный размер пула",
+                    "type": "integer"
+                },
+                "max_idle_destroyed": {
+                    "description": "соединения, закрытые по времени простоя",
+                    "type": "integer"
+                },
+                "max_lifetime_destroyed": {
+                    "description": "соединения, закрытые по времени жизни",
+                    "type": "integer"
+                },
+                "new_conns_count": {
+                    "description": "количество установленных соединений",
+                    "type": "integer"
+                },
+                "total_conns": {
+                    "description": "открытые соединения, включая устанавливаемые",
+                    "type": "integer"
+                }
+            }
         }
     }
 }`
@@ -146,7 +1321,7 @@ var SwaggerInfo = &swag.Spec{
 	BasePath:         "",
 	Schemes:          []string{},
 	Title:            "MetricStorage API",
-	Description:      "metrics dto for fetch full information",
+	Description:      "Metrics manager",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
 	LeftDelim:        "{{",